@@ -6,8 +6,11 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -107,9 +110,24 @@ func main() {
 
 	// Auto migrate only our session tracking table (skip Laravel tables)
 	db.AutoMigrate(&models.LiveTrackingSession{})
+	db.AutoMigrate(&models.AdminWebSession{})
+	// trips is owned by Laravel - only add the columns this service writes
+	tripColumns := []string{
+		"ClientDistanceKm", "ClientMaxSpeedKmh", "ClientAvgSpeedKmh", "ClientDurationSeconds", "StatsFlagged", "StatsFlagReason",
+		"TrackingDataKey",
+		"ElevationLossM", "MovingTimeSeconds", "StoppedTimeSeconds", "AvgMovingSpeedKmh", "StopCount",
+	}
+	for _, column := range tripColumns {
+		if !db.Migrator().HasColumn(&models.Trip{}, column) {
+			if err := db.Migrator().AddColumn(&models.Trip{}, column); err != nil {
+				log.Printf("Failed to add trips column %s: %v", column, err)
+			}
+		}
+	}
 
 	// Initialize Redis client for live tracking performance
 	var redisClient *redis.Client
+	var redisMonitor *handlers.RedisHealthMonitor
 	if cfg.RedisEnabled {
 		redisClient = redis.NewClient(&redis.Options{
 			Addr:     fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort),
@@ -117,15 +135,16 @@ func main() {
 			DB:       cfg.RedisDB, // Use separate DB from Laravel
 		})
 		
-		// Test Redis connection
-		ctx := context.Background()
-		pong, err := redisClient.Ping(ctx).Result()
-		if err != nil {
-			log.Printf("WARNING: Redis connection failed: %v", err)
-			log.Printf("WARNING: Falling back to MySQL-only mode")
-			redisClient = nil
-		} else {
-			fmt.Printf("INFO: Redis connected successfully: %s (DB: %d)\n", pong, cfg.RedisDB)
+		// Watch Redis health - handlers fall back to MySQL/S3 while it's down and switch back once it recovers
+		redisMonitor = handlers.NewRedisHealthMonitor(
+			redisClient,
+			time.Duration(cfg.RedisHealthIntervalSeconds)*time.Second,
+			cfg.RedisFailureThreshold,
+			cfg.RedisRecoveryThreshold,
+		)
+		redisMonitor.Start()
+		if redisMonitor.Healthy() {
+			fmt.Printf("INFO: Redis connected successfully (DB: %d)\n", cfg.RedisDB)
 		}
 	} else {
 		fmt.Printf("INFO: Redis disabled via configuration\n")
@@ -139,31 +158,111 @@ func main() {
 		cfg.S3Bucket,
 		cfg.S3Endpoint,
 	)
+	if err := s3Client.SetCompression(cfg.S3Compression); err != nil {
+		fmt.Printf("WARNING: %v, storing uncompressed JSON\n", err)
+	}
+	// Convert existing train files to the configured compression in the background
+	if cfg.S3CompressionMigrate {
+		go func() {
+			if _, err := s3Client.MigrateCompression("trains/"); err != nil {
+				fmt.Printf("ERROR: S3 compression migration failed: %v\n", err)
+			}
+		}()
+	}
+
+	// Live tracking state store - Redis when available, S3 train files otherwise
+	var liveStore handlers.LiveStore
+	switch cfg.LiveStore {
+	case "memory":
+		liveStore = handlers.NewMemoryLiveStore()
+		fmt.Printf("INFO: Using in-memory live store (single instance only)\n")
+	case "s3":
+		liveStore = handlers.NewS3LiveStore(s3Client)
+		fmt.Printf("INFO: Using S3 live store (legacy train files)\n")
+	default:
+		if cfg.LiveStore != "redis" {
+			fmt.Printf("WARNING: Unknown live store %s, using redis\n", cfg.LiveStore)
+		}
+		if redisMonitor != nil {
+			liveStore = handlers.NewFailoverLiveStore(redisMonitor, handlers.NewS3LiveStore(s3Client))
+			fmt.Printf("INFO: Using Redis live store (S3 train files while Redis is unhealthy)\n")
+		} else {
+			liveStore = handlers.NewS3LiveStore(s3Client)
+			fmt.Printf("WARNING: Redis disabled, using S3 live store (legacy train files)\n")
+		}
+	}
+
+	// Timetable times are local to the railway, not the server
+	if err := handlers.SetScheduleTimezone(cfg.ScheduleTimezone); err != nil {
+		fmt.Printf("WARNING: Unknown schedule timezone %s, using server timezone: %v\n", cfg.ScheduleTimezone, err)
+	}
+
+	// Background workers run until the server shuts down
+	serverCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
 
 	// Initialize handlers and middleware
 	authMiddleware := middleware.NewAuthMiddleware(db)
-	// Initialize live tracking handler with Redis support (falls back to MySQL if Redis unavailable)
+	// Initialize live tracking handler on the configured live store
 	liveTrackingHandler := handlers.NewSimpleLiveTrackingHandler(db, s3Client)
-	// Set Redis client for live tracking performance (if available)
-	if redisClient != nil {
-		liveTrackingHandler.SetRedisClient(redisClient)
+	liveTrackingHandler.SetLiveStore(liveStore)
+	// Expire sessions whose app stopped sending heartbeats
+	if cfg.SessionReaperEnabled {
+		liveTrackingHandler.StartSessionReaper(
+			serverCtx,
+			time.Duration(cfg.SessionExpiryMinutes)*time.Minute,
+			time.Duration(cfg.SessionReaperIntervalSeconds)*time.Second,
+			cfg.SessionReaperAutoSaveTrip,
+		)
+	}
+	// Simplified trip routes, full tracking data optionally in S3
+	liveTrackingHandler.SetTripStorage(float64(cfg.TripRouteToleranceMeters), cfg.TripTrackingDataInS3)
+	// Archive train snapshots and passenger points for history
+	var trainArchiver *handlers.TrainArchiver
+	if cfg.ArchiveEnabled {
+		trainArchiver = handlers.NewTrainArchiver(
+			s3Client,
+			time.Duration(cfg.ArchiveFlushIntervalSeconds)*time.Second,
+			time.Duration(cfg.ArchiveRetentionDays)*24*time.Hour,
+		)
+		trainArchiver.Start(serverCtx)
+		liveTrackingHandler.SetArchiver(trainArchiver)
 	}
 	// Initialize WebSocket handler for real-time updates
 	wsHandler := handlers.NewWebSocketHandler(db, s3Client)
-	// Enable Redis for real-time WebSocket data (Redis-first, S3 fallback)
-	if redisClient != nil {
-		wsHandler.SetRedisClient(redisClient)
+	// Real-time WebSocket data from the live store (S3 fallback)
+	wsHandler.SetLiveStore(liveStore)
+	// Replay of archived train history
+	if trainArchiver != nil {
+		wsHandler.SetArchiver(trainArchiver)
 	}
 	// Initialize API endpoints handler
 	apiEndpointsHandler := handlers.NewAPIEndpointsHandler(db, redisClient)
+	apiEndpointsHandler.SetS3Client(s3Client)
+	apiEndpointsHandler.SetLiveStore(liveStore)
+	if redisMonitor != nil {
+		apiEndpointsHandler.SetRedisHealthMonitor(redisMonitor)
+	}
 	// Initialize tile proxy handler for CartoDB tiles
 	tileProxyHandler := handlers.NewTileProxyHandler()
 	// Initialize admin handler for session management
 	adminHandler := handlers.NewAdminHandler(db)
 	// Initialize web admin handler for dashboard
 	webAdminHandler := handlers.NewWebAdminHandler(db)
+	webAdminHandler.SetSessionLifetime(
+		time.Duration(cfg.AdminSessionTTLHours)*time.Hour,
+		time.Duration(cfg.AdminSessionMaxAgeDays)*24*time.Hour,
+	)
+	webAdminHandler.StartSessionCleanup(time.Hour)
 	// Initialize spotter location handler for map user presence
-	spotterHandler := handlers.NewSpotterHandler(db, redisClient)
+	spotterHandler := handlers.NewSpotterHandler(db, liveStore)
+
+	// Start or stop live store background workers when Redis goes down or comes back
+	if redisMonitor != nil {
+		redisMonitor.OnChange(func(healthy bool) {
+			liveTrackingHandler.RefreshLiveStoreMode()
+		})
+	}
 
 	// Setup routes
 	r := gin.Default()
@@ -202,10 +301,19 @@ func main() {
 		
 		randomQuote := quotes[rand.Intn(len(quotes))]
 		
+		// Current live tracking mode - the live store switches to S3 while Redis is unhealthy
+		redisStatus := map[string]interface{}{"enabled": false}
+		if redisMonitor != nil {
+			redisStatus = redisMonitor.Status()
+			redisStatus["enabled"] = true
+		}
+		
 		c.JSON(200, gin.H{
 			"status": "ok",
 			"service": "golang-live-tracking",
 			"quote": randomQuote,
+			"live_store": liveStore.Name(),
+			"redis": redisStatus,
 		})
 	})
 
@@ -230,7 +338,8 @@ func main() {
 	})
 
 	// WebSocket endpoint for real-time train updates
-	r.GET("/ws/trains", wsHandler.HandleWebSocket)
+	// Optional token: anonymous clients get live updates, admins may also replay history
+	r.GET("/ws/trains", authMiddleware.OptionalSanctumAuth(), wsHandler.HandleWebSocket)
 	
 	// Web Admin Interface
 	adminWeb := r.Group("/admin")
@@ -244,10 +353,13 @@ func main() {
 		adminWeb.Use(webAdminHandler.RequireAdminSession())
 		adminWeb.GET("/", func(c *gin.Context) { c.Redirect(http.StatusFound, "/admin/dashboard") })
 		adminWeb.GET("/dashboard", webAdminHandler.ShowDashboard)
+		adminWeb.POST("/logout-all", webAdminHandler.HandleLogoutAll)
 		
 		// Web Admin API endpoints (use session authentication)
 		adminWeb.GET("/api/sessions", webAdminHandler.GetSessionsWeb)
 		adminWeb.POST("/api/sessions/terminate/:session_id", webAdminHandler.TerminateSessionWeb)
+		adminWeb.GET("/api/admin-sessions", webAdminHandler.GetAdminSessionsWeb)
+		adminWeb.POST("/api/admin-sessions/revoke/:id", webAdminHandler.RevokeAdminSessionWeb)
 	}
 
 	// API routes
@@ -256,6 +368,8 @@ func main() {
 		// Public endpoints for train data (replace direct S3 access)
 		api.GET("/active-train-list", liveTrackingHandler.GetActiveTrainsList)
 		api.GET("/train/:trainNumber", liveTrackingHandler.GetTrainData)
+		api.GET("/train/:trainNumber/history", authMiddleware.SanctumAuth(), liveTrackingHandler.GetTrainHistory)
+		api.GET("/trains/nearby", liveTrackingHandler.GetNearbyTrains)
 		
 		// Tile proxy endpoints for CartoDB maps (bypass blocking)
 		api.GET("/tiles/:style/:z/:x/:y", tileProxyHandler.ProxyCartoDB)
@@ -436,10 +550,21 @@ func main() {
 				liveTracking.GET("/active-session", liveTrackingHandler.GetActiveSession)
 				liveTracking.POST("/start", liveTrackingHandler.StartMobileSession)
 				liveTracking.POST("/update", liveTrackingHandler.UpdateMobileLocation)
+				liveTracking.POST("/update-batch", liveTrackingHandler.UpdateMobileLocationBatch)
 				liveTracking.POST("/heartbeat", liveTrackingHandler.Heartbeat)
 				liveTracking.POST("/recover", liveTrackingHandler.RecoverSession)
 				liveTracking.POST("/stop", liveTrackingHandler.StopMobileSession)
 			}
+			
+			// Saved trips of the authenticated user
+			trips := mobile.Group("/trips")
+			trips.Use(authMiddleware.SanctumAuth())
+			{
+				trips.GET("", liveTrackingHandler.GetUserTrips)
+				trips.GET("/:id", liveTrackingHandler.GetUserTrip)
+				trips.GET("/:id/export", liveTrackingHandler.ExportUserTrip)
+				trips.DELETE("/:id", liveTrackingHandler.DeleteUserTrip)
+			}
 		}
 		
 		// Spotter location routes for map user presence
@@ -451,6 +576,8 @@ func main() {
 			// - Public users: filtered results respecting privacy settings
 			// - Admin users: full unfiltered results with all data
 			spotters.GET("/active", spotterHandler.GetActiveSpotters)
+			// Spotters around a point, nearest first (same privacy filtering as /active)
+			spotters.GET("/nearby", spotterHandler.GetNearbySpotters)
 		}
 	}
 
@@ -459,7 +586,34 @@ func main() {
 	log.Printf("☁️  S3: %s/%s", cfg.S3Endpoint, cfg.S3Bucket)
 	log.Printf("⚡ Mode: S3-enabled but Redis-free implementation")
 
-	if err := r.Run(":" + cfg.Port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: r,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// On SIGINT/SIGTERM finish in-flight requests, stop the background workers and write what
+	// the archive still buffers, so a deploy doesn't lose history
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Printf("Shutting down server...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown did not finish cleanly: %v", err)
+	}
+
+	stopWorkers()
+	liveTrackingHandler.StopLiveWorkers()
+	wsHandler.StopLiveEvents()
+	if trainArchiver != nil {
+		trainArchiver.Flush()
 	}
+	log.Printf("Server stopped")
 }
\ No newline at end of file
@@ -20,6 +20,14 @@ type Config struct {
 	RedisPassword string
 	RedisDB       int
 	RedisEnabled  bool
+
+	// Redis health monitor (circuit breaker)
+	RedisHealthIntervalSeconds int
+	RedisFailureThreshold      int // failed pings in a row before switching to fallback mode
+	RedisRecoveryThreshold     int // successful pings in a row before switching back
+
+	// Live tracking state store: redis, s3 (legacy train files) or memory (single instance)
+	LiveStore string
 	
 	// App Version
 	CurrentVersion string
@@ -33,12 +41,38 @@ type Config struct {
 	LaravelAppKey     string
 	SanctumTokenPrefix string
 
+	// Timezone of the timetable's arrival/departure times
+	ScheduleTimezone string
+
+	// Stale session reaper
+	SessionReaperEnabled         bool
+	SessionExpiryMinutes         int
+	SessionReaperIntervalSeconds int
+	SessionReaperAutoSaveTrip    bool
+
+	// Saved trips
+	TripRouteToleranceMeters int  // route_coordinates simplification, 0 keeps every point
+	TripTrackingDataInS3     bool // store full tracking_data in S3 instead of MySQL
+
+	// Web admin sessions (MySQL)
+	AdminSessionTTLHours   int // idle lifetime, renewed on every request
+	AdminSessionMaxAgeDays int // absolute lifetime after login
+
+	// Historical train archive (gzip NDJSON in S3)
+	ArchiveEnabled              bool
+	ArchiveFlushIntervalSeconds int
+	ArchiveRetentionDays        int // 0 keeps archives forever
+
 	// S3
 	S3AccessKey string
 	S3SecretKey string
 	S3Region    string
 	S3Bucket    string
 	S3Endpoint  string
+
+	// Compression of JSON objects in S3 (none or gzip); migrate rewrites existing train files
+	S3Compression        string
+	S3CompressionMigrate bool
 }
 
 func LoadConfig() *Config {
@@ -55,17 +89,35 @@ func LoadConfig() *Config {
 		RedisPassword:     getEnv("REDIS_PASSWORD", ""),
 		RedisDB:           getEnvAsInt("REDIS_DB", 1),
 		RedisEnabled:      getEnvAsBool("REDIS_ENABLED", true),
+		RedisHealthIntervalSeconds: getEnvAsInt("REDIS_HEALTH_INTERVAL_SECONDS", 5),
+		RedisFailureThreshold:      getEnvAsInt("REDIS_FAILURE_THRESHOLD", 3),
+		RedisRecoveryThreshold:     getEnvAsInt("REDIS_RECOVERY_THRESHOLD", 2),
+		LiveStore:         getEnv("LIVE_STORE", "redis"),
 		Port:              getEnv("PORT", "8080"),
 		GinMode:           getEnv("GIN_MODE", "debug"),
 		CurrentVersion:    getEnv("APP_CURRENT_VERSION", "1.2.0"),
 		MinimumVersion:    getEnv("APP_MINIMUM_VERSION", "1.1.0"),
 		LaravelAppKey:     getEnv("LARAVEL_APP_KEY", ""),
 		SanctumTokenPrefix: getEnv("SANCTUM_TOKEN_PREFIX", ""),
+		ScheduleTimezone:  getEnv("SCHEDULE_TIMEZONE", "Asia/Jakarta"),
+		SessionReaperEnabled:         getEnvAsBool("SESSION_REAPER_ENABLED", false),
+		SessionExpiryMinutes:         getEnvAsInt("SESSION_EXPIRY_MINUTES", 10),
+		SessionReaperIntervalSeconds: getEnvAsInt("SESSION_REAPER_INTERVAL_SECONDS", 60),
+		SessionReaperAutoSaveTrip:    getEnvAsBool("SESSION_REAPER_AUTO_SAVE_TRIP", false),
+		TripRouteToleranceMeters:     getEnvAsInt("TRIP_ROUTE_TOLERANCE_METERS", 10),
+		TripTrackingDataInS3:         getEnvAsBool("TRIP_TRACKING_DATA_IN_S3", false),
+		AdminSessionTTLHours:         getEnvAsInt("ADMIN_SESSION_TTL_HOURS", 24),
+		AdminSessionMaxAgeDays:       getEnvAsInt("ADMIN_SESSION_MAX_AGE_DAYS", 7),
+		ArchiveEnabled:               getEnvAsBool("ARCHIVE_ENABLED", true),
+		ArchiveFlushIntervalSeconds:  getEnvAsInt("ARCHIVE_FLUSH_INTERVAL_SECONDS", 300),
+		ArchiveRetentionDays:         getEnvAsInt("ARCHIVE_RETENTION_DAYS", 90),
 		S3AccessKey:       getEnv("S3_ACCESS_KEY", ""),
 		S3SecretKey:       getEnv("S3_SECRET_KEY", ""),
 		S3Region:          getEnv("S3_REGION", ""),
 		S3Bucket:          getEnv("S3_BUCKET", ""),
 		S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+		S3Compression:        getEnv("S3_COMPRESSION", "none"),
+		S3CompressionMigrate: getEnvAsBool("S3_COMPRESSION_MIGRATE", false),
 	}
 }
 
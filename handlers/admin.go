@@ -27,7 +27,7 @@ func (h *AdminHandler) GetAllSessions(c *gin.Context) {
 	fmt.Printf("DEBUG: Admin %s (ID: %d) requesting all sessions\n", user.Name, user.ID)
 
 	// Parse query parameters
-	status := c.DefaultQuery("status", "active") // active, inactive, terminated, completed, all
+	status := c.DefaultQuery("status", "active") // active, inactive, terminated, completed, expired, all
 	limit := c.DefaultQuery("limit", "50")
 	offset := c.DefaultQuery("offset", "0")
 
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+const adminSessionCookie = "admin_session"
+
+// Web admin session defaults
+const (
+	adminSessionDefaultTTL     = 24 * time.Hour     // idle time before a session expires
+	adminSessionDefaultMaxAge  = 7 * 24 * time.Hour // sessions expire this long after login, even if used
+	adminSessionRenewInterval  = time.Minute        // expiry is pushed back at most this often, to save writes
+	adminSessionUserAgentLimit = 512
+)
+
+// SetSessionLifetime sets how long an idle admin session lasts (renewed on every request) and
+// the absolute lifetime after which the admin has to log in again
+func (h *WebAdminHandler) SetSessionLifetime(ttl, maxAge time.Duration) {
+	h.sessionTTL = ttl
+	h.sessionMaxAge = maxAge
+	fmt.Printf("INFO: Web admin sessions expire after %s idle, %s after login\n", ttl, maxAge)
+}
+
+// StartSessionCleanup starts a background goroutine deleting expired admin sessions
+func (h *WebAdminHandler) StartSessionCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			result := h.db.Where("expires_at < ?", time.Now()).Delete(&models.AdminWebSession{})
+			if result.Error != nil {
+				fmt.Printf("ERROR: Failed to delete expired admin sessions: %v\n", result.Error)
+				continue
+			}
+			if result.RowsAffected > 0 {
+				fmt.Printf("DEBUG: Deleted %d expired admin sessions\n", result.RowsAffected)
+			}
+		}
+	}()
+}
+
+// hashAdminSessionToken returns the stored form of a session cookie token
+func hashAdminSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// adminCSRFToken returns the token that forms of a session must post back. It is derived from
+// the session's token hash, which never leaves the server, so other sites can't forge it.
+func adminCSRFToken(session *models.AdminWebSession) string {
+	sum := sha256.Sum256([]byte("csrf:" + session.TokenHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// validCSRFToken reports whether the request posted the CSRF token of its session
+func validCSRFToken(c *gin.Context) bool {
+	expected := c.GetString("admin_csrf_token")
+	posted := c.PostForm("csrf_token")
+	return expected != "" && subtle.ConstantTimeCompare([]byte(posted), []byte(expected)) == 1
+}
+
+// createSession stores a new session for the admin and sets the session cookie
+func (h *WebAdminHandler) createSession(c *gin.Context, user models.User) error {
+	token := h.generateSessionID()
+	now := time.Now()
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > adminSessionUserAgentLimit {
+		userAgent = userAgent[:adminSessionUserAgentLimit]
+	}
+
+	session := models.AdminWebSession{
+		TokenHash:  hashAdminSessionToken(token),
+		UserID:     user.ID,
+		Username:   user.Name,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  h.sessionExpiry(now, now),
+	}
+	if err := h.db.Create(&session).Error; err != nil {
+		return err
+	}
+
+	h.setSessionCookie(c, token, session.ExpiresAt)
+	return nil
+}
+
+// lookupSession returns the unexpired session of a cookie token
+func (h *WebAdminHandler) lookupSession(token string) (*models.AdminWebSession, error) {
+	var session models.AdminWebSession
+	err := h.db.Where("token_hash = ? AND expires_at > ?", hashAdminSessionToken(token), time.Now()).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// renewSession pushes the session's expiry back (sliding expiration), at most once per renew interval
+func (h *WebAdminHandler) renewSession(c *gin.Context, token string, session *models.AdminWebSession) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < adminSessionRenewInterval {
+		return
+	}
+
+	expiresAt := h.sessionExpiry(session.CreatedAt, now)
+	result := h.db.Model(&models.AdminWebSession{}).
+		Where("id = ?", session.ID).
+		Updates(map[string]interface{}{"last_seen_at": now, "expires_at": expiresAt})
+	if result.Error != nil {
+		fmt.Printf("WARNING: Failed to renew admin session %d: %v\n", session.ID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return // Logged out concurrently
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = expiresAt
+	h.setSessionCookie(c, token, expiresAt)
+}
+
+// sessionExpiry returns when a session used at lastSeen expires
+func (h *WebAdminHandler) sessionExpiry(createdAt, lastSeen time.Time) time.Time {
+	expiresAt := lastSeen.Add(h.sessionTTL)
+	if maxExpiry := createdAt.Add(h.sessionMaxAge); h.sessionMaxAge > 0 && expiresAt.After(maxExpiry) {
+		expiresAt = maxExpiry
+	}
+	return expiresAt
+}
+
+func (h *WebAdminHandler) setSessionCookie(c *gin.Context, token string, expiresAt time.Time) {
+	c.SetCookie(adminSessionCookie, token, int(time.Until(expiresAt).Seconds()), "/admin", "", false, true)
+}
+
+func (h *WebAdminHandler) clearSessionCookie(c *gin.Context) {
+	c.SetCookie(adminSessionCookie, "", -1, "/admin", "", false, true)
+}
+
+// HandleLogoutAll ends every session of the logged-in admin, on all devices (POST with CSRF token)
+func (h *WebAdminHandler) HandleLogoutAll(c *gin.Context) {
+	userID := c.GetUint("admin_user_id")
+	if !validCSRFToken(c) {
+		fmt.Printf("WARNING: Rejected logout-all for admin %d without a valid CSRF token\n", userID)
+		c.String(http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	result := h.db.Where("user_id = ?", userID).Delete(&models.AdminWebSession{})
+	if result.Error != nil {
+		fmt.Printf("ERROR: Failed to log out admin %d everywhere: %v\n", userID, result.Error)
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+
+	fmt.Printf("DEBUG: Admin %d logged out everywhere (%d sessions)\n", userID, result.RowsAffected)
+	h.clearSessionCookie(c)
+	c.Redirect(http.StatusFound, "/admin/login")
+}
+
+// GetAdminSessionsWeb - Lists the active sessions of the logged-in admin
+func (h *WebAdminHandler) GetAdminSessionsWeb(c *gin.Context) {
+	userID := c.GetUint("admin_user_id")
+	currentID := c.GetUint("admin_session_id")
+
+	var sessions []models.AdminWebSession
+	result := h.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch admin sessions",
+			"error":   result.Error.Error(),
+		})
+		return
+	}
+
+	formattedSessions := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		formattedSessions = append(formattedSessions, gin.H{
+			"id":           session.ID,
+			"ip_address":   session.IPAddress,
+			"user_agent":   session.UserAgent,
+			"created_at":   session.CreatedAt,
+			"last_seen_at": session.LastSeenAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"sessions": formattedSessions,
+			"total":    len(formattedSessions),
+		},
+	})
+}
+
+// RevokeAdminSessionWeb - Ends one of the logged-in admin's sessions
+func (h *WebAdminHandler) RevokeAdminSessionWeb(c *gin.Context) {
+	userID := c.GetUint("admin_user_id")
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid session ID",
+		})
+		return
+	}
+
+	var session models.AdminWebSession
+	err = h.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Session not found",
+		})
+		return
+	}
+	if err == nil {
+		err = h.db.Delete(&session).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to revoke session",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	fmt.Printf("DEBUG: Admin %d revoked admin session %d\n", userID, session.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session revoked successfully",
+		"data": gin.H{
+			"id":      session.ID,
+			"current": session.ID == c.GetUint("admin_session_id"),
+		},
+	})
+}
@@ -13,20 +13,48 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils"
 )
 
 type APIEndpointsHandler struct {
 	db    *gorm.DB
 	redis *redis.Client
+	s3    *utils.S3Client // Live train data fallback when the live store has none
+	store LiveStore       // Live train data for delay estimation
+	// Route geometry and timetable for live delay estimation
+	routes *routeMatcher
+	// Skips the Redis cache while its circuit is open (nil = always use it)
+	redisHealth *RedisHealthMonitor
 }
 
 func NewAPIEndpointsHandler(db *gorm.DB, redisClient *redis.Client) *APIEndpointsHandler {
 	return &APIEndpointsHandler{
-		db:    db,
-		redis: redisClient,
+		db:     db,
+		redis:  redisClient,
+		routes: newRouteMatcher(db),
 	}
 }
 
+// SetS3Client sets the S3 client used to read live train data when the live store has none
+func (h *APIEndpointsHandler) SetS3Client(s3Client *utils.S3Client) {
+	h.s3 = s3Client
+}
+
+// SetLiveStore sets where live train data is read from for delay estimation
+func (h *APIEndpointsHandler) SetLiveStore(store LiveStore) {
+	h.store = store
+}
+
+// SetRedisHealthMonitor skips the Redis cache while Redis is unhealthy, so requests don't wait for its timeouts
+func (h *APIEndpointsHandler) SetRedisHealthMonitor(monitor *RedisHealthMonitor) {
+	h.redisHealth = monitor
+}
+
+// cacheAvailable reports whether the Redis response cache should be used
+func (h *APIEndpointsHandler) cacheAvailable() bool {
+	return h.redis != nil && (h.redisHealth == nil || h.redisHealth.Healthy())
+}
+
 // GetStations - GET /api/stations
 // Returns all stations with platforms, matching Laravel API structure
 func (h *APIEndpointsHandler) GetStations(c *gin.Context) {
@@ -34,7 +62,7 @@ func (h *APIEndpointsHandler) GetStations(c *gin.Context) {
 	cacheKey := "api:stations:all"
 	
 	// Try to get from cache first
-	if h.redis != nil {
+	if h.cacheAvailable() {
 		cached, err := h.redis.Get(context.Background(), cacheKey).Result()
 		if err == nil {
 			if json.Unmarshal([]byte(cached), &stations) == nil {
@@ -54,7 +82,7 @@ func (h *APIEndpointsHandler) GetStations(c *gin.Context) {
 		
 	if result.Error != nil {
 		// Try to serve stale cache as fallback
-		if h.redis != nil {
+		if h.cacheAvailable() {
 			staleKey := cacheKey + ":stale"
 			cached, err := h.redis.Get(context.Background(), staleKey).Result()
 			if err == nil {
@@ -74,7 +102,7 @@ func (h *APIEndpointsHandler) GetStations(c *gin.Context) {
 	}
 
 	// Cache the result for 5 minutes
-	if h.redis != nil {
+	if h.cacheAvailable() {
 		if data, err := json.Marshal(stations); err == nil {
 			h.redis.Set(context.Background(), cacheKey, data, 5*time.Minute)
 			h.redis.Set(context.Background(), cacheKey+":stale", data, 24*time.Hour) // Keep stale version
@@ -111,7 +139,7 @@ func (h *APIEndpointsHandler) GetSchedules(c *gin.Context) {
 	cacheKey := fmt.Sprintf("api:schedules:station_%s:page_%s:limit_%s", stationID, pageStr, limitStr)
 	
 	// Try to get from Redis cache first
-	if h.redis != nil {
+	if h.cacheAvailable() {
 		cached, err := h.redis.Get(context.Background(), cacheKey).Result()
 		if err == nil {
 			// Parse cached data
@@ -271,7 +299,7 @@ func (h *APIEndpointsHandler) GetSchedules(c *gin.Context) {
 	c.Header("X-Records-Count", fmt.Sprintf("%d", len(scheduleDetails)))
 	
 	// Cache the successful response
-	if h.redis != nil {
+	if h.cacheAvailable() {
 		// Cache the data
 		if data, err := json.Marshal(scheduleDetails); err == nil {
 			// Cache for 10 minutes for full data, 5 minutes for filtered/paginated
@@ -404,8 +432,16 @@ func (h *APIEndpointsHandler) GetStationByID(c *gin.Context) {
 	c.JSON(http.StatusOK, station)
 }
 
+// TrainScheduleStop - schedule detail with live estimates for stops still ahead of the train
+type TrainScheduleStop struct {
+	models.ScheduleDetail
+	EstimatedArrival   *string `json:"estimated_arrival,omitempty"`
+	EstimatedDeparture *string `json:"estimated_departure,omitempty"`
+	DelayMinutes       *int    `json:"delay_minutes,omitempty"`
+}
+
 // GetTrainSchedule - GET /api/trains/:id/schedule
-// Returns schedule details for a specific train
+// Returns schedule details for a specific train, with live delay and ETAs while the train is tracked
 func (h *APIEndpointsHandler) GetTrainSchedule(c *gin.Context) {
 	trainID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -429,7 +465,46 @@ func (h *APIEndpointsHandler) GetTrainSchedule(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, scheduleDetails)
+	stops := make([]TrainScheduleStop, len(scheduleDetails))
+	for i, detail := range scheduleDetails {
+		stops[i].ScheduleDetail = detail
+	}
+
+	if delay := h.getLiveTrainDelay(uint(trainID)); delay != nil {
+		etas := make(map[int]models.StopETA, len(delay.RemainingStops))
+		for _, eta := range delay.RemainingStops {
+			etas[eta.StopSequence] = eta
+		}
+		for i := range stops {
+			if eta, exists := etas[stops[i].StopSequence]; exists {
+				stops[i].EstimatedArrival = eta.EstimatedArrival
+				stops[i].EstimatedDeparture = eta.EstimatedDeparture
+				stops[i].DelayMinutes = &delay.DelayMinutes
+			}
+		}
+		c.Header("X-Train-Delay-Minutes", strconv.Itoa(delay.DelayMinutes))
+	}
+
+	c.JSON(http.StatusOK, stops)
+}
+
+// getLiveTrainDelay estimates a train's delay from its live data (live store first, S3 fallback).
+// Returns nil when the train isn't being tracked or can't be matched to its timetable.
+func (h *APIEndpointsHandler) getLiveTrainDelay(trainID uint) *models.TrainDelay {
+	var train models.Train
+	if err := h.db.Select("train_id, train_number").First(&train, trainID).Error; err != nil {
+		return nil
+	}
+
+	if h.store == nil {
+		return nil
+	}
+	trainData, err := getLiveTrainData(h.store, h.s3, train.TrainNumber)
+	if err != nil || trainData.PassengerCount == 0 {
+		return nil
+	}
+
+	return h.routes.estimateDelay(train.TrainNumber, trainData.RouteMatch, time.Now())
 }
 
 // GetOperationalRouteByID - GET /api/operational-routes/:id
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// GPS plausibility limits for location ingestion
+const (
+	gpsMaxAccuracyM          = 500.0 // fixes worse than this are kept out of the train aggregate
+	gpsMinJumpCheckM         = 200.0 // movements shorter than this are treated as GPS noise, never as jumps
+	gpsDefaultMaxSpeedKmh    = 160.0 // used when the train has no maximum_speed
+	gpsSpeedToleranceRatio   = 1.25  // allowed overshoot of the train's maximum speed
+	gpsSpeedToleranceKmh     = 20.0  // extra slack for GPS position error at short intervals
+	gpsMaxConsecutiveRejects = 3     // after this many rejections in a row the previous fix was probably the bad one
+)
+
+// GPS quality flags and rejection reasons returned to the app
+const (
+	gpsQualityLowAccuracy     = "low_accuracy"
+	gpsRejectImplausibleSpeed = "implausible_speed"
+)
+
+// GPSPlausibility is the result of checking a point against the previous one and the train
+type GPSPlausibility struct {
+	Accepted        bool     `json:"accepted"`
+	QualityFlag     string   `json:"quality_flag,omitempty"`     // point kept, but excluded from the train aggregate
+	RejectionReason string   `json:"rejection_reason,omitempty"` // point dropped
+	ImpliedSpeedKmh *float64 `json:"implied_speed_kmh,omitempty"`
+	MaxAllowedKmh   float64  `json:"max_allowed_kmh"`
+}
+
+// checkGPSPlausibility checks a point against the previous accepted point and the train's maximum speed
+func checkGPSPlausibility(point GPSPoint, previous *GPSPoint, maxSpeedKmh float64) GPSPlausibility {
+	if maxSpeedKmh <= 0 {
+		maxSpeedKmh = gpsDefaultMaxSpeedKmh
+	}
+	result := GPSPlausibility{
+		Accepted:      true,
+		MaxAllowedKmh: maxSpeedKmh*gpsSpeedToleranceRatio + gpsSpeedToleranceKmh,
+	}
+
+	if previous != nil && point.Timestamp > previous.Timestamp {
+		distanceM := calculateDistance(previous.Lat, previous.Lng, point.Lat, point.Lng) * 1000
+		elapsedHours := float64(point.Timestamp-previous.Timestamp) / 1000 / 3600
+		impliedSpeed := (distanceM / 1000) / elapsedHours
+		result.ImpliedSpeedKmh = &impliedSpeed
+
+		if distanceM > gpsMinJumpCheckM && impliedSpeed > result.MaxAllowedKmh {
+			result.Accepted = false
+			result.RejectionReason = gpsRejectImplausibleSpeed
+			return result
+		}
+	}
+
+	if point.Accuracy != nil && *point.Accuracy > gpsMaxAccuracyM {
+		result.QualityFlag = gpsQualityLowAccuracy
+	}
+
+	return result
+}
+
+// filterGPSPoint checks a point for a session, accepting it anyway after repeated rejections
+// so a single bad anchor fix cannot lock the session out
+func (h *SimpleLiveTrackingHandler) filterGPSPoint(session models.LiveTrackingSession, point GPSPoint, previous *GPSPoint) GPSPlausibility {
+	result := checkGPSPlausibility(point, previous, h.getTrainMaxSpeedKmh(session.TrainID))
+
+	h.gpsRejectMutex.Lock()
+	defer h.gpsRejectMutex.Unlock()
+
+	if result.Accepted {
+		delete(h.gpsRejectStreak, session.SessionID)
+		return result
+	}
+
+	h.gpsRejectStreak[session.SessionID]++
+	if h.gpsRejectStreak[session.SessionID] >= gpsMaxConsecutiveRejects {
+		fmt.Printf("DEBUG: Session %s had %d implausible points in a row, accepting new position\n",
+			session.SessionID, h.gpsRejectStreak[session.SessionID])
+		delete(h.gpsRejectStreak, session.SessionID)
+		result.Accepted = true
+		result.RejectionReason = ""
+	}
+
+	return result
+}
+
+// forgetGPSRejectStreak drops the reject counter of a session that ended
+func (h *SimpleLiveTrackingHandler) forgetGPSRejectStreak(sessionID string) {
+	h.gpsRejectMutex.Lock()
+	delete(h.gpsRejectStreak, sessionID)
+	h.gpsRejectMutex.Unlock()
+}
+
+// getTrainMaxSpeedKmh returns the train's maximum speed from the trains table (0 if unknown)
+func (h *SimpleLiveTrackingHandler) getTrainMaxSpeedKmh(trainID uint) float64 {
+	train := h.getTrain(trainID)
+	if train == nil || train.MaximumSpeed == nil {
+		return 0
+	}
+	return float64(*train.MaximumSpeed)
+}
+
+// getLivePosition returns the session's current live position (live store first, S3 fallback)
+func (h *SimpleLiveTrackingHandler) getLivePosition(session models.LiveTrackingSession) *GPSPoint {
+	if h.store.TracksSessions() {
+		if position, err := h.getLiveSessionPosition(session.SessionID); err == nil {
+			return position
+		}
+	}
+
+	trainData, err := h.trainFiles.GetTrain(session.TrainNumber)
+	if err != nil {
+		return nil
+	}
+
+	for _, passenger := range trainData.Passengers {
+		if passenger.SessionID == session.SessionID {
+			return &GPSPoint{
+				Lat:       passenger.Lat,
+				Lng:       passenger.Lng,
+				Timestamp: passenger.Timestamp,
+				Speed:     passenger.Speed,
+				Altitude:  passenger.Altitude,
+				Accuracy:  passenger.Accuracy,
+				Heading:   passenger.Heading,
+			}
+		}
+	}
+
+	return nil
+}
+
+// getLiveSessionPosition reads the session's current live position from the live store
+func (h *SimpleLiveTrackingHandler) getLiveSessionPosition(sessionID string) (*GPSPoint, error) {
+	sessionData, err := h.store.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("session not found in live store: %v", err)
+	}
+	return sessionPosition(sessionData), nil
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Redis channel every instance publishes live tracking changes on
+const liveEventsChannel = "live_tracking:events"
+
+// Live event types
+const (
+	liveEventLocationUpdated  = "location_updated"
+	liveEventSessionStarted   = "session_started"
+	liveEventSessionStopped   = "session_stopped"
+	liveEventSessionExpired   = "session_expired"
+	liveEventSessionRecovered = "session_recovered"
+)
+
+// LiveEvent tells other instances that a train's live data changed. Subscribers read the
+// current train data from the live store, so the event only carries what changed, not the data itself.
+type LiveEvent struct {
+	Type        string `json:"type"`
+	TrainNumber string `json:"train_number"`
+	SessionID   string `json:"session_id,omitempty"`
+	UserID      uint   `json:"user_id,omitempty"`
+	Timestamp   int64  `json:"timestamp"` // Unix milliseconds
+}
+
+// publishLiveEvent publishes a live event. Failures are logged, never returned - the
+// periodic WebSocket snapshot still picks the change up.
+func publishLiveEvent(store LiveStore, event LiveEvent) {
+	event.Timestamp = time.Now().UnixMilli()
+	if err := store.Publish(event); err != nil {
+		fmt.Printf("WARNING: Failed to publish %s event for train %s: %v\n", event.Type, event.TrainNumber, err)
+	}
+}
+
+// Live event fan-out parameters
+const (
+	liveEventFlushInterval   = time.Second     // coalesce bursts of location updates per train
+	liveEventResubscribeWait = 5 * time.Second // wait before resubscribing after the subscription closed
+)
+
+// subscribeLiveEvents marks trains changed on any instance so the next flush pushes them to clients.
+// It runs until ctx is cancelled.
+func (h *WebSocketHandler) subscribeLiveEvents(ctx context.Context, store LiveStore) {
+	for {
+		events, err := store.Subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == ErrLiveStoreUnsupported {
+			return // Clients get changes from the periodic snapshot only
+		}
+		if err == ErrLiveStoreUnavailable {
+			// Backend down, the health monitor tells us when it's back
+			if !sleepContext(ctx, liveEventResubscribeWait) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Printf("WARNING: Failed to subscribe to live events, retrying in %v: %v\n", liveEventResubscribeWait, err)
+			if !sleepContext(ctx, liveEventResubscribeWait) {
+				return
+			}
+			continue
+		}
+		fmt.Printf("INFO: WebSocket hub subscribed to live events (%s live store)\n", store.Name())
+
+		for event := range events {
+			h.dirtyMutex.Lock()
+			h.dirtyTrains[event.TrainNumber] = true
+			h.dirtyMutex.Unlock()
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("WARNING: Live event subscription closed, resubscribing in %v\n", liveEventResubscribeWait)
+		if !sleepContext(ctx, liveEventResubscribeWait) {
+			return
+		}
+	}
+}
+
+// sleepContext waits for d, returning false when ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// flushLiveEvents pushes the current state of every changed train to clients until ctx is cancelled
+func (h *WebSocketHandler) flushLiveEvents(ctx context.Context) {
+	ticker := time.NewTicker(liveEventFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h.dirtyMutex.Lock()
+		dirty := h.dirtyTrains
+		h.dirtyTrains = make(map[string]bool)
+		h.dirtyMutex.Unlock()
+
+		h.mutex.RLock()
+		clientCount := len(h.clients)
+		h.mutex.RUnlock()
+		if len(dirty) == 0 || clientCount == 0 {
+			continue
+		}
+
+		for trainNumber := range dirty {
+			update, err := h.getLiveTrainUpdate(trainNumber, fmt.Sprintf("%s-pubsub-websocket", h.store.Name()))
+			if err != nil {
+				fmt.Printf("WARNING: Failed to read live data for train %s: %v\n", trainNumber, err)
+				continue
+			}
+			if update == nil {
+				h.broadcastToClients(WebSocketMessage{
+					Type: "train_removed",
+					Data: map[string]interface{}{"trainNumber": trainNumber},
+				})
+				continue
+			}
+			h.broadcastToClients(WebSocketMessage{
+				Type: "train_update",
+				Data: update,
+			})
+		}
+	}
+}
+
+// broadcastTrainUpdatesFromStore sends the full train_updates snapshot built from the live store's trains.
+// It catches anything a missed event or an expired key left out of the pushed updates.
+func (h *WebSocketHandler) broadcastTrainUpdatesFromStore() error {
+	trainNumbers, err := h.store.ListTrains()
+	if err != nil {
+		return err
+	}
+
+	updates := []TrainUpdate{}
+	for _, trainNumber := range trainNumbers {
+		update, err := h.getLiveTrainUpdate(trainNumber, fmt.Sprintf("%s-live-websocket", h.store.Name()))
+		if err != nil {
+			log.Printf("WebSocket: Failed to read live data for train %s: %v", trainNumber, err)
+			continue
+		}
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	h.broadcastToClients(WebSocketMessage{
+		Type: "train_updates",
+		Data: updates,
+	})
+	return nil
+}
+
+// getLiveTrainUpdate builds a train's update from its live data in the live store.
+// Returns nil when the train has no live data or no active passengers left.
+func (h *WebSocketHandler) getLiveTrainUpdate(trainNumber string, dataSource string) (*TrainUpdate, error) {
+	trainData, err := h.store.GetTrain(trainNumber)
+	if err == ErrLiveStoreNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The aggregate only holds sessions that were active when it was written
+	var activePassengers []models.Passenger
+	for _, passenger := range trainData.Passengers {
+		if passenger.SessionStatus != "active" {
+			continue
+		}
+		if userCache := h.getUserWithStation(passenger.UserID); userCache != nil {
+			passenger.Name = userCache.Name
+			passenger.Username = userCache.Username
+			passenger.StationName = userCache.StationName
+		}
+		activePassengers = append(activePassengers, passenger)
+	}
+	if len(activePassengers) == 0 {
+		return nil, nil
+	}
+
+	update := h.buildTrainUpdate(trainNumber, trainData, activePassengers, dataSource)
+	return &update, nil
+}
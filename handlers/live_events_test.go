@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func subscriberCount(store *memoryLiveStore) int {
+	store.subscribersMutex.Lock()
+	defer store.subscribersMutex.Unlock()
+	return len(store.subscribers)
+}
+
+func TestWebSocketSetLiveStoreReplacesWorkers(t *testing.T) {
+	h := &WebSocketHandler{
+		clients:     make(map[*websocket.Conn]bool),
+		dirtyTrains: make(map[string]bool),
+	}
+	first := NewMemoryLiveStore().(*memoryLiveStore)
+	second := NewMemoryLiveStore().(*memoryLiveStore)
+
+	h.SetLiveStore(first)
+	h.SetLiveStore(first)
+	h.SetLiveStore(second)
+
+	// The replaced workers unsubscribe once their context is cancelled
+	deadline := time.Now().Add(2 * time.Second)
+	for subscriberCount(first) != 0 || subscriberCount(second) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribers: first store %d, second store %d; want 0 and 1",
+				subscriberCount(first), subscriberCount(second))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h.liveEventsMutex.Lock()
+	h.liveEventsCancel()
+	h.liveEventsMutex.Unlock()
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils"
+)
+
+// Live state lifetimes, shared by every LiveStore implementation
+const (
+	liveSessionTTL   = 10 * time.Minute // session position expires without updates
+	trainLiveTTL     = 15 * time.Minute // train aggregate expires without updates
+	gpsPathMaxPoints = 20000            // ~28 hours at one point every 5 seconds
+	gpsPathTTL       = 24 * time.Hour   // refreshed on every append
+)
+
+var (
+	// ErrLiveStoreNotFound is returned when a key doesn't exist or has expired
+	ErrLiveStoreNotFound = errors.New("not found in live store")
+	// ErrLiveStoreUnsupported is returned for data the store doesn't keep
+	ErrLiveStoreUnsupported = errors.New("not supported by live store")
+	// ErrLiveStoreUnavailable is returned while the store's backend is down (circuit open)
+	ErrLiveStoreUnavailable = errors.New("live store temporarily unavailable")
+)
+
+// LiveStore keeps short-lived live tracking state - session positions, path history, train
+// aggregates and spotters - and the per-train locking and event fan-out around it.
+// Implementations: Redis (multi-instance), S3 (legacy train files only) and in-memory (single box).
+type LiveStore interface {
+	// Name identifies the store in logs and API responses ("redis", "s3", "memory")
+	Name() string
+	// TracksSessions reports whether the store keeps per-session positions and path history.
+	// Without it, handlers keep passengers directly in the S3 train files (legacy mode).
+	TracksSessions() bool
+
+	// Session positions, expiring after liveSessionTTL
+	GetSession(sessionID string) (map[string]interface{}, error)
+	SetSession(sessionID string, data map[string]interface{}) error
+	TouchSession(sessionID string) (bool, error) // extends the TTL, false if the session is gone
+	DeleteSession(sessionID string) error        // also removes the path history
+
+	// Path history, capped at gpsPathMaxPoints and expiring after gpsPathTTL
+	AppendPath(sessionID string, points ...GPSPoint) error
+	GetPath(sessionID string) ([]GPSPoint, error) // in append order
+
+	// Train aggregates. Writes carry the train lock's fencing token (0 when not fenced) and
+	// are rejected when a newer lock holder already wrote the train.
+	GetTrain(trainNumber string) (*models.TrainData, error)
+	PutTrain(trainNumber string, trainData *models.TrainData, fence int64) error
+	DeleteTrain(trainNumber string, fence int64) error
+	ListTrains() ([]string, error)
+
+	// Spotters, expiring after spotterLocationTTL
+	PutSpotter(spotter SpotterLocation) error
+	ListSpotters() ([]SpotterLocation, error)
+
+	// Proximity queries within radiusKm of a point, nearest first, at most limit results
+	NearbySpotters(lat, lng, radiusKm float64, limit int) ([]NearbySpotter, error)
+	NearbyTrains(lat, lng, radiusKm float64, limit int) ([]NearbyTrain, error)
+
+	// NewTrainLock returns the lock serializing changes to one train's live data
+	NewTrainLock(trainNumber string) TrainLock
+	// Publish and Subscribe fan live events out to every instance sharing the store
+	Publish(event LiveEvent) error
+	Subscribe(ctx context.Context) (<-chan LiveEvent, error)
+}
+
+// TrainLock serializes read-modify-write of one train's live data
+type TrainLock interface {
+	Lock() error // fails when the lock can't be acquired; nothing may be written then
+	Unlock()
+	Token() int64 // fencing token for store writes, 0 when not fenced
+	Held() bool   // false when not locked and once the lock was lost while held
+}
+
+// NearbySpotter is a spotter found by a proximity query
+type NearbySpotter struct {
+	Spotter    SpotterLocation
+	DistanceKm float64
+}
+
+// NearbyTrain is a live train found by a proximity query
+type NearbyTrain struct {
+	Train      *models.TrainData
+	DistanceKm float64
+}
+
+// nearbySpottersByScan answers NearbySpotters from the full spotter list, for stores without a geo index
+func nearbySpottersByScan(store LiveStore, lat, lng, radiusKm float64, limit int) ([]NearbySpotter, error) {
+	spotters, err := store.ListSpotters()
+	if err != nil {
+		return nil, err
+	}
+
+	var nearby []NearbySpotter
+	for _, spotter := range spotters {
+		if distance := calculateDistance(lat, lng, spotter.Latitude, spotter.Longitude); distance <= radiusKm {
+			nearby = append(nearby, NearbySpotter{Spotter: spotter, DistanceKm: distance})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].DistanceKm < nearby[j].DistanceKm })
+	if len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby, nil
+}
+
+// nearbyTrainsByScan answers NearbyTrains by reading every live train, for stores without a geo index
+func nearbyTrainsByScan(store LiveStore, lat, lng, radiusKm float64, limit int) ([]NearbyTrain, error) {
+	trainNumbers, err := store.ListTrains()
+	if err != nil {
+		return nil, err
+	}
+
+	var nearby []NearbyTrain
+	for _, trainNumber := range trainNumbers {
+		trainData, err := store.GetTrain(trainNumber)
+		if err != nil {
+			continue // Expired or unreadable since it was listed
+		}
+		position := trainData.AveragePosition
+		if distance := calculateDistance(lat, lng, position.Lat, position.Lng); distance <= radiusKm {
+			nearby = append(nearby, NearbyTrain{Train: trainData, DistanceKm: distance})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].DistanceKm < nearby[j].DistanceKm })
+	if len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby, nil
+}
+
+// getLiveTrainData reads a train's aggregate from the live store, falling back to the train
+// file last synced to S3
+func getLiveTrainData(store LiveStore, s3Client *utils.S3Client, trainNumber string) (*models.TrainData, error) {
+	trainData, err := store.GetTrain(trainNumber)
+	if err == nil {
+		return trainData, nil
+	}
+	if _, readsS3 := store.(*s3LiveStore); readsS3 || s3Client == nil {
+		return nil, err
+	}
+
+	return s3Client.GetTrainData(s3TrainFileName(trainNumber))
+}
+
+// sessionPosition reads the GPS fields of a session position
+func sessionPosition(sessionData map[string]interface{}) *GPSPoint {
+	lat, _ := sessionData["lat"].(float64)
+	lng, _ := sessionData["lng"].(float64)
+	timestamp, _ := sessionData["timestamp"].(float64)
+	position := &GPSPoint{
+		Lat:       lat,
+		Lng:       lng,
+		Timestamp: int64(timestamp),
+	}
+	if val, ok := sessionData["accuracy"].(float64); ok {
+		position.Accuracy = &val
+	}
+	if val, ok := sessionData["speed"].(float64); ok {
+		position.Speed = &val
+	}
+	if val, ok := sessionData["heading"].(float64); ok {
+		position.Heading = &val
+	}
+	if val, ok := sessionData["altitude"].(float64); ok {
+		position.Altitude = &val
+	}
+	return position
+}
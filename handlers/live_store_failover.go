@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// failoverLiveStore uses Redis while the health monitor's circuit is closed and the fallback
+// store (the S3 train files) while it is open. Everything that checks Name or TracksSessions
+// follows the switch on its next call.
+type failoverLiveStore struct {
+	primary  LiveStore
+	fallback LiveStore
+	monitor  *RedisHealthMonitor
+}
+
+// NewFailoverLiveStore creates a Redis live store that falls back to another store while Redis is unhealthy
+func NewFailoverLiveStore(monitor *RedisHealthMonitor, fallback LiveStore) LiveStore {
+	return &failoverLiveStore{
+		primary:  NewRedisLiveStore(monitor.Client()),
+		fallback: fallback,
+		monitor:  monitor,
+	}
+}
+
+func (s *failoverLiveStore) current() LiveStore {
+	if s.monitor.Healthy() {
+		return s.primary
+	}
+	return s.fallback
+}
+
+func (s *failoverLiveStore) Name() string {
+	return s.current().Name()
+}
+
+func (s *failoverLiveStore) TracksSessions() bool {
+	return s.current().TracksSessions()
+}
+
+func (s *failoverLiveStore) GetSession(sessionID string) (map[string]interface{}, error) {
+	return s.current().GetSession(sessionID)
+}
+
+func (s *failoverLiveStore) SetSession(sessionID string, data map[string]interface{}) error {
+	return s.current().SetSession(sessionID, data)
+}
+
+func (s *failoverLiveStore) TouchSession(sessionID string) (bool, error) {
+	return s.current().TouchSession(sessionID)
+}
+
+func (s *failoverLiveStore) DeleteSession(sessionID string) error {
+	return s.current().DeleteSession(sessionID)
+}
+
+func (s *failoverLiveStore) AppendPath(sessionID string, points ...GPSPoint) error {
+	return s.current().AppendPath(sessionID, points...)
+}
+
+func (s *failoverLiveStore) GetPath(sessionID string) ([]GPSPoint, error) {
+	return s.current().GetPath(sessionID)
+}
+
+func (s *failoverLiveStore) GetTrain(trainNumber string) (*models.TrainData, error) {
+	return s.current().GetTrain(trainNumber)
+}
+
+func (s *failoverLiveStore) PutTrain(trainNumber string, trainData *models.TrainData, fence int64) error {
+	return s.current().PutTrain(trainNumber, trainData, fence)
+}
+
+func (s *failoverLiveStore) DeleteTrain(trainNumber string, fence int64) error {
+	return s.current().DeleteTrain(trainNumber, fence)
+}
+
+func (s *failoverLiveStore) ListTrains() ([]string, error) {
+	return s.current().ListTrains()
+}
+
+func (s *failoverLiveStore) PutSpotter(spotter SpotterLocation) error {
+	return s.current().PutSpotter(spotter)
+}
+
+func (s *failoverLiveStore) ListSpotters() ([]SpotterLocation, error) {
+	return s.current().ListSpotters()
+}
+
+func (s *failoverLiveStore) NearbySpotters(lat, lng, radiusKm float64, limit int) ([]NearbySpotter, error) {
+	return s.current().NearbySpotters(lat, lng, radiusKm, limit)
+}
+
+func (s *failoverLiveStore) NearbyTrains(lat, lng, radiusKm float64, limit int) ([]NearbyTrain, error) {
+	return s.current().NearbyTrains(lat, lng, radiusKm, limit)
+}
+
+// NewTrainLock returns a lock that uses Redis only while the circuit is closed. Handlers cache
+// locks per train, so the choice is made on every Lock rather than here.
+func (s *failoverLiveStore) NewTrainLock(trainNumber string) TrainLock {
+	lock := newTrainMutex(trainNumber, s.monitor.Client())
+	lock.available = s.monitor.Healthy
+	return lock
+}
+
+func (s *failoverLiveStore) Publish(event LiveEvent) error {
+	return s.current().Publish(event)
+}
+
+// Subscribe returns ErrLiveStoreUnavailable while the circuit is open, so subscribers retry
+// until Redis is back instead of giving up like with a store that never fans out events
+func (s *failoverLiveStore) Subscribe(ctx context.Context) (<-chan LiveEvent, error) {
+	if !s.monitor.Healthy() {
+		return nil, ErrLiveStoreUnavailable
+	}
+	return s.primary.Subscribe(ctx)
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// How often expired entries are dropped from the in-memory store
+const memoryStoreSweepInterval = time.Minute
+
+// Buffered events per subscriber before new events are dropped for it
+const memoryStoreSubscriberBuffer = 256
+
+// memoryEntry is a JSON value with an expiry. Values are kept as JSON so readers see the
+// same types (numbers as float64) as with Redis, and never share memory with writers.
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return now.After(e.expiresAt)
+}
+
+type memoryPath struct {
+	points    []GPSPoint
+	expiresAt time.Time
+}
+
+// memoryLiveStore keeps live state in process memory, for running everything on one box.
+// Nothing is shared with other instances and everything is lost on restart.
+type memoryLiveStore struct {
+	mutex       sync.Mutex
+	sessions    map[string]memoryEntry
+	paths       map[string]*memoryPath
+	trains      map[string]memoryEntry
+	trainFences map[string]int64 // last fencing token written per train
+	spotters    map[uint]memoryEntry
+
+	subscribers      map[chan LiveEvent]bool
+	subscribersMutex sync.RWMutex
+}
+
+// NewMemoryLiveStore creates a live store kept in process memory
+func NewMemoryLiveStore() LiveStore {
+	store := &memoryLiveStore{
+		sessions:    make(map[string]memoryEntry),
+		paths:       make(map[string]*memoryPath),
+		trains:      make(map[string]memoryEntry),
+		trainFences: make(map[string]int64),
+		spotters:    make(map[uint]memoryEntry),
+		subscribers: make(map[chan LiveEvent]bool),
+	}
+
+	go store.sweepExpired()
+
+	return store
+}
+
+func (s *memoryLiveStore) Name() string {
+	return "memory"
+}
+
+func (s *memoryLiveStore) TracksSessions() bool {
+	return true
+}
+
+func (s *memoryLiveStore) GetSession(sessionID string) (map[string]interface{}, error) {
+	s.mutex.Lock()
+	entry, exists := s.sessions[sessionID]
+	s.mutex.Unlock()
+	if !exists || entry.expired(time.Now()) {
+		return nil, ErrLiveStoreNotFound
+	}
+
+	var sessionData map[string]interface{}
+	if err := json.Unmarshal(entry.value, &sessionData); err != nil {
+		return nil, fmt.Errorf("failed to parse session data: %v", err)
+	}
+	return sessionData, nil
+}
+
+func (s *memoryLiveStore) SetSession(sessionID string, data map[string]interface{}) error {
+	sessionJSON, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session data: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.sessions[sessionID] = memoryEntry{value: sessionJSON, expiresAt: time.Now().Add(liveSessionTTL)}
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryLiveStore) TouchSession(sessionID string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	entry, exists := s.sessions[sessionID]
+	if !exists || entry.expired(now) {
+		return false, nil
+	}
+	entry.expiresAt = now.Add(liveSessionTTL)
+	s.sessions[sessionID] = entry
+	return true, nil
+}
+
+func (s *memoryLiveStore) DeleteSession(sessionID string) error {
+	s.mutex.Lock()
+	delete(s.sessions, sessionID)
+	delete(s.paths, sessionID)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryLiveStore) AppendPath(sessionID string, points ...GPSPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	path, exists := s.paths[sessionID]
+	if !exists || now.After(path.expiresAt) {
+		path = &memoryPath{}
+		s.paths[sessionID] = path
+	}
+	path.points = append(path.points, points...)
+	if overflow := len(path.points) - gpsPathMaxPoints; overflow > 0 {
+		path.points = append([]GPSPoint(nil), path.points[overflow:]...) // Keep only the newest points
+	}
+	path.expiresAt = now.Add(gpsPathTTL)
+	return nil
+}
+
+func (s *memoryLiveStore) GetPath(sessionID string) ([]GPSPoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path, exists := s.paths[sessionID]
+	if !exists || time.Now().After(path.expiresAt) {
+		return nil, nil
+	}
+	points := make([]GPSPoint, len(path.points))
+	copy(points, path.points)
+	return points, nil
+}
+
+func (s *memoryLiveStore) GetTrain(trainNumber string) (*models.TrainData, error) {
+	s.mutex.Lock()
+	entry, exists := s.trains[trainNumber]
+	s.mutex.Unlock()
+	if !exists || entry.expired(time.Now()) {
+		return nil, ErrLiveStoreNotFound
+	}
+
+	var trainData models.TrainData
+	if err := json.Unmarshal(entry.value, &trainData); err != nil {
+		return nil, fmt.Errorf("failed to parse train data: %v", err)
+	}
+	return &trainData, nil
+}
+
+func (s *memoryLiveStore) PutTrain(trainNumber string, trainData *models.TrainData, fence int64) error {
+	trainJSON, err := json.Marshal(trainData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal train data: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkFence(trainNumber, fence); err != nil {
+		return err
+	}
+	s.trains[trainNumber] = memoryEntry{value: trainJSON, expiresAt: time.Now().Add(trainLiveTTL)}
+	return nil
+}
+
+func (s *memoryLiveStore) DeleteTrain(trainNumber string, fence int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkFence(trainNumber, fence); err != nil {
+		return err
+	}
+	delete(s.trains, trainNumber)
+	return nil
+}
+
+// checkFence rejects writes from a lock holder older than the last writer. Caller must hold the mutex.
+func (s *memoryLiveStore) checkFence(trainNumber string, fence int64) error {
+	if fence <= 0 {
+		return nil
+	}
+	if fence < s.trainFences[trainNumber] {
+		return fmt.Errorf("stale fencing token %d for train %s, newer data already written", fence, trainNumber)
+	}
+	s.trainFences[trainNumber] = fence
+	return nil
+}
+
+func (s *memoryLiveStore) ListTrains() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	trainNumbers := make([]string, 0, len(s.trains))
+	for trainNumber, entry := range s.trains {
+		if !entry.expired(now) {
+			trainNumbers = append(trainNumbers, trainNumber)
+		}
+	}
+	sort.Strings(trainNumbers)
+	return trainNumbers, nil
+}
+
+func (s *memoryLiveStore) PutSpotter(spotter SpotterLocation) error {
+	data, err := json.Marshal(spotter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spotter data: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.spotters[spotter.UserID] = memoryEntry{value: data, expiresAt: time.Now().Add(spotterLocationTTL)}
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryLiveStore) ListSpotters() ([]SpotterLocation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	var spotters []SpotterLocation
+	for _, entry := range s.spotters {
+		if entry.expired(now) {
+			continue
+		}
+		var spotter SpotterLocation
+		if err := json.Unmarshal(entry.value, &spotter); err != nil {
+			continue // Skip malformed data
+		}
+		spotters = append(spotters, spotter)
+	}
+	return spotters, nil
+}
+
+func (s *memoryLiveStore) NearbySpotters(lat, lng, radiusKm float64, limit int) ([]NearbySpotter, error) {
+	return nearbySpottersByScan(s, lat, lng, radiusKm, limit)
+}
+
+func (s *memoryLiveStore) NearbyTrains(lat, lng, radiusKm float64, limit int) ([]NearbyTrain, error) {
+	return nearbyTrainsByScan(s, lat, lng, radiusKm, limit)
+}
+
+func (s *memoryLiveStore) NewTrainLock(trainNumber string) TrainLock {
+	return newTrainMutex(trainNumber, nil)
+}
+
+// Publish hands the event to every subscriber without blocking; slow subscribers miss events
+// and catch up with the next periodic snapshot
+func (s *memoryLiveStore) Publish(event LiveEvent) error {
+	s.subscribersMutex.RLock()
+	defer s.subscribersMutex.RUnlock()
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			fmt.Printf("WARNING: Live event subscriber is full, dropping %s event for train %s\n", event.Type, event.TrainNumber)
+		}
+	}
+	return nil
+}
+
+// Subscribe delivers events until ctx is cancelled
+func (s *memoryLiveStore) Subscribe(ctx context.Context) (<-chan LiveEvent, error) {
+	events := make(chan LiveEvent, memoryStoreSubscriberBuffer)
+
+	s.subscribersMutex.Lock()
+	s.subscribers[events] = true
+	s.subscribersMutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.subscribersMutex.Lock()
+		delete(s.subscribers, events)
+		close(events)
+		s.subscribersMutex.Unlock()
+	}()
+
+	return events, nil
+}
+
+// sweepExpired periodically drops expired entries so abandoned sessions don't accumulate
+func (s *memoryLiveStore) sweepExpired() {
+	ticker := time.NewTicker(memoryStoreSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mutex.Lock()
+		for sessionID, entry := range s.sessions {
+			if entry.expired(now) {
+				delete(s.sessions, sessionID)
+			}
+		}
+		for sessionID, path := range s.paths {
+			if now.After(path.expiresAt) {
+				delete(s.paths, sessionID)
+			}
+		}
+		for trainNumber, entry := range s.trains {
+			if entry.expired(now) {
+				delete(s.trains, trainNumber)
+			}
+		}
+		for userID, entry := range s.spotters {
+			if entry.expired(now) {
+				delete(s.spotters, userID)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
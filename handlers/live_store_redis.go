@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Indexes maintained on write so readers never scan KEYS
+const (
+	liveTrainsIndexKey     = "live_trains"     // set of train numbers with a train_live:<train> key
+	activeSpottersIndexKey = "spotters_active" // sorted set of spotter user IDs scored by last update (Unix ms)
+	liveTrainsGeoKey       = "live_trains_geo" // GEO index of train numbers at their average position
+	spottersGeoKey         = "spotters_geo"    // GEO index of spotter user IDs at their location
+)
+
+// Redis GEO only indexes latitudes within about +-85 degrees
+const maxGeoLatitude = 85.05112878
+
+// Write (or delete, for an empty value) the train aggregate unless a newer lock holder already wrote it,
+// keeping the live trains index and GEO index in step.
+// KEYS = train_live key, last written fence, live trains index, live trains GEO index;
+// ARGV = token, value, ttl ms, fence ttl ms, train number, longitude, latitude (empty to leave the GEO index alone)
+var fencedTrainWriteScript = redis.NewScript(`
+local token = tonumber(ARGV[1])
+if token > 0 then
+	local last = tonumber(redis.call("GET", KEYS[2]) or "0")
+	if token < last then
+		return 0
+	end
+	redis.call("SET", KEYS[2], token, "PX", ARGV[4])
+end
+if ARGV[2] == "" then
+	redis.call("DEL", KEYS[1])
+	redis.call("SREM", KEYS[3], ARGV[5])
+	redis.call("ZREM", KEYS[4], ARGV[5])
+else
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	redis.call("SADD", KEYS[3], ARGV[5])
+	if ARGV[6] ~= "" then
+		redis.call("GEOADD", KEYS[4], ARGV[6], ARGV[7], ARGV[5])
+	end
+end
+return 1`)
+
+// redisLiveStore keeps live state in Redis, shared by every instance:
+// live_session:<id>, live_path:<id>, train_live:<train>, spotter_location:<user> and their indexes
+type redisLiveStore struct {
+	client *redis.Client
+}
+
+// NewRedisLiveStore creates a live store backed by Redis
+func NewRedisLiveStore(client *redis.Client) LiveStore {
+	return &redisLiveStore{client: client}
+}
+
+func (s *redisLiveStore) Name() string {
+	return "redis"
+}
+
+func (s *redisLiveStore) TracksSessions() bool {
+	return true
+}
+
+func (s *redisLiveStore) GetSession(sessionID string) (map[string]interface{}, error) {
+	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
+	sessionDataStr, err := s.client.Get(context.Background(), sessionKey).Result()
+	if err == redis.Nil {
+		return nil, ErrLiveStoreNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session from Redis: %v", err)
+	}
+
+	var sessionData map[string]interface{}
+	if err := json.Unmarshal([]byte(sessionDataStr), &sessionData); err != nil {
+		return nil, fmt.Errorf("failed to parse session data: %v", err)
+	}
+	return sessionData, nil
+}
+
+func (s *redisLiveStore) SetSession(sessionID string, data map[string]interface{}) error {
+	sessionJSON, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session data: %v", err)
+	}
+
+	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
+	if err := s.client.Set(context.Background(), sessionKey, sessionJSON, liveSessionTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store session in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *redisLiveStore) TouchSession(sessionID string) (bool, error) {
+	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
+	return s.client.Expire(context.Background(), sessionKey, liveSessionTTL).Result()
+}
+
+func (s *redisLiveStore) DeleteSession(sessionID string) error {
+	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
+	pathKey := fmt.Sprintf("live_path:%s", sessionID)
+	return s.client.Del(context.Background(), sessionKey, pathKey).Err()
+}
+
+func (s *redisLiveStore) AppendPath(sessionID string, points ...GPSPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	pointsJSON := make([]interface{}, 0, len(points))
+	for _, point := range points {
+		pointJSON, err := json.Marshal(point)
+		if err != nil {
+			return fmt.Errorf("failed to marshal GPS point: %v", err)
+		}
+		pointsJSON = append(pointsJSON, pointJSON)
+	}
+
+	ctx := context.Background()
+	pathKey := fmt.Sprintf("live_path:%s", sessionID)
+
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, pathKey, pointsJSON...)
+	pipe.LTrim(ctx, pathKey, -gpsPathMaxPoints, -1) // Keep only the newest points
+	pipe.Expire(ctx, pathKey, gpsPathTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to append GPS path in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *redisLiveStore) GetPath(sessionID string) ([]GPSPoint, error) {
+	pathKey := fmt.Sprintf("live_path:%s", sessionID)
+	pathEntries, err := s.client.LRange(context.Background(), pathKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPS path from Redis: %v", err)
+	}
+
+	var gpsPath []GPSPoint
+	for _, entry := range pathEntries {
+		var point GPSPoint
+		if err := json.Unmarshal([]byte(entry), &point); err != nil {
+			continue // Skip malformed points
+		}
+		gpsPath = append(gpsPath, point)
+	}
+	return gpsPath, nil
+}
+
+func (s *redisLiveStore) GetTrain(trainNumber string) (*models.TrainData, error) {
+	trainKey := fmt.Sprintf("train_live:%s", trainNumber)
+	trainDataStr, err := s.client.Get(context.Background(), trainKey).Result()
+	if err == redis.Nil {
+		return nil, ErrLiveStoreNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get train data from Redis: %v", err)
+	}
+
+	var trainData models.TrainData
+	if err := json.Unmarshal([]byte(trainDataStr), &trainData); err != nil {
+		return nil, fmt.Errorf("failed to parse train data: %v", err)
+	}
+	return &trainData, nil
+}
+
+func (s *redisLiveStore) PutTrain(trainNumber string, trainData *models.TrainData, fence int64) error {
+	trainJSON, err := json.Marshal(trainData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal train data: %v", err)
+	}
+	return s.writeTrain(trainNumber, string(trainJSON), &trainData.AveragePosition, fence)
+}
+
+func (s *redisLiveStore) DeleteTrain(trainNumber string, fence int64) error {
+	return s.writeTrain(trainNumber, "", nil, fence)
+}
+
+// writeTrain stores (or deletes, for an empty value) the train aggregate. Writes are fenced with
+// the train lock's token, so a holder whose lease expired cannot overwrite data written by the
+// instance that took the lock over.
+func (s *redisLiveStore) writeTrain(trainNumber string, trainJSON string, position *models.Position, fence int64) error {
+	trainKey := fmt.Sprintf("train_live:%s", trainNumber)
+	fenceKey := fmt.Sprintf("train_live_fence:%s", trainNumber)
+
+	lng, lat := "", ""
+	if position != nil && math.Abs(position.Lat) <= maxGeoLatitude {
+		lng = strconv.FormatFloat(position.Lng, 'f', -1, 64)
+		lat = strconv.FormatFloat(position.Lat, 'f', -1, 64)
+	}
+
+	written, err := fencedTrainWriteScript.Run(context.Background(), s.client, []string{trainKey, fenceKey, liveTrainsIndexKey, liveTrainsGeoKey},
+		fence, trainJSON, trainLiveTTL.Milliseconds(), trainFenceKeyTTL.Milliseconds(), trainNumber, lng, lat).Int()
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		return fmt.Errorf("stale fencing token %d for train %s, newer data already written", fence, trainNumber)
+	}
+	return nil
+}
+
+// ListTrains returns the trains in the live trains index, dropping those whose key expired without a final write
+func (s *redisLiveStore) ListTrains() ([]string, error) {
+	ctx := context.Background()
+	trainNumbers, err := s.client.SMembers(ctx, liveTrainsIndexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get live trains index from Redis: %v", err)
+	}
+	if len(trainNumbers) == 0 {
+		return trainNumbers, nil
+	}
+
+	pipe := s.client.Pipeline()
+	exists := make([]*redis.IntCmd, len(trainNumbers))
+	for i, trainNumber := range trainNumbers {
+		exists[i] = pipe.Exists(ctx, fmt.Sprintf("train_live:%s", trainNumber))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return trainNumbers, nil // Readers handle missing trains anyway
+	}
+
+	live := make([]string, 0, len(trainNumbers))
+	var expired []interface{}
+	for i, trainNumber := range trainNumbers {
+		if exists[i].Val() == 0 {
+			expired = append(expired, trainNumber)
+			continue
+		}
+		live = append(live, trainNumber)
+	}
+	if len(expired) > 0 {
+		s.client.SRem(ctx, liveTrainsIndexKey, expired...)
+		s.client.ZRem(ctx, liveTrainsGeoKey, expired...)
+	}
+	return live, nil
+}
+
+func (s *redisLiveStore) PutSpotter(spotter SpotterLocation) error {
+	ctx := context.Background()
+	key := fmt.Sprintf("spotter_location:%d", spotter.UserID)
+
+	data, err := json.Marshal(spotter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spotter data: %v", err)
+	}
+
+	// Store with expiration (auto-cleanup) and index by last update
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, key, data, spotterLocationTTL)
+	pipe.ZAdd(ctx, activeSpottersIndexKey, redis.Z{Score: float64(spotter.LastUpdate), Member: spotter.UserID})
+	if math.Abs(spotter.Latitude) <= maxGeoLatitude {
+		pipe.GeoAdd(ctx, spottersGeoKey, &redis.GeoLocation{
+			Name:      strconv.FormatUint(uint64(spotter.UserID), 10),
+			Longitude: spotter.Longitude,
+			Latitude:  spotter.Latitude,
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *redisLiveStore) ListSpotters() ([]SpotterLocation, error) {
+	ctx := context.Background()
+
+	// Prune spotters whose last update is older than the location TTL, then read the rest of the index
+	cutoff := time.Now().Add(-spotterLocationTTL).UnixMilli()
+	if err := s.client.ZRemRangeByScore(ctx, activeSpottersIndexKey, "-inf", fmt.Sprintf("(%d", cutoff)).Err(); err != nil {
+		fmt.Printf("WARNING: Failed to prune spotter index: %v\n", err)
+	}
+	userIDs, err := s.client.ZRange(ctx, activeSpottersIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spotter index: %v", err)
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = fmt.Sprintf("spotter_location:%s", userID)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spotter locations: %v", err)
+	}
+
+	var spotters []SpotterLocation
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, userIDs[i]) // Key expired before the index caught up
+			continue
+		}
+
+		var spotter SpotterLocation
+		if err := json.Unmarshal([]byte(data), &spotter); err != nil {
+			continue // Skip malformed data
+		}
+		spotters = append(spotters, spotter)
+	}
+
+	if len(expired) > 0 {
+		s.client.ZRem(ctx, activeSpottersIndexKey, expired...)
+		s.client.ZRem(ctx, spottersGeoKey, expired...)
+	}
+	return spotters, nil
+}
+
+// geoSearch returns the members of a GEO index within radiusKm, nearest first
+func (s *redisLiveStore) geoSearch(key string, lat, lng, radiusKm float64, limit int) ([]redis.GeoLocation, error) {
+	return s.client.GeoSearchLocation(context.Background(), key, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  lng,
+			Latitude:   lat,
+			Radius:     radiusKm,
+			RadiusUnit: "km",
+			Sort:       "ASC",
+			Count:      limit,
+		},
+		WithDist: true,
+	}).Result()
+}
+
+func (s *redisLiveStore) NearbySpotters(lat, lng, radiusKm float64, limit int) ([]NearbySpotter, error) {
+	ctx := context.Background()
+	locations, err := s.geoSearch(spottersGeoKey, lat, lng, radiusKm, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search spotter GEO index: %v", err)
+	}
+	if len(locations) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(locations))
+	for i, location := range locations {
+		keys[i] = fmt.Sprintf("spotter_location:%s", location.Name)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spotter locations: %v", err)
+	}
+
+	var nearby []NearbySpotter
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, locations[i].Name) // Key expired, the GEO index has no TTL
+			continue
+		}
+
+		var spotter SpotterLocation
+		if err := json.Unmarshal([]byte(data), &spotter); err != nil {
+			continue // Skip malformed data
+		}
+		nearby = append(nearby, NearbySpotter{Spotter: spotter, DistanceKm: locations[i].Dist})
+	}
+
+	if len(expired) > 0 {
+		s.client.ZRem(ctx, spottersGeoKey, expired...)
+		s.client.ZRem(ctx, activeSpottersIndexKey, expired...)
+	}
+	return nearby, nil
+}
+
+func (s *redisLiveStore) NearbyTrains(lat, lng, radiusKm float64, limit int) ([]NearbyTrain, error) {
+	ctx := context.Background()
+	locations, err := s.geoSearch(liveTrainsGeoKey, lat, lng, radiusKm, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search train GEO index: %v", err)
+	}
+	if len(locations) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(locations))
+	for i, location := range locations {
+		keys[i] = fmt.Sprintf("train_live:%s", location.Name)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get train data from Redis: %v", err)
+	}
+
+	var nearby []NearbyTrain
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, locations[i].Name) // Key expired without a final write
+			continue
+		}
+
+		var trainData models.TrainData
+		if err := json.Unmarshal([]byte(data), &trainData); err != nil {
+			continue // Skip malformed data
+		}
+		nearby = append(nearby, NearbyTrain{Train: &trainData, DistanceKm: locations[i].Dist})
+	}
+
+	if len(expired) > 0 {
+		s.client.ZRem(ctx, liveTrainsGeoKey, expired...)
+		s.client.SRem(ctx, liveTrainsIndexKey, expired...)
+	}
+	return nearby, nil
+}
+
+func (s *redisLiveStore) NewTrainLock(trainNumber string) TrainLock {
+	return newTrainMutex(trainNumber, s.client)
+}
+
+func (s *redisLiveStore) Publish(event LiveEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal live event: %v", err)
+	}
+	return s.client.Publish(context.Background(), liveEventsChannel, payload).Err()
+}
+
+// Subscribe delivers events until ctx is cancelled or the subscription closes
+func (s *redisLiveStore) Subscribe(ctx context.Context) (<-chan LiveEvent, error) {
+	pubsub := s.client.Subscribe(ctx, liveEventsChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", liveEventsChannel, err)
+	}
+
+	events := make(chan LiveEvent)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		// The channel reconnects on its own and only closes with the subscription
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event LiveEvent
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil || event.TrainNumber == "" {
+					fmt.Printf("WARNING: Ignoring malformed live event: %s\n", message.Payload)
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils"
+)
+
+// Train file read-modify-write retries when another writer changed the file in between
+const (
+	trainFileMaxAttempts = 5
+	trainFileRetryDelay  = 100 * time.Millisecond // multiplied by the attempt number
+)
+
+// s3LiveStore is the legacy mode: train aggregates are the trains/train-<n>.json files with the
+// passengers kept inside, so there are no separate session positions, path history or spotters.
+// Locks are in-process only and events are not fanned out.
+type s3LiveStore struct {
+	s3 *utils.S3Client
+}
+
+// s3TrainFile is a stored train file: the train data plus the fencing token of the last fenced
+// write. Readers of the file ignore the extra field.
+type s3TrainFile struct {
+	*models.TrainData
+	Fence int64 `json:"fence,omitempty"`
+}
+
+// NewS3LiveStore creates a live store backed by the S3 train files
+func NewS3LiveStore(s3Client *utils.S3Client) LiveStore {
+	return &s3LiveStore{s3: s3Client}
+}
+
+func (s *s3LiveStore) Name() string {
+	return "s3"
+}
+
+func (s *s3LiveStore) TracksSessions() bool {
+	return false
+}
+
+func (s *s3LiveStore) GetSession(sessionID string) (map[string]interface{}, error) {
+	return nil, ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) SetSession(sessionID string, data map[string]interface{}) error {
+	return ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) TouchSession(sessionID string) (bool, error) {
+	return false, ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) DeleteSession(sessionID string) error {
+	return nil // Nothing stored per session
+}
+
+func (s *s3LiveStore) AppendPath(sessionID string, points ...GPSPoint) error {
+	return ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) GetPath(sessionID string) ([]GPSPoint, error) {
+	return nil, ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) GetTrain(trainNumber string) (*models.TrainData, error) {
+	return s.s3.GetTrainData(s3TrainFileName(trainNumber))
+}
+
+func (s *s3LiveStore) PutTrain(trainNumber string, trainData *models.TrainData, fence int64) error {
+	_, err := s.modifyTrain(trainNumber, fence, func(*models.TrainData) (*models.TrainData, error) {
+		return trainData, nil
+	})
+	return err
+}
+
+func (s *s3LiveStore) DeleteTrain(trainNumber string, fence int64) error {
+	_, err := s.modifyTrain(trainNumber, fence, func(*models.TrainData) (*models.TrainData, error) {
+		return nil, nil
+	})
+	return err
+}
+
+// modifyTrain applies modify to the current train file and writes the result back only if
+// nobody else wrote the file in between (ETag check), retrying with a fresh read on conflict.
+// modify gets nil when the file doesn't exist yet and returns nil to delete the file.
+// A fenced write (fence > 0) is rejected when the file was written with a newer token; the
+// token is kept in the file, so it is checked under the same ETag condition as the write.
+// Returns the written train data (nil when deleted).
+func (s *s3LiveStore) modifyTrain(trainNumber string, fence int64, modify func(trainData *models.TrainData) (*models.TrainData, error)) (*models.TrainData, error) {
+	fileName := s3TrainFileName(trainNumber)
+
+	for attempt := 1; ; attempt++ {
+		var current s3TrainFile
+		etag, err := s.s3.GetJSONWithETag(fileName, &current)
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return nil, fmt.Errorf("failed to read train file %s: %v", fileName, err)
+		}
+		if fence > 0 && fence < current.Fence {
+			return nil, fmt.Errorf("stale fencing token %d for train %s, newer data already written", fence, trainNumber)
+		}
+
+		updated, err := modify(current.TrainData)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case updated != nil:
+			if fence < current.Fence {
+				fence = current.Fence // unfenced writes keep the last token
+			}
+			_, err = s.s3.UploadJSONIfMatch(fileName, s3TrainFile{TrainData: updated, Fence: fence}, etag)
+		case etag != "":
+			// Unlike in Redis, the token goes with the file - a new file starts unfenced
+			err = s.s3.DeleteFileIfMatch(fileName, etag)
+		default:
+			return nil, nil // Nothing to delete
+		}
+		if err == nil {
+			return updated, nil
+		}
+		if !errors.Is(err, utils.ErrPreconditionFailed) || attempt == trainFileMaxAttempts {
+			return nil, err
+		}
+
+		fmt.Printf("DEBUG: Train file %s changed concurrently, retrying (attempt %d/%d)\n", fileName, attempt, trainFileMaxAttempts)
+		time.Sleep(time.Duration(attempt) * trainFileRetryDelay)
+	}
+}
+
+func (s *s3LiveStore) ListTrains() ([]string, error) {
+	keys, err := s.s3.ListFiles("trains/")
+	if err != nil {
+		return nil, err
+	}
+
+	var trainNumbers []string
+	for _, key := range keys {
+		if trainNumber, ok := s3TrainNumber(key); ok {
+			trainNumbers = append(trainNumbers, trainNumber)
+		}
+	}
+	return trainNumbers, nil
+}
+
+func (s *s3LiveStore) PutSpotter(spotter SpotterLocation) error {
+	return ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) ListSpotters() ([]SpotterLocation, error) {
+	return nil, ErrLiveStoreUnsupported
+}
+
+func (s *s3LiveStore) NearbySpotters(lat, lng, radiusKm float64, limit int) ([]NearbySpotter, error) {
+	return nil, ErrLiveStoreUnsupported
+}
+
+// NearbyTrains reads every train file - fine for the few trains of a legacy deployment
+func (s *s3LiveStore) NearbyTrains(lat, lng, radiusKm float64, limit int) ([]NearbyTrain, error) {
+	return nearbyTrainsByScan(s, lat, lng, radiusKm, limit)
+}
+
+func (s *s3LiveStore) NewTrainLock(trainNumber string) TrainLock {
+	return newTrainMutex(trainNumber, nil)
+}
+
+func (s *s3LiveStore) Publish(event LiveEvent) error {
+	return nil // Single instance - clients pick changes up from the periodic snapshot
+}
+
+func (s *s3LiveStore) Subscribe(ctx context.Context) (<-chan LiveEvent, error) {
+	return nil, ErrLiveStoreUnsupported
+}
+
+// s3TrainFileName returns the S3 key of a train's file
+func s3TrainFileName(trainNumber string) string {
+	return fmt.Sprintf("trains/train-%s.json", trainNumber)
+}
+
+// s3TrainNumber returns the train number of a train file key
+func s3TrainNumber(fileName string) (string, bool) {
+	if !strings.HasPrefix(fileName, "trains/train-") || !strings.HasSuffix(fileName, ".json") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(fileName, "trains/train-"), ".json"), true
+}
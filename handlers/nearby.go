@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Proximity query limits
+const (
+	nearbyDefaultRadiusKm = 10.0
+	nearbyMaxRadiusKm     = 200.0
+	nearbyDefaultLimit    = 50
+	nearbyMaxLimit        = 200
+)
+
+// nearbyQuery is the point and radius of a proximity query
+type nearbyQuery struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+	Limit    int
+}
+
+// parseNearbyQuery reads lat, lng, radius (km) and limit query parameters
+func parseNearbyQuery(c *gin.Context) (nearbyQuery, error) {
+	query := nearbyQuery{RadiusKm: nearbyDefaultRadiusKm, Limit: nearbyDefaultLimit}
+
+	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return query, fmt.Errorf("lat must be between -90 and 90")
+	}
+	lng, err := strconv.ParseFloat(c.Query("lng"), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return query, fmt.Errorf("lng must be between -180 and 180")
+	}
+	query.Lat = lat
+	query.Lng = lng
+
+	if radiusParam := c.Query("radius"); radiusParam != "" {
+		radius, err := strconv.ParseFloat(radiusParam, 64)
+		if err != nil || radius <= 0 || radius > nearbyMaxRadiusKm {
+			return query, fmt.Errorf("radius must be between 0 and %g km", nearbyMaxRadiusKm)
+		}
+		query.RadiusKm = radius
+	}
+
+	if limitParam := c.Query("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > nearbyMaxLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", nearbyMaxLimit)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// NearbyTrainResponse is a live train near the queried point. Passenger positions are left out -
+// only the aggregated train position is public.
+type NearbyTrainResponse struct {
+	TrainNumber      string             `json:"train_number"`
+	TrainName        string             `json:"train_name,omitempty"`
+	Relation         *string            `json:"relation,omitempty"`
+	TrainType        *string            `json:"train_type,omitempty"`
+	Position         models.Position    `json:"position"`
+	ConfidenceRadius *float64           `json:"confidence_radius,omitempty"`
+	RouteMatch       *models.RouteMatch `json:"route_match,omitempty"`
+	PassengerCount   int                `json:"passenger_count"`
+	DistanceKm       float64            `json:"distance_km"`
+	LastUpdate       string             `json:"last_update"`
+}
+
+// GetNearbyTrains - Public API endpoint returning live trains around a point, nearest first
+func (h *SimpleLiveTrackingHandler) GetNearbyTrains(c *gin.Context) {
+	query, err := parseNearbyQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	nearby, err := h.store.NearbyTrains(query.Lat, query.Lng, query.RadiusKm, query.Limit)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to find trains near (%.6f, %.6f): %v\n", query.Lat, query.Lng, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to find nearby trains",
+		})
+		return
+	}
+
+	trains := make([]NearbyTrainResponse, 0, len(nearby))
+	for _, train := range nearby {
+		trains = append(trains, NearbyTrainResponse{
+			TrainNumber:      train.Train.TrainID,
+			TrainName:        train.Train.TrainName,
+			Relation:         train.Train.Relation,
+			TrainType:        train.Train.TrainType,
+			Position:         train.Train.AveragePosition,
+			ConfidenceRadius: train.Train.ConfidenceRadius,
+			RouteMatch:       train.Train.RouteMatch,
+			PassengerCount:   train.Train.PassengerCount,
+			DistanceKm:       train.DistanceKm,
+			LastUpdate:       train.Train.LastUpdate,
+		})
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"trains":       trains,
+		"total":        len(trains),
+		"radius_km":    query.RadiusKm,
+		"last_updated": time.Now().Format(time.RFC3339),
+	})
+}
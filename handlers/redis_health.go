@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Timeout of one health check ping
+const redisHealthPingTimeout = 2 * time.Second
+
+// RedisHealthMonitor pings Redis in the background and acts as a circuit breaker: after
+// failureThreshold failed pings in a row the circuit opens and handlers switch to fallback mode,
+// after recoveryThreshold successful pings in a row it closes again and Redis is used again.
+// While open, requests never touch Redis, so they don't pay its timeouts.
+type RedisHealthMonitor struct {
+	client            *redis.Client
+	interval          time.Duration
+	failureThreshold  int
+	recoveryThreshold int
+
+	healthy   atomic.Bool
+	mutex     sync.Mutex // protects everything below
+	failures  int        // consecutive failed pings
+	successes int        // consecutive successful pings
+	lastError string
+	lastCheck time.Time
+	changedAt time.Time
+	listeners []func(healthy bool)
+}
+
+// NewRedisHealthMonitor creates a monitor and checks Redis once, so the initial mode is known
+// before handlers are set up
+func NewRedisHealthMonitor(client *redis.Client, interval time.Duration, failureThreshold, recoveryThreshold int) *RedisHealthMonitor {
+	m := &RedisHealthMonitor{
+		client:            client,
+		interval:          interval,
+		failureThreshold:  failureThreshold,
+		recoveryThreshold: recoveryThreshold,
+		changedAt:         time.Now(),
+	}
+
+	err := m.ping()
+	m.healthy.Store(err == nil)
+	m.lastCheck = time.Now()
+	if err != nil {
+		m.lastError = err.Error()
+		fmt.Printf("WARNING: Redis unavailable at startup, circuit open (fallback mode): %v\n", err)
+	}
+
+	return m
+}
+
+// Start starts the background health checks
+func (m *RedisHealthMonitor) Start() {
+	go m.run()
+}
+
+// Healthy reports whether the circuit is closed and Redis should be used
+func (m *RedisHealthMonitor) Healthy() bool {
+	return m.healthy.Load()
+}
+
+// Client returns the monitored Redis client
+func (m *RedisHealthMonitor) Client() *redis.Client {
+	return m.client
+}
+
+// OnChange registers a callback run (in the monitor goroutine) whenever the circuit opens or closes
+func (m *RedisHealthMonitor) OnChange(listener func(healthy bool)) {
+	m.mutex.Lock()
+	m.listeners = append(m.listeners, listener)
+	m.mutex.Unlock()
+}
+
+// Status returns the circuit state for health output
+func (m *RedisHealthMonitor) Status() map[string]interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	circuit := "closed"
+	if !m.Healthy() {
+		circuit = "open"
+	}
+	status := map[string]interface{}{
+		"healthy":              m.Healthy(),
+		"circuit":              circuit,
+		"consecutive_failures": m.failures,
+		"last_check":           m.lastCheck.Format(time.RFC3339),
+		"since":                m.changedAt.Format(time.RFC3339),
+	}
+	if m.lastError != "" {
+		status["last_error"] = m.lastError
+	}
+	return status
+}
+
+func (m *RedisHealthMonitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	fmt.Printf("INFO: Started Redis health monitor (interval: %s, open after %d failures, close after %d successes)\n",
+		m.interval, m.failureThreshold, m.recoveryThreshold)
+
+	for range ticker.C {
+		m.check()
+	}
+}
+
+func (m *RedisHealthMonitor) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisHealthPingTimeout)
+	defer cancel()
+	return m.client.Ping(ctx).Err()
+}
+
+// check pings Redis and opens or closes the circuit once the threshold is reached
+func (m *RedisHealthMonitor) check() {
+	err := m.ping()
+
+	m.mutex.Lock()
+	m.lastCheck = time.Now()
+	wasHealthy := m.Healthy()
+	if err != nil {
+		m.failures++
+		m.successes = 0
+		m.lastError = err.Error()
+	} else {
+		m.successes++
+		m.failures = 0
+	}
+
+	changed := false
+	if wasHealthy && m.failures >= m.failureThreshold {
+		m.healthy.Store(false)
+		changed = true
+		fmt.Printf("ERROR: Redis failed %d health checks, circuit open - switching to fallback mode: %v\n", m.failures, err)
+	} else if !wasHealthy && m.successes >= m.recoveryThreshold {
+		m.healthy.Store(true)
+		m.lastError = ""
+		changed = true
+		fmt.Printf("INFO: Redis recovered, circuit closed - switching back to Redis\n")
+	}
+	if changed {
+		m.changedAt = time.Now()
+	}
+	listeners := m.listeners
+	m.mutex.Unlock()
+
+	if changed {
+		for _, listener := range listeners {
+			listener(m.Healthy())
+		}
+	}
+}
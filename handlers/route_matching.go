@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Map-matching parameters
+const (
+	routeMaxSnapDistanceM    = 1000.0           // positions further from the route are not matched
+	routeMaxStationOffsetM   = 2000.0           // schedule stations further from the route are ignored
+	routeGeometryCacheTTL    = 30 * time.Minute // geometry rarely changes, but new routes should show up
+	metersPerDegreeLatitude  = 110540.0
+	metersPerDegreeLongitude = 111320.0 // at the equator, scaled by cos(latitude)
+)
+
+// trainRoute is a train's operational route geometry, oriented in the train's direction of travel
+type trainRoute struct {
+	operationalRouteID uint
+	operationalRoute   *models.OperationalRouteSummary
+	points             []models.Position
+	chainagesKm        []float64 // cumulative distance along the route at each point
+	stations           []models.RouteStation
+	stops              []routeStop // full schedule in stop order, used for delay estimation
+	originTimeOfDay    int64       // first scheduled time in seconds after midnight, -1 without timetable
+	loadedAt           time.Time
+}
+
+// routeMatcher snaps train positions onto their operational route, caching route geometry per train number
+type routeMatcher struct {
+	db         *gorm.DB
+	cache      map[string]*trainRoute
+	cacheMutex sync.RWMutex
+}
+
+func newRouteMatcher(db *gorm.DB) *routeMatcher {
+	return &routeMatcher{
+		db:    db,
+		cache: make(map[string]*trainRoute),
+	}
+}
+
+// match snaps a position onto the train's route. Returns nil when the train has no route
+// geometry or the position is too far from it.
+func (m *routeMatcher) match(trainNumber string, position models.Position) *models.RouteMatch {
+	route := m.getRoute(trainNumber)
+	if route == nil || len(route.points) < 2 {
+		return nil
+	}
+
+	snapped, chainageKm, offsetM := route.project(position)
+	if offsetM > routeMaxSnapDistanceM {
+		return nil
+	}
+
+	result := &models.RouteMatch{
+		OperationalRouteID: route.operationalRouteID,
+		SnappedPosition:    snapped,
+		ChainageKm:         math.Round(chainageKm*1000) / 1000,
+		DistanceFromRouteM: math.Round(offsetM*10) / 10,
+	}
+	for i := range route.stations {
+		station := route.stations[i]
+		if station.ChainageKm <= chainageKm {
+			result.PreviousStation = &station
+			continue
+		}
+		result.NextStation = &station
+		distanceToNext := math.Round((station.ChainageKm-chainageKm)*1000) / 1000
+		result.DistanceToNextStationKm = &distanceToNext
+		break
+	}
+
+	return result
+}
+
+// operationalRoute returns the operational route the train runs on, or nil when unknown
+func (m *routeMatcher) operationalRoute(trainNumber string) *models.OperationalRouteSummary {
+	route := m.getRoute(trainNumber)
+	if route == nil {
+		return nil
+	}
+	return route.operationalRoute
+}
+
+// getRoute returns the cached route for a train, loading it from the database when missing or stale
+func (m *routeMatcher) getRoute(trainNumber string) *trainRoute {
+	m.cacheMutex.RLock()
+	cached, exists := m.cache[trainNumber]
+	m.cacheMutex.RUnlock()
+	if exists && time.Since(cached.loadedAt) < routeGeometryCacheTTL {
+		return cached
+	}
+
+	route, err := m.loadRoute(trainNumber)
+	if err != nil {
+		fmt.Printf("DEBUG: No route geometry for train %s: %v\n", trainNumber, err)
+		// Cache the miss too, so every location update doesn't query the database again
+		route = &trainRoute{}
+	}
+	route.loadedAt = time.Now()
+
+	m.cacheMutex.Lock()
+	m.cache[trainNumber] = route
+	m.cacheMutex.Unlock()
+
+	return route
+}
+
+// loadRoute finds the operational route connecting the train's first and last scheduled stations
+// and builds its geometry from the railway lines in pivot sequence order
+func (m *routeMatcher) loadRoute(trainNumber string) (*trainRoute, error) {
+	var train models.Train
+	if err := m.db.Where("train_number = ?", trainNumber).First(&train).Error; err != nil {
+		return nil, fmt.Errorf("train not found: %v", err)
+	}
+
+	var schedule []models.ScheduleDetail
+	if err := m.db.Preload("Station").
+		Where("train_id = ?", train.TrainID).
+		Order("stop_sequence").
+		Find(&schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to load schedule: %v", err)
+	}
+	if len(schedule) < 2 {
+		return nil, fmt.Errorf("train has fewer than 2 scheduled stops")
+	}
+	origin := schedule[0].StationID
+	destination := schedule[len(schedule)-1].StationID
+
+	var operationalRoute models.OperationalRoute
+	if err := m.db.Where("status = ?", "active").
+		Where("(start_station_id = ? AND end_station_id = ?) OR (start_station_id = ? AND end_station_id = ?)",
+			origin, destination, destination, origin).
+		First(&operationalRoute).Error; err != nil {
+		return nil, fmt.Errorf("no operational route between stations %d and %d: %v", origin, destination, err)
+	}
+
+	var lines []struct {
+		models.RailwayLine
+		Sequence   *int  `gorm:"column:sequence"`
+		IsReversed *bool `gorm:"column:is_reversed"`
+	}
+	if err := m.db.Table("operational_route_railway_line").
+		Select("railway_lines.*, operational_route_railway_line.sequence, operational_route_railway_line.is_reversed").
+		Joins("JOIN railway_lines ON railway_lines.id = operational_route_railway_line.railway_line_id").
+		Where("operational_route_railway_line.operational_route_id = ?", operationalRoute.ID).
+		Order("operational_route_railway_line.sequence").
+		Find(&lines).Error; err != nil {
+		return nil, fmt.Errorf("failed to load railway lines: %v", err)
+	}
+
+	// GeoJSON coordinates are [lng, lat]
+	var points []models.Position
+	for _, line := range lines {
+		if line.Geometry == nil {
+			continue
+		}
+		coordinates := line.Geometry.Coordinates
+		reversed := line.IsReversed != nil && *line.IsReversed
+		for i := range coordinates {
+			coordinate := coordinates[i]
+			if reversed {
+				coordinate = coordinates[len(coordinates)-1-i]
+			}
+			if len(coordinate) < 2 {
+				continue
+			}
+			points = append(points, models.Position{Lat: coordinate[1], Lng: coordinate[0]})
+		}
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("operational route %d has no geometry", operationalRoute.ID)
+	}
+
+	// Orient the route in the train's direction of travel
+	if operationalRoute.StartStationID != nil && *operationalRoute.StartStationID == destination {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+
+	route := &trainRoute{
+		operationalRouteID: operationalRoute.ID,
+		operationalRoute: &models.OperationalRouteSummary{
+			ID:   operationalRoute.ID,
+			Code: operationalRoute.OperationalRouteCode,
+			Name: operationalRoute.Name,
+		},
+		points:      points,
+		chainagesKm: make([]float64, len(points)),
+	}
+	for i := 1; i < len(points); i++ {
+		route.chainagesKm[i] = route.chainagesKm[i-1] +
+			calculateDistance(points[i-1].Lat, points[i-1].Lng, points[i].Lat, points[i].Lng)
+	}
+
+	// Place the scheduled stations along the route
+	route.stops, route.originTimeOfDay = buildRouteStops(schedule)
+	for i, stop := range schedule {
+		if stop.Station.Latitude == nil || stop.Station.Longitude == nil {
+			continue
+		}
+		_, chainageKm, offsetM := route.project(models.Position{Lat: *stop.Station.Latitude, Lng: *stop.Station.Longitude})
+		if offsetM > routeMaxStationOffsetM {
+			continue
+		}
+		station := models.RouteStation{
+			StationID:   stop.StationID,
+			StationCode: stop.Station.StationCode,
+			StationName: stop.Station.StationName,
+			ChainageKm:  math.Round(chainageKm*1000) / 1000,
+		}
+		route.stations = append(route.stations, station)
+		route.stops[i].onRoute = true
+		route.stops[i].chainageKm = station.ChainageKm
+	}
+	sort.SliceStable(route.stations, func(i, j int) bool {
+		return route.stations[i].ChainageKm < route.stations[j].ChainageKm
+	})
+
+	fmt.Printf("DEBUG: Loaded route geometry for train %s: operational route %d, %d points, %.1f km, %d stations\n",
+		trainNumber, operationalRoute.ID, len(points), route.chainagesKm[len(points)-1], len(route.stations))
+
+	return route, nil
+}
+
+// project finds the nearest point on the route, returning it with its chainage (km) and offset (m)
+func (r *trainRoute) project(position models.Position) (models.Position, float64, float64) {
+	bestOffset := math.Inf(1)
+	var bestPoint models.Position
+	var bestChainage float64
+
+	for i := 1; i < len(r.points); i++ {
+		start, end := r.points[i-1], r.points[i]
+
+		// Local equirectangular projection around the segment start, in meters
+		scaleLng := metersPerDegreeLongitude * math.Cos(start.Lat*math.Pi/180)
+		dx := (end.Lng - start.Lng) * scaleLng
+		dy := (end.Lat - start.Lat) * metersPerDegreeLatitude
+		px := (position.Lng - start.Lng) * scaleLng
+		py := (position.Lat - start.Lat) * metersPerDegreeLatitude
+
+		t := 0.0
+		if segmentLengthSq := dx*dx + dy*dy; segmentLengthSq > 0 {
+			t = math.Max(0, math.Min(1, (px*dx+py*dy)/segmentLengthSq))
+		}
+		offset := math.Hypot(px-t*dx, py-t*dy)
+
+		if offset < bestOffset {
+			bestOffset = offset
+			bestPoint = models.Position{
+				Lat: start.Lat + t*(end.Lat-start.Lat),
+				Lng: start.Lng + t*(end.Lng-start.Lng),
+			}
+			bestChainage = r.chainagesKm[i-1] + t*(r.chainagesKm[i]-r.chainagesKm[i-1])
+		}
+	}
+
+	return bestPoint, bestChainage, bestOffset
+}
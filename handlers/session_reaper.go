@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// StartSessionReaper starts a background goroutine that expires sessions whose app stopped
+// sending heartbeats for longer than threshold. It stops when ctx is cancelled.
+func (h *SimpleLiveTrackingHandler) StartSessionReaper(ctx context.Context, threshold, interval time.Duration, autoSaveTrip bool) {
+	go h.runSessionReaper(ctx, threshold, interval, autoSaveTrip)
+}
+
+// runSessionReaper checks for stale sessions on every tick
+func (h *SimpleLiveTrackingHandler) runSessionReaper(ctx context.Context, threshold, interval time.Duration, autoSaveTrip bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Printf("INFO: Started stale session reaper (threshold: %s, interval: %s, auto-save trip: %t)\n",
+		threshold, interval, autoSaveTrip)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("INFO: Stopped stale session reaper\n")
+			return
+		case <-ticker.C:
+			h.reapStaleSessions(threshold, autoSaveTrip)
+		}
+	}
+}
+
+// reapStaleSessions moves active sessions without a recent heartbeat to "expired" and removes
+// them from live data (live store sessions, train aggregates and S3 train files)
+func (h *SimpleLiveTrackingHandler) reapStaleSessions(threshold time.Duration, autoSaveTrip bool) {
+	cutoff := time.Now().Add(-threshold)
+
+	var staleSessions []models.LiveTrackingSession
+	if err := h.db.Where("status = ? AND last_heartbeat < ?", "active", cutoff).Find(&staleSessions).Error; err != nil {
+		fmt.Printf("ERROR: Session reaper failed to query stale sessions: %v\n", err)
+		return
+	}
+
+	expiredCount := 0
+	tripsSaved := 0
+	for _, session := range staleSessions {
+		// Conditional update so only one instance (or request) wins if the session changes concurrently
+		result := h.db.Model(&models.LiveTrackingSession{}).
+			Where("id = ? AND status = ? AND last_heartbeat < ?", session.ID, "active", cutoff).
+			Updates(map[string]interface{}{"status": "expired", "updated_at": time.Now()})
+		if result.Error != nil {
+			fmt.Printf("ERROR: Session reaper failed to expire session %s: %v\n", session.SessionID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue // Session was stopped, recovered or expired elsewhere
+		}
+		expiredCount++
+
+		fmt.Printf("DEBUG: Expiring session %s (user %d, train %s, last heartbeat %s)\n",
+			session.SessionID, session.UserID, session.TrainNumber, session.LastHeartbeat.Format(time.RFC3339))
+
+		// Save the trip from whatever path is available before live data is removed
+		if autoSaveTrip {
+			var existingTrips int64
+			h.db.Model(&models.Trip{}).Where("session_id = ?", session.SessionID).Count(&existingTrips)
+			if existingTrips == 0 {
+				if tripID, reason := h.saveUserTrip(session, session.UserID, nil, nil, &StationInfo{}); tripID != nil {
+					tripsSaved++
+					fmt.Printf("DEBUG: Auto-saved trip %d for expired session %s\n", *tripID, session.SessionID)
+				} else {
+					fmt.Printf("WARNING: Could not auto-save trip for expired session %s: %s\n", session.SessionID, reason)
+				}
+			}
+		}
+
+		h.removeEndedSessionLiveData(session, liveEventSessionExpired)
+	}
+
+	if expiredCount > 0 {
+		fmt.Printf("INFO: Session reaper expired %d stale sessions (%d trips auto-saved)\n", expiredCount, tripsSaved)
+	}
+}
+
+// removeEndedSessionLiveData drops a session that ended without StopMobileSession (expired or
+// terminated) from the live store and the S3 train file, and publishes eventType for it
+func (h *SimpleLiveTrackingHandler) removeEndedSessionLiveData(session models.LiveTrackingSession, eventType string) {
+	h.forgetGPSRejectStreak(session.SessionID)
+
+	// Get train-specific mutex to prevent race conditions with other users on same train
+	trainMutex := h.getTrainMutex(session.TrainNumber)
+	if err := trainMutex.Lock(); err != nil {
+		// Live data expires on its own (liveSessionTTL) if it can't be removed now
+		fmt.Printf("WARNING: Failed to lock train %s to remove ended session %s: %v\n", session.TrainNumber, session.SessionID, err)
+		return
+	}
+	defer trainMutex.Unlock()
+
+	if h.store.TracksSessions() {
+		// Removes the session position and path history and rebuilds the train aggregate without this session
+		if err := h.cleanupLiveSession(session.SessionID, session.TrainNumber, trainMutex); err != nil {
+			fmt.Printf("WARNING: Failed to clean up live store for ended session %s: %v\n", session.SessionID, err)
+		}
+		publishLiveEvent(h.store, LiveEvent{
+			Type:        eventType,
+			TrainNumber: session.TrainNumber,
+			SessionID:   session.SessionID,
+			UserID:      session.UserID,
+		})
+	}
+
+	if err := h.handleStopSessionS3Operations(session.FilePath, session.UserID, false, trainMutex); err != nil {
+		// Train file may not exist (live store mode between syncs) - nothing to remove
+		fmt.Printf("DEBUG: No S3 cleanup for ended session %s: %v\n", session.SessionID, err)
+	}
+}
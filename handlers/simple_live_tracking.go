@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 
 	"github.com/modernland/golang-live-tracking/models"
@@ -29,8 +30,9 @@ type UserStationCache struct {
 type SimpleLiveTrackingHandler struct {
 	db *gorm.DB
 	s3 *utils.S3Client
-	redis *redis.Client // Redis client for live tracking performance
-	trainMutexes map[string]*sync.Mutex // mutex per train to prevent race conditions
+	store LiveStore // live tracking state (Redis, S3 train files or in-memory)
+	trainFiles *s3LiveStore // S3 train files: legacy mode, live store fallback and the periodic backup
+	trainMutexes map[string]TrainLock // lock per train to prevent race conditions (across instances with Redis)
 	mutexLock    sync.RWMutex           // protect the trainMutexes map itself
 	trainsListMutex sync.Mutex          // dedicated mutex for trains-list.json updates
 	trainsListCache map[string]interface{} // in-memory cache for trains list
@@ -39,28 +41,99 @@ type SimpleLiveTrackingHandler struct {
 	// Cache for user and station data (key: userID)
 	userCache map[uint]*UserStationCache
 	userCacheMutex sync.RWMutex
+	// Cache for trains table rows (key: trainID)
+	trainCache map[uint]*models.Train
+	trainCacheMutex sync.RWMutex
+	// Consecutive implausible GPS points per session (key: sessionID)
+	gpsRejectStreak map[string]int
+	gpsRejectMutex  sync.Mutex
+	// Route geometry for map-matching train positions
+	routes *routeMatcher
+	// Historical archive of train snapshots and passenger points (nil when disabled)
+	archive *TrainArchiver
+	// Stops the background workers that only run while the live store tracks sessions
+	liveWorkersCancel context.CancelFunc
+	liveWorkersMutex  sync.Mutex
+	// Trip storage (see SetTripStorage)
+	routeToleranceMeters float64
+	tripDataInS3         bool
 }
 
 func NewSimpleLiveTrackingHandler(db *gorm.DB, s3Client *utils.S3Client) *SimpleLiveTrackingHandler {
+	trainFiles := &s3LiveStore{s3: s3Client}
 	return &SimpleLiveTrackingHandler{
 		db: db,
 		s3: s3Client,
-		trainMutexes: make(map[string]*sync.Mutex),
+		store: trainFiles,
+		trainFiles: trainFiles,
+		trainMutexes: make(map[string]TrainLock),
 		trainsListCache: make(map[string]interface{}),
 		userCache: make(map[uint]*UserStationCache),
+		trainCache: make(map[uint]*models.Train),
+		gpsRejectStreak: make(map[string]int),
+		routes: newRouteMatcher(db),
 	}
 }
 
-// SetRedisClient sets the Redis client for live tracking performance
-func (h *SimpleLiveTrackingHandler) SetRedisClient(redisClient *redis.Client) {
-	h.redis = redisClient
-	fmt.Printf("INFO: Redis client enabled for live tracking handler\n")
+// SetLiveStore sets where live tracking state is kept (defaults to the legacy S3 train files)
+func (h *SimpleLiveTrackingHandler) SetLiveStore(store LiveStore) {
+	h.liveWorkersMutex.Lock()
+	if h.liveWorkersCancel != nil {
+		// Stop the previous store's workers, RefreshLiveStoreMode starts them for this one
+		h.liveWorkersCancel()
+		h.liveWorkersCancel = nil
+	}
+	h.store = store
+	h.liveWorkersMutex.Unlock()
+	fmt.Printf("INFO: %s live store enabled for live tracking handler\n", store.Name())
+	
+	h.RefreshLiveStoreMode()
+}
+
+// RefreshLiveStoreMode starts the background workers when the live store tracks sessions and
+// stops them when it doesn't (legacy mode, or Redis fallback while its circuit is open)
+func (h *SimpleLiveTrackingHandler) RefreshLiveStoreMode() {
+	h.liveWorkersMutex.Lock()
+	defer h.liveWorkersMutex.Unlock()
+	
+	running := h.liveWorkersCancel != nil
+	if h.store.TracksSessions() == running {
+		return
+	}
+	
+	if running {
+		h.liveWorkersCancel()
+		h.liveWorkersCancel = nil
+		fmt.Printf("INFO: Stopped live store background workers (%s mode)\n", h.store.Name())
+		return
+	}
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	h.liveWorkersCancel = cancel
 	
-	// Start background cache updater if Redis is available
-	go h.startCacheUpdater()
+	// Start background cache updater
+	go h.startCacheUpdater(ctx)
 	
-	// Start Redis to S3 sync process (every 88 seconds)
-	go h.startRedisSyncToS3()
+	// Start live store to S3 sync process (every 88 seconds)
+	go h.startLiveSyncToS3(ctx)
+}
+
+// StopLiveWorkers stops the live store background workers for shutdown
+func (h *SimpleLiveTrackingHandler) StopLiveWorkers() {
+	h.liveWorkersMutex.Lock()
+	defer h.liveWorkersMutex.Unlock()
+	
+	if h.liveWorkersCancel != nil {
+		h.liveWorkersCancel()
+		h.liveWorkersCancel = nil
+		fmt.Printf("INFO: Stopped live store background workers (%s mode)\n", h.store.Name())
+	}
+}
+
+// SetArchiver enables archiving train snapshots and passenger points to S3
+func (h *SimpleLiveTrackingHandler) SetArchiver(archive *TrainArchiver) {
+	h.archive = archive
+	fmt.Printf("INFO: Train archive enabled for live tracking handler\n")
 }
 
 // getUserWithStation gets user data with station lookup, using cache for efficiency
@@ -110,26 +183,14 @@ func (h *SimpleLiveTrackingHandler) getUserWithStation(userID uint) *UserStation
 	return userCache
 }
 
-// preserveExistingStatus retrieves and preserves the last status from Redis
+// preserveExistingStatus retrieves and preserves the last status from the live store
 func (h *SimpleLiveTrackingHandler) preserveExistingStatus(sessionID string, sessionData map[string]interface{}) error {
-	if h.redis == nil {
-		return fmt.Errorf("Redis not available")
-	}
-
-	ctx := context.Background()
-	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
-	
-	// Get existing session data from Redis
-	existingDataStr, err := h.redis.Get(ctx, sessionKey).Result()
+	// Get existing session data from the live store
+	existingData, err := h.store.GetSession(sessionID)
 	if err != nil {
 		return fmt.Errorf("failed to get existing session: %v", err)
 	}
 
-	var existingData map[string]interface{}
-	if err := json.Unmarshal([]byte(existingDataStr), &existingData); err != nil {
-		return fmt.Errorf("failed to unmarshal existing session: %v", err)
-	}
-
 	// Preserve status fields if they exist
 	if statusEmoji, exists := existingData["status_emoji"]; exists {
 		sessionData["status_emoji"] = statusEmoji
@@ -147,22 +208,42 @@ func (h *SimpleLiveTrackingHandler) preserveExistingStatus(sessionID string, ses
 	return nil
 }
 
-// getTrainMutex returns a mutex for the specific train to prevent race conditions
-func (h *SimpleLiveTrackingHandler) getTrainMutex(trainNumber string) *sync.Mutex {
+// getTrainMutex returns a lock for the specific train to prevent race conditions.
+// With the Redis store the lock is also held in Redis, so other instances are excluded too.
+func (h *SimpleLiveTrackingHandler) getTrainMutex(trainNumber string) TrainLock {
 	h.mutexLock.Lock()
 	defer h.mutexLock.Unlock()
 	
 	if _, exists := h.trainMutexes[trainNumber]; !exists {
-		h.trainMutexes[trainNumber] = &sync.Mutex{}
+		h.trainMutexes[trainNumber] = h.store.NewTrainLock(trainNumber)
 	}
 	
 	return h.trainMutexes[trainNumber]
 }
 
+// modifyTrainFile applies modify to the train's S3 file (see s3LiveStore.modifyTrain), fenced
+// with the token of trainMutex, which the caller must hold. Nothing is written once the train
+// lock was lost (another instance may have written newer data meanwhile).
+func (h *SimpleLiveTrackingHandler) modifyTrainFile(trainNumber string, trainMutex TrainLock, modify func(trainData *models.TrainData) (*models.TrainData, error)) error {
+	updated, err := h.trainFiles.modifyTrain(trainNumber, trainMutex.Token(), func(trainData *models.TrainData) (*models.TrainData, error) {
+		updated, err := modify(trainData)
+		if err == nil && !trainMutex.Held() {
+			return nil, fmt.Errorf("train lock for %s was lost, not overwriting train file", trainNumber)
+		}
+		return updated, err
+	})
+	if err != nil {
+		return err
+	}
+	
+	h.archive.RecordSnapshot(updated)
+	return nil
+}
+
 // GetActiveTrainsList - Public API endpoint to serve active trains list (cached for performance)
 func (h *SimpleLiveTrackingHandler) GetActiveTrainsList(c *gin.Context) {
-	if h.redis != nil {
-		fmt.Printf("DEBUG: Frontend requesting active trains list via Redis cache\n")
+	if h.store.TracksSessions() {
+		fmt.Printf("DEBUG: Frontend requesting active trains list via cache (%s live store)\n", h.store.Name())
 	} else {
 		fmt.Printf("DEBUG: Frontend requesting active trains list via direct database query\n")
 	}
@@ -194,20 +275,16 @@ func (h *SimpleLiveTrackingHandler) GetActiveTrainsList(c *gin.Context) {
 	c.JSON(http.StatusOK, trainsListData)
 }
 
-// GetTrainData - Public API endpoint to serve individual train data (Redis-first with S3 fallback)
+// GetTrainData - Public API endpoint to serve individual train data (live store first with S3 fallback)
 func (h *SimpleLiveTrackingHandler) GetTrainData(c *gin.Context) {
 	trainNumber := c.Param("trainNumber")
 	
-	if h.redis != nil {
-		fmt.Printf("DEBUG: Frontend requesting train data for %s via Redis (with S3 fallback)\n", trainNumber)
-	} else {
-		fmt.Printf("DEBUG: Frontend requesting train data for %s via S3 (Redis disabled)\n", trainNumber)
-	}
+	fmt.Printf("DEBUG: Frontend requesting train data for %s via %s live store\n", trainNumber, h.store.Name())
 	
-	// Try to read train data from Redis first, fallback to S3
-	trainData, err := h.getTrainDataFromRedis(trainNumber)
+	// Try to read train data from the live store first, fallback to S3
+	trainData, err := getLiveTrainData(h.store, h.s3, trainNumber)
 	if err != nil {
-		fmt.Printf("DEBUG: Train %s not found in Redis or S3: %v\n", trainNumber, err)
+		fmt.Printf("DEBUG: Train %s not found in live store or S3: %v\n", trainNumber, err)
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Train not found",
 			"trainNumber": trainNumber,
@@ -215,6 +292,9 @@ func (h *SimpleLiveTrackingHandler) GetTrainData(c *gin.Context) {
 		return
 	}
 	
+	// Delay changes with time even when the position doesn't - estimate on every read
+	trainData.Delay = h.routes.estimateDelay(trainNumber, trainData.RouteMatch, time.Now())
+	
 	// Set proper CORS and cache headers
 	c.Header("Access-Control-Allow-Origin", "*")
 	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -289,13 +369,41 @@ func (h *SimpleLiveTrackingHandler) StartMobileSession(c *gin.Context) {
 		return
 	}
 
-	// Start database transaction for consistency
-	tx := h.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	// Only track trains that exist, are running, and match the requested number
+	train, err := h.loadTrain(req.TrainID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Train not found",
+			})
+		} else {
+			fmt.Printf("ERROR: Failed to look up train %d: %v\n", req.TrainID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to validate train",
+				"error":   err.Error(),
+			})
 		}
-	}()
+		return
+	}
+	if !train.IsActive {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"message": "Train is not active",
+		})
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(req.TrainNumber), strings.TrimSpace(train.TrainNumber)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success":      false,
+			"message":      "train_id and train_number do not match",
+			"train_number": train.TrainNumber,
+		})
+		return
+	}
+	// Use the canonical number - it keys the live store and S3 train data
+	req.TrainNumber = train.TrainNumber
 
 	// Terminate any existing sessions for this user (like Laravel)
 	h.terminateUserSessions(user.ID)
@@ -306,108 +414,117 @@ func (h *SimpleLiveTrackingHandler) StartMobileSession(c *gin.Context) {
 
 	// Get train-specific mutex to prevent race conditions
 	trainMutex := h.getTrainMutex(req.TrainNumber)
-	trainMutex.Lock()
+	if err := trainMutex.Lock(); err != nil {
+		fmt.Printf("ERROR: Failed to lock train %s: %v\n", req.TrainNumber, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Train is busy, please retry",
+			"error":   err.Error(),
+		})
+		return
+	}
 	defer trainMutex.Unlock()
 
-	fileName := fmt.Sprintf("trains/train-%s.json", req.TrainNumber)
-	
-	// Check if train file already exists and has other passengers
-	existingTrainData, err := h.s3.GetTrainData(fileName)
-	var trainData models.TrainData
+	// Start database transaction for consistency
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	fileName := s3TrainFileName(req.TrainNumber)
 	
-	if err != nil {
-		// New train file - create fresh data
-		fmt.Printf("DEBUG: Creating new train file for train %s\n", req.TrainNumber)
+	// Adds this user to the train file, creating it for the first passenger. Runs again on a
+	// fresh read when another writer changed the file in between.
+	joinTrainFile := func(existingTrainData *models.TrainData) (*models.TrainData, error) {
+		var trainData models.TrainData
+
+		if existingTrainData == nil {
+			// New train file - create fresh data
+			fmt.Printf("DEBUG: Creating new train file for train %s\n", req.TrainNumber)
 		
-		// Create passenger with username and station name
-		passenger := models.Passenger{
-			UserID:        user.ID,
-			UserType:      "authenticated", 
-			ClientType:    "mobile",
-			Lat:           req.InitialLat,
-			Lng:           req.InitialLng,
-			Timestamp:     time.Now().UnixMilli(),
-			SessionID:     sessionID,
-			SessionStatus: "active",
-		}
+			// Create passenger with username and station name
+			passenger := models.Passenger{
+				UserID:        user.ID,
+				UserType:      "authenticated", 
+				ClientType:    "mobile",
+				Lat:           req.InitialLat,
+				Lng:           req.InitialLng,
+				Timestamp:     time.Now().UnixMilli(),
+				SessionID:     sessionID,
+				SessionStatus: "active",
+			}
 		
-		// Add user details with cached station lookup
-		if userCache := h.getUserWithStation(user.ID); userCache != nil {
-			passenger.Name = userCache.Name
-			passenger.Username = userCache.Username
-			passenger.StationName = userCache.StationName
-		}
+			// Add user details with cached station lookup
+			if userCache := h.getUserWithStation(user.ID); userCache != nil {
+				passenger.Name = userCache.Name
+				passenger.Username = userCache.Username
+				passenger.StationName = userCache.StationName
+			}
 		
-		trainData = models.TrainData{
-			TrainID:         req.TrainNumber,
-			Route:           fmt.Sprintf("Route information for train %d", req.TrainID),
-			PassengerCount:  1,
-			AveragePosition: models.Position{Lat: req.InitialLat, Lng: req.InitialLng},
-			Passengers:      []models.Passenger{passenger},
-			LastUpdate: time.Now().Format(time.RFC3339),
-			Status:     "active",
-			DataSource: "live-gps",
-		}
-	} else {
-		// Train file exists - add this user to existing passengers
-		fmt.Printf("DEBUG: Adding user to existing train %s with %d passengers\n", req.TrainNumber, len(existingTrainData.Passengers))
-		trainData = *existingTrainData
+			trainData = models.TrainData{
+				TrainID:         req.TrainNumber,
+				PassengerCount:  1,
+				AveragePosition: models.Position{Lat: req.InitialLat, Lng: req.InitialLng},
+				Passengers:      []models.Passenger{passenger},
+				LastUpdate: time.Now().Format(time.RFC3339),
+				Status:     "active",
+				DataSource: "live-gps",
+			}
+			applyTrainMetadata(&trainData, train, h.routes)
+		} else {
+			// Train file exists - add this user to existing passengers
+			fmt.Printf("DEBUG: Adding user to existing train %s with %d passengers\n", req.TrainNumber, len(existingTrainData.Passengers))
+			trainData = *existingTrainData
 		
-		// Add new passenger
-		newPassenger := models.Passenger{
-			UserID:        user.ID,
-			UserType:      "authenticated", 
-			ClientType:    "mobile",
-			Lat:           req.InitialLat,
-			Lng:           req.InitialLng,
-			Timestamp:     time.Now().UnixMilli(),
-			SessionID:     sessionID,
-			SessionStatus: "active",
-		}
+			// Add new passenger
+			newPassenger := models.Passenger{
+				UserID:        user.ID,
+				UserType:      "authenticated", 
+				ClientType:    "mobile",
+				Lat:           req.InitialLat,
+				Lng:           req.InitialLng,
+				Timestamp:     time.Now().UnixMilli(),
+				SessionID:     sessionID,
+				SessionStatus: "active",
+			}
 		
-		// Add username and station name
-		if user.Username != nil {
-			newPassenger.Username = *user.Username
-		} else {
-			newPassenger.Username = user.Name
-		}
-		if user.StationName != nil {
-			newPassenger.StationName = *user.StationName
-		} else {
-			newPassenger.StationName = ""
-		}
+			// Add username and station name
+			if user.Username != nil {
+				newPassenger.Username = *user.Username
+			} else {
+				newPassenger.Username = user.Name
+			}
+			if user.StationName != nil {
+				newPassenger.StationName = *user.StationName
+			} else {
+				newPassenger.StationName = ""
+			}
 		
-		trainData.Passengers = append(trainData.Passengers, newPassenger)
+			trainData.Passengers = append(trainData.Passengers, newPassenger)
 		
-		// Recalculate average position and passenger count
-		h.recalculateAveragePosition(&trainData)
-		trainData.LastUpdate = time.Now().Format(time.RFC3339)
+			// Recalculate average position and passenger count
+			h.recalculateAveragePosition(&trainData)
+			applyTrainMetadata(&trainData, train, h.routes)
+			trainData.LastUpdate = time.Now().Format(time.RFC3339)
+		}
+		return &trainData, nil
 	}
 
-	// Store GPS position in Redis for real-time tracking (with S3 fallback)
-	if h.redis != nil {
-		// Store in Redis for real-time performance - create minimal GPS request for initial position
-		initialGPS := struct {
-			SessionID string   `json:"session_id" binding:"required"`
-			Latitude  float64  `json:"latitude" binding:"required,min=-90,max=90"`
-			Longitude float64  `json:"longitude" binding:"required,min=-180,max=180"`
-			Accuracy  *float64 `json:"accuracy,omitempty"`
-			Speed     *float64 `json:"speed,omitempty"`
-			Heading   *float64 `json:"heading,omitempty"`
-			Altitude  *float64 `json:"altitude,omitempty"`
-			// User status fields (optional)
-			StatusEmoji    *string `json:"status_emoji,omitempty"`
-			StatusMessage  *string `json:"status_message,omitempty"`
-		}{
+	// Store GPS position in the live store for real-time tracking (with S3 fallback)
+	if h.store.TracksSessions() {
+		// Store in the live store for real-time performance - create minimal GPS request for initial position
+		initialGPS := LocationUpdate{
 			SessionID: sessionID,
 			Latitude:  req.InitialLat,
 			Longitude: req.InitialLng,
 			// Initial position has no speed or status data
 		}
-		if err := h.storeGPSInRedis(sessionID, user.ID, req.TrainNumber, initialGPS, user); err != nil {
-			fmt.Printf("WARNING: Failed to store GPS in Redis, falling back to S3: %v\n", err)
-			// Fallback to S3 if Redis fails
-			if err := h.s3.UploadJSON(fileName, trainData); err != nil {
+		if err := h.storeLiveGPS(sessionID, user.ID, req.TrainNumber, initialGPS, user, trainMutex); err != nil {
+			fmt.Printf("WARNING: Failed to store GPS in %s live store, falling back to S3: %v\n", h.store.Name(), err)
+			// Fallback to S3 if the live store fails
+			if err := h.modifyTrainFile(req.TrainNumber, trainMutex, joinTrainFile); err != nil {
 				tx.Rollback()
 				fmt.Printf("ERROR: Failed to upload to S3: %v\n", err)
 				c.JSON(http.StatusInternalServerError, gin.H{
@@ -417,13 +534,13 @@ func (h *SimpleLiveTrackingHandler) StartMobileSession(c *gin.Context) {
 				})
 				return
 			}
-			fmt.Printf("DEBUG: Session %s stored in S3 file %s (Redis fallback)\n", sessionID, fileName)
+			fmt.Printf("DEBUG: Session %s stored in S3 file %s (live store fallback)\n", sessionID, fileName)
 		} else {
-			fmt.Printf("DEBUG: Session %s stored in Redis for real-time tracking\n", sessionID)
+			fmt.Printf("DEBUG: Session %s stored in %s live store for real-time tracking\n", sessionID, h.store.Name())
 		}
 	} else {
-		// No Redis available, use S3 (legacy mode)
-		if err := h.s3.UploadJSON(fileName, trainData); err != nil {
+		// Live store keeps no sessions, use S3 train files (legacy mode)
+		if err := h.modifyTrainFile(req.TrainNumber, trainMutex, joinTrainFile); err != nil {
 			tx.Rollback()
 			fmt.Printf("ERROR: Failed to upload to S3: %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -433,7 +550,7 @@ func (h *SimpleLiveTrackingHandler) StartMobileSession(c *gin.Context) {
 			})
 			return
 		}
-		fmt.Printf("DEBUG: Session %s stored in S3 file %s (Redis disabled)\n", sessionID, fileName)
+		fmt.Printf("DEBUG: Session %s stored in S3 file %s (legacy mode)\n", sessionID, fileName)
 	}
 
 	// Store session in database (only if S3 succeeded)
@@ -474,10 +591,35 @@ func (h *SimpleLiveTrackingHandler) StartMobileSession(c *gin.Context) {
 
 	// Note: No longer maintaining trains-list.json - using database-driven approach
 
+	if h.store.TracksSessions() {
+		// The aggregate written before the commit couldn't see this session yet - rebuild it now
+		if err := h.updateLiveTrainData(req.TrainNumber, trainMutex); err != nil {
+			fmt.Printf("WARNING: Failed to rebuild train data for %s after session start: %v\n", req.TrainNumber, err)
+		}
+		publishLiveEvent(h.store, LiveEvent{
+			Type:        liveEventSessionStarted,
+			TrainNumber: req.TrainNumber,
+			SessionID:   sessionID,
+			UserID:      user.ID,
+		})
+	}
+	h.archive.RecordPoints(req.TrainNumber, sessionID, user.ID, GPSPoint{
+		Lat:       req.InitialLat,
+		Lng:       req.InitialLng,
+		Timestamp: now.UnixMilli(),
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":    true,
 		"session_id": sessionID,
 		"message":    "Mobile tracking session started successfully",
+		"train": gin.H{
+			"train_id":     train.TrainID,
+			"train_number": train.TrainNumber,
+			"train_name":   train.TrainName,
+			"relation":     train.Relation,
+			"train_type":   train.TrainType,
+		},
 	})
 }
 
@@ -492,18 +634,7 @@ func (h *SimpleLiveTrackingHandler) UpdateMobileLocation(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		SessionID string   `json:"session_id" binding:"required"`
-		Latitude  float64  `json:"latitude" binding:"required,min=-90,max=90"`
-		Longitude float64  `json:"longitude" binding:"required,min=-180,max=180"`
-		Accuracy  *float64 `json:"accuracy,omitempty"`
-		Speed     *float64 `json:"speed,omitempty"`
-		Heading   *float64 `json:"heading,omitempty"`
-		Altitude  *float64 `json:"altitude,omitempty"`
-		// User status fields (optional)
-		StatusEmoji    *string `json:"status_emoji,omitempty"`
-		StatusMessage  *string `json:"status_message,omitempty"`
-	}
+	var req LocationUpdate
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -516,6 +647,32 @@ func (h *SimpleLiveTrackingHandler) UpdateMobileLocation(c *gin.Context) {
 	fmt.Printf("DEBUG: User %d updating location for session %s: (%.6f, %.6f)\n", 
 		user.ID, req.SessionID, req.Latitude, req.Longitude)
 
+	// First check if session exists (regardless of status)
+	var session models.LiveTrackingSession
+	result := h.db.Where("session_id = ? AND user_id = ?", req.SessionID, user.ID).First(&session)
+	
+	if result.Error != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Invalid session",
+		})
+		return
+	}
+
+	// Get train-specific mutex to prevent race conditions with other users on same train.
+	// Taken before the transaction so no connection is held while waiting for the lock.
+	trainMutex := h.getTrainMutex(session.TrainNumber)
+	if err := trainMutex.Lock(); err != nil {
+		fmt.Printf("ERROR: Failed to lock train %s: %v\n", session.TrainNumber, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Train is busy, please retry",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer trainMutex.Unlock()
+
 	// Start database transaction for consistency
 	tx := h.db.Begin()
 	defer func() {
@@ -524,11 +681,8 @@ func (h *SimpleLiveTrackingHandler) UpdateMobileLocation(c *gin.Context) {
 		}
 	}()
 
-	// First check if session exists (regardless of status)
-	var session models.LiveTrackingSession
-	result := tx.Where("session_id = ? AND user_id = ?", req.SessionID, user.ID).First(&session)
-	
-	if result.Error != nil {
+	// Re-read under the lock - the session may have been stopped while waiting
+	if err := tx.Where("id = ?", session.ID).First(&session).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusForbidden, gin.H{
 			"success": false,
@@ -553,25 +707,51 @@ func (h *SimpleLiveTrackingHandler) UpdateMobileLocation(c *gin.Context) {
 		return
 	}
 
-	// Get train-specific mutex to prevent race conditions with other users on same train
-	trainMutex := h.getTrainMutex(session.TrainNumber)
-	trainMutex.Lock()
-	defer trainMutex.Unlock()
+	// Check the point against the previous position and the train's maximum speed
+	point := GPSPoint{
+		Lat:       req.Latitude,
+		Lng:       req.Longitude,
+		Timestamp: time.Now().UnixMilli(),
+		Speed:     req.Speed,
+		Altitude:  req.Altitude,
+		Accuracy:  req.Accuracy,
+		Heading:   req.Heading,
+	}
+	plausibility := h.filterGPSPoint(session, point, h.getLivePosition(session))
+	if !plausibility.Accepted {
+		fmt.Printf("DEBUG: Rejected implausible location for session %s: %s\n", session.SessionID, plausibility.RejectionReason)
+		
+		// The app is still alive - keep the session from expiring
+		tx.Model(&session).Update("last_heartbeat", time.Now())
+		tx.Commit()
+		
+		c.JSON(http.StatusOK, gin.H{
+			"success":          false,
+			"message":          "Location rejected as implausible",
+			"rejection_reason": plausibility.RejectionReason,
+			"gps_quality":      plausibility,
+			"session_status":   "active",
+		})
+		return
+	}
+	req.QualityFlag = plausibility.QualityFlag
 
-	// Update location in Redis for real-time tracking (with S3 fallback)
+	// Update location in the live store for real-time tracking (with S3 fallback)
 	var updateError error
-	if h.redis != nil {
-		// Update GPS position in Redis (primary, fast) - include all GPS metadata
-		if err := h.storeGPSInRedis(session.SessionID, user.ID, session.TrainNumber, req, user); err != nil {
-			fmt.Printf("WARNING: Failed to update GPS in Redis, falling back to S3: %v\n", err)
-			// Fallback to S3 if Redis fails
-			_, updateError = h.updateLocationInTrainFile(session.FilePath, user.ID, req)
+	storage := h.store.Name()
+	if h.store.TracksSessions() {
+		// Update GPS position in the live store (primary, fast) - include all GPS metadata
+		if err := h.storeLiveGPS(session.SessionID, user.ID, session.TrainNumber, req, user, trainMutex); err != nil {
+			fmt.Printf("WARNING: Failed to update GPS in %s live store, falling back to S3: %v\n", h.store.Name(), err)
+			// Fallback to S3 if the live store fails
+			storage = "s3"
+			_, updateError = h.updateLocationInTrainFile(session.FilePath, user.ID, req, trainMutex)
 		} else {
-			fmt.Printf("DEBUG: GPS position updated in Redis for session %s\n", session.SessionID)
+			fmt.Printf("DEBUG: GPS position updated in %s live store for session %s\n", h.store.Name(), session.SessionID)
 		}
 	} else {
-		// No Redis available, use S3 (legacy mode)
-		_, updateError = h.updateLocationInTrainFile(session.FilePath, user.ID, req)
+		// Live store keeps no sessions, use S3 train files (legacy mode)
+		_, updateError = h.updateLocationInTrainFile(session.FilePath, user.ID, req, trainMutex)
 	}
 
 	if updateError != nil {
@@ -608,22 +788,259 @@ func (h *SimpleLiveTrackingHandler) UpdateMobileLocation(c *gin.Context) {
 		return
 	}
 
-	if h.redis != nil {
-		fmt.Printf("DEBUG: Successfully updated GPS position in Redis for user %d\n", user.ID)
-	} else {
-		fmt.Printf("DEBUG: GPS position updated via S3 for user %d (Redis disabled)\n", user.ID)
-	}
+	fmt.Printf("DEBUG: Successfully updated GPS position for user %d (storage: %s)\n", user.ID, storage)
+	h.archive.RecordPoints(session.TrainNumber, session.SessionID, user.ID, point)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Mobile location updated successfully",
-		"storage": func() string {
-			if h.redis != nil { return "redis" } else { return "s3" }
-		}(),
+		"storage": storage,
+		"gps_quality": plausibility,
 		"session_status": "active", // NEW: Consistent session status for mobile apps
 	})
 }
 
+// UpdateMobileLocationBatch - Upload GPS points buffered while offline (tunnels, rural stretches)
+func (h *SimpleLiveTrackingHandler) UpdateMobileLocationBatch(c *gin.Context) {
+	user, exists := middleware.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Authentication required",
+		})
+		return
+	}
+
+	var req struct {
+		SessionID string     `json:"session_id" binding:"required"`
+		Points    []GPSPoint `json:"points" binding:"required,min=1,max=1000"`
+		// User status fields (optional, applied with the newest point)
+		StatusEmoji    *string `json:"status_emoji,omitempty"`
+		StatusMessage  *string `json:"status_message,omitempty"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"errors":  err.Error(),
+		})
+		return
+	}
+
+	fmt.Printf("DEBUG: User %d uploading batch of %d points for session %s\n", user.ID, len(req.Points), req.SessionID)
+
+	var session models.LiveTrackingSession
+	result := h.db.Where("session_id = ? AND user_id = ?", req.SessionID, user.ID).First(&session)
+
+	if result.Error != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Invalid session",
+		})
+		return
+	}
+
+	if session.Status != "active" {
+		fmt.Printf("DEBUG: User %d tried to upload batch to terminated/inactive session %s (status: %s)\n",
+			user.ID, req.SessionID, session.Status)
+		c.JSON(http.StatusOK, gin.H{
+			"success":        false,
+			"message":        "Session is no longer active",
+			"accepted":       0,
+			"rejected":       len(req.Points),
+			"session_status": session.Status,
+		})
+		return
+	}
+
+	// Validate, dedupe and reorder the points by client timestamp
+	accepted, rejectedReasons := validateGPSBatch(req.Points, session.StartedAt, time.Now())
+	rejected := len(req.Points) - len(accepted)
+
+	if len(accepted) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success":          false,
+			"message":          "No valid points in batch",
+			"accepted":         0,
+			"rejected":         rejected,
+			"rejected_reasons": rejectedReasons,
+			"session_status":   session.Status,
+		})
+		return
+	}
+
+	// Get train-specific mutex to prevent race conditions with other users on same train
+	trainMutex := h.getTrainMutex(session.TrainNumber)
+	if err := trainMutex.Lock(); err != nil {
+		fmt.Printf("ERROR: Failed to lock train %s: %v\n", session.TrainNumber, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Train is busy, please retry",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer trainMutex.Unlock()
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Re-read under the lock - the session may have been stopped while waiting
+	if err := tx.Where("id = ?", session.ID).First(&session).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Invalid session",
+		})
+		return
+	}
+
+	if session.Status != "active" {
+		tx.Rollback()
+		fmt.Printf("DEBUG: Session %s was stopped while user %d was waiting to upload a batch (status: %s)\n",
+			req.SessionID, user.ID, session.Status)
+		c.JSON(http.StatusOK, gin.H{
+			"success":        false,
+			"message":        "Session is no longer active",
+			"accepted":       0,
+			"rejected":       len(req.Points),
+			"session_status": session.Status,
+		})
+		return
+	}
+
+	// Check each point against the previous plausible one (starting from the live position)
+	previous := h.getLivePosition(session)
+	if previous != nil && previous.Timestamp >= accepted[0].Timestamp {
+		previous = nil // Live position is newer than the batch - nothing earlier to compare with
+	}
+	plausible := make([]GPSPoint, 0, len(accepted))
+	var newestQuality GPSPlausibility
+	for _, point := range accepted {
+		quality := h.filterGPSPoint(session, point, previous)
+		if !quality.Accepted {
+			rejectedReasons[quality.RejectionReason]++
+			continue
+		}
+		plausible = append(plausible, point)
+		newestQuality = quality
+		if quality.QualityFlag == "" {
+			previous = &plausible[len(plausible)-1]
+		}
+	}
+	rejected = len(req.Points) - len(plausible)
+	accepted = plausible
+
+	if len(accepted) == 0 {
+		// The app is still alive - keep the session from expiring
+		tx.Model(&session).Update("last_heartbeat", time.Now())
+		tx.Commit()
+		c.JSON(http.StatusOK, gin.H{
+			"success":          false,
+			"message":          "No plausible points in batch",
+			"accepted":         0,
+			"rejected":         rejected,
+			"rejected_reasons": rejectedReasons,
+			"session_status":   session.Status,
+		})
+		return
+	}
+
+	newest := accepted[len(accepted)-1]
+	newestUpdate := LocationUpdate{
+		SessionID:     session.SessionID,
+		Latitude:      newest.Lat,
+		Longitude:     newest.Lng,
+		Accuracy:      newest.Accuracy,
+		Speed:         newest.Speed,
+		Heading:       newest.Heading,
+		Altitude:      newest.Altitude,
+		StatusEmoji:   req.StatusEmoji,
+		StatusMessage: req.StatusMessage,
+		QualityFlag:   newestQuality.QualityFlag,
+	}
+
+	// Only move the live position if the batch is newer than what we already have
+	livePositionUpdated := false
+	var updateError error
+	storage := h.store.Name()
+	if h.store.TracksSessions() {
+		if err := h.store.AppendPath(session.SessionID, accepted[:len(accepted)-1]...); err != nil {
+			fmt.Printf("WARNING: Failed to append batch to GPS path history for session %s: %v\n", session.SessionID, err)
+		}
+
+		if livePosition, err := h.getLiveSessionPosition(session.SessionID); err == nil && livePosition.Timestamp >= newest.Timestamp {
+			// A newer live update already arrived - keep it, just record the newest point in history
+			if err := h.store.AppendPath(session.SessionID, newest); err != nil {
+				fmt.Printf("WARNING: Failed to append batch to GPS path history for session %s: %v\n", session.SessionID, err)
+			}
+		} else if err := h.storeLiveGPSAt(session.SessionID, user.ID, session.TrainNumber, newestUpdate, newest.Timestamp, trainMutex); err != nil {
+			fmt.Printf("WARNING: Failed to update GPS in %s live store, falling back to S3: %v\n", h.store.Name(), err)
+			storage = "s3"
+			_, updateError = h.updateLocationInTrainFileAt(session.FilePath, user.ID, newestUpdate, newest.Timestamp, trainMutex)
+			livePositionUpdated = updateError == nil
+		} else {
+			livePositionUpdated = true
+		}
+	} else {
+		// Live store keeps no sessions, use S3 train files (legacy mode) - only the newest point is kept
+		_, updateError = h.updateLocationInTrainFileAt(session.FilePath, user.ID, newestUpdate, newest.Timestamp, trainMutex)
+		livePositionUpdated = updateError == nil
+	}
+
+	if updateError != nil {
+		tx.Rollback()
+		fmt.Printf("ERROR: Failed to update location from batch: %v\n", updateError)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update location",
+			"error":   updateError.Error(),
+		})
+		return
+	}
+
+	if err := tx.Model(&session).Update("last_heartbeat", time.Now()).Error; err != nil {
+		tx.Rollback()
+		fmt.Printf("ERROR: Failed to update heartbeat: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update session heartbeat",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("ERROR: Failed to commit transaction: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to commit location update",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	h.archive.RecordPoints(session.TrainNumber, session.SessionID, user.ID, accepted...)
+
+	fmt.Printf("DEBUG: Batch for session %s: %d accepted, %d rejected (live position updated: %t)\n",
+		session.SessionID, len(accepted), rejected, livePositionUpdated)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":               true,
+		"message":               "Mobile location batch processed successfully",
+		"accepted":              len(accepted),
+		"rejected":              rejected,
+		"rejected_reasons":      rejectedReasons,
+		"live_position_updated": livePositionUpdated,
+		"storage":               storage,
+		"session_status":        "active",
+	})
+}
+
 // Heartbeat - Simple version
 func (h *SimpleLiveTrackingHandler) Heartbeat(c *gin.Context) {
 	user, exists := middleware.GetUserFromContext(c)
@@ -675,7 +1092,7 @@ func (h *SimpleLiveTrackingHandler) Heartbeat(c *gin.Context) {
 	})
 }
 
-// RecoverSession - Simple version
+// RecoverSession - Resume an active session after app crash, OS kill or connection loss
 func (h *SimpleLiveTrackingHandler) RecoverSession(c *gin.Context) {
 	user, exists := middleware.GetUserFromContext(c)
 	if !exists {
@@ -687,25 +1104,237 @@ func (h *SimpleLiveTrackingHandler) RecoverSession(c *gin.Context) {
 	}
 
 	var req struct {
-		SessionID string  `json:"session_id" binding:"required"`
-		Reason    *string `json:"reason,omitempty"`
+		SessionID string   `json:"session_id" binding:"required"`
+		Reason    *string  `json:"reason,omitempty"`
+		// Last fix known to the device (optional, used when the server has no position)
+		Latitude  *float64 `json:"latitude,omitempty" binding:"omitempty,min=-90,max=90"`
+		Longitude *float64 `json:"longitude,omitempty" binding:"omitempty,min=-180,max=180"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"errors":  err.Error(),
+		})
+		return
+	}
+
+	reason := "unspecified"
+	if req.Reason != nil && *req.Reason != "" {
+		reason = *req.Reason
+	}
+	fmt.Printf("DEBUG: User %d attempting to recover session %s (reason: %s)\n", user.ID, req.SessionID, reason)
+
+	// Look up the session regardless of status so we can tell the app why recovery failed
+	var session models.LiveTrackingSession
+	result := h.db.Where("session_id = ? AND user_id = ?", req.SessionID, user.ID).First(&session)
+
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":        false,
+			"message":        "Session not found",
+			"session_status": "not_found",
+		})
+		return
+	}
+
+	// Terminated, completed or expired sessions cannot be resumed - the app must start a new one
+	if session.Status != "active" {
+		fmt.Printf("DEBUG: User %d cannot recover session %s (status: %s)\n", user.ID, req.SessionID, session.Status)
+		c.JSON(http.StatusConflict, gin.H{
+			"success":        false,
+			"message":        "Session is no longer active and cannot be recovered",
+			"session_id":     session.SessionID,
+			"session_status": session.Status,
+		})
+		return
+	}
+
+	// Get train-specific mutex to prevent race conditions with other users on same train
+	trainMutex := h.getTrainMutex(session.TrainNumber)
+	if err := trainMutex.Lock(); err != nil {
+		fmt.Printf("ERROR: Failed to lock train %s: %v\n", session.TrainNumber, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Train is busy, please retry",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer trainMutex.Unlock()
+
+	// Find the last known position (live store, then S3 train file, then device-supplied fix)
+	lastPosition := h.getLastKnownPosition(session)
+	if lastPosition == nil && req.Latitude != nil && req.Longitude != nil {
+		lastPosition = &GPSPoint{
+			Lat:       *req.Latitude,
+			Lng:       *req.Longitude,
+			Timestamp: time.Now().UnixMilli(),
+		}
+		fmt.Printf("DEBUG: Using device-supplied position to recover session %s\n", session.SessionID)
+	}
+
+	// Re-seed live data so the passenger shows up on the map again
+	storage := "none"
+	if lastPosition != nil {
+		var err error
+		storage, err = h.reseedLiveSession(session, *lastPosition, trainMutex)
+		if err != nil {
+			fmt.Printf("ERROR: Failed to re-seed live data for session %s: %v\n", session.SessionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to restore live tracking data",
+				"error":   err.Error(),
+			})
+			return
+		}
+	} else {
+		fmt.Printf("DEBUG: No known position for session %s, live data will resume on next update\n", session.SessionID)
+	}
+
+	// Recovery counts as a heartbeat
+	h.db.Model(&session).Update("last_heartbeat", time.Now())
+	if storage != "none" {
+		publishLiveEvent(h.store, LiveEvent{
+			Type:        liveEventSessionRecovered,
+			TrainNumber: session.TrainNumber,
+			SessionID:   session.SessionID,
+			UserID:      session.UserID,
+		})
+	}
+
+	fmt.Printf("DEBUG: User %d recovered session %s on train %s (storage: %s)\n", user.ID, session.SessionID, session.TrainNumber, storage)
+
+	response := gin.H{
+		"success":        true,
+		"message":        "Session recovered successfully",
+		"session_id":     session.SessionID,
+		"train_number":   session.TrainNumber,
+		"train_id":       session.TrainID,
+		"started_at":     session.StartedAt.Format(time.RFC3339),
+		"session_status": session.Status,
+		"storage":        storage,
+		"last_position":  nil,
+	}
+	if lastPosition != nil {
+		response["last_position"] = lastPosition
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getLastKnownPosition finds the most recent position for a session (live store first, S3 fallback)
+func (h *SimpleLiveTrackingHandler) getLastKnownPosition(session models.LiveTrackingSession) *GPSPoint {
+	if h.store.TracksSessions() {
+		if gpsPath, err := h.getLiveGPSPath(session.SessionID); err == nil && len(gpsPath) > 0 {
+			return &gpsPath[len(gpsPath)-1]
+		}
+	}
+
+	trainData, err := h.trainFiles.GetTrain(session.TrainNumber)
+	if err != nil {
+		return nil
+	}
+
+	for _, passenger := range trainData.Passengers {
+		// Match the session only - an older session of the same user on this train is not this trip
+		if passenger.SessionID == session.SessionID {
+			return &GPSPoint{
+				Lat:       passenger.Lat,
+				Lng:       passenger.Lng,
+				Timestamp: passenger.Timestamp,
+				Speed:     passenger.Speed,
+				Altitude:  passenger.Altitude,
+				Accuracy:  passenger.Accuracy,
+				Heading:   passenger.Heading,
+			}
+		}
+	}
+
+	return nil
+}
+
+// reseedLiveSession restores a recovered session's live position and the train aggregate.
+// Caller must hold trainMutex. Returns the storage that was used.
+func (h *SimpleLiveTrackingHandler) reseedLiveSession(session models.LiveTrackingSession, position GPSPoint, trainMutex TrainLock) (string, error) {
+	if h.store.TracksSessions() {
+		// Session still alive - just extend it and rebuild the train aggregate
+		if alive, err := h.store.TouchSession(session.SessionID); err == nil && alive {
+			if err := h.updateLiveTrainData(session.TrainNumber, trainMutex); err == nil {
+				return h.store.Name(), nil
+			}
+		} else {
+			recoveredGPS := LocationUpdate{
+				SessionID: session.SessionID,
+				Latitude:  position.Lat,
+				Longitude: position.Lng,
+				Accuracy:  position.Accuracy,
+				Speed:     position.Speed,
+				Heading:   position.Heading,
+				Altitude:  position.Altitude,
+			}
+			if err := h.storeLiveGPSAt(session.SessionID, session.UserID, session.TrainNumber, recoveredGPS, position.Timestamp, trainMutex); err != nil {
+				fmt.Printf("WARNING: Failed to re-seed session in %s live store, falling back to S3: %v\n", h.store.Name(), err)
+			} else {
+				return h.store.Name(), nil
+			}
+		}
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"errors":  err.Error(),
-		})
-		return
-	}
+	// S3 (legacy mode or live store failure) - make sure the passenger is present in the train file
+	err := h.modifyTrainFile(session.TrainNumber, trainMutex, func(trainData *models.TrainData) (*models.TrainData, error) {
+		if trainData == nil {
+			trainData = &models.TrainData{
+				TrainID:    session.TrainNumber,
+				Passengers: []models.Passenger{},
+				Status:     "active",
+				DataSource: "live-gps",
+			}
+			applyTrainMetadata(trainData, h.getTrain(session.TrainID), h.routes)
+		}
 
-	fmt.Printf("DEBUG: User %d recovered session %s\n", user.ID, req.SessionID)
+		passengerFound := false
+		for i := range trainData.Passengers {
+			if trainData.Passengers[i].UserID == session.UserID {
+				trainData.Passengers[i].SessionID = session.SessionID
+				trainData.Passengers[i].SessionStatus = "active"
+				passengerFound = true
+				break
+			}
+		}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Session recovered successfully (Redis-free)",
-		"train_number": "TEST-TRAIN",
+		if !passengerFound {
+			passenger := models.Passenger{
+				UserID:        session.UserID,
+				UserType:      session.UserType,
+				ClientType:    session.ClientType,
+				Lat:           position.Lat,
+				Lng:           position.Lng,
+				Timestamp:     position.Timestamp,
+				SessionID:     session.SessionID,
+				SessionStatus: "active",
+				Accuracy:      position.Accuracy,
+				Speed:         position.Speed,
+				Heading:       position.Heading,
+				Altitude:      position.Altitude,
+			}
+			if userCache := h.getUserWithStation(session.UserID); userCache != nil {
+				passenger.Name = userCache.Name
+				passenger.Username = userCache.Username
+				passenger.StationName = userCache.StationName
+			}
+			trainData.Passengers = append(trainData.Passengers, passenger)
+		}
+
+		h.recalculateAveragePosition(trainData)
+		trainData.LastUpdate = time.Now().Format(time.RFC3339)
+		return trainData, nil
 	})
+	if err != nil {
+		return "", fmt.Errorf("failed to update train file: %v", err)
+	}
+
+	return "s3", nil
 }
 
 // StopMobileSession - Simple version
@@ -759,6 +1388,19 @@ func (h *SimpleLiveTrackingHandler) StopMobileSession(c *gin.Context) {
 
 	fmt.Printf("DEBUG: User %d stopping session %s\n", user.ID, req.SessionID)
 
+	// Lock before anything is written, so a retry after a busy lock doesn't save the trip twice
+	trainMutex := h.getTrainMutex(session.TrainNumber)
+	if err := trainMutex.Lock(); err != nil {
+		fmt.Printf("ERROR: Failed to lock train %s: %v\n", session.TrainNumber, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Train is busy, please retry",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer trainMutex.Unlock()
+
 	// Get train file data before removing user
 	fileName := session.FilePath
 	var tripSaved bool = false
@@ -777,26 +1419,12 @@ func (h *SimpleLiveTrackingHandler) StopMobileSession(c *gin.Context) {
 			ToStationName:   req.ToStationName,
 		}
 		
-		// Enhanced GPS path handling: Use mobile data if provided, otherwise try Redis, then S3 fallback
-		var finalGPSPath []GPSPoint
+		// GPS path handling: mobile data if provided, otherwise saveUserTrip uses the server path history, then S3 fallback
 		if len(req.GPSPath) > 0 {
-			// Use mobile-provided GPS path (preferred)
-			finalGPSPath = req.GPSPath
 			fmt.Printf("DEBUG: Using mobile GPS path with %d points for trip saving\n", len(req.GPSPath))
-		} else if h.redis != nil {
-			// Try to get GPS data from Redis (real-time)
-			redisGPSPath, err := h.getGPSPathFromRedis(req.SessionID, user.ID, session.TrainNumber)
-			if err == nil && len(redisGPSPath) > 0 {
-				finalGPSPath = redisGPSPath
-				fmt.Printf("DEBUG: Using Redis GPS data for trip saving (real-time position)\n")
-			} else {
-				fmt.Printf("DEBUG: Redis GPS data not available, will use S3 fallback in saveUserTrip\n")
-			}
-		} else {
-			fmt.Printf("DEBUG: Redis not available, will use S3 fallback in saveUserTrip\n")
 		}
 		
-		tripID, saveFailureReason = h.saveUserTrip(session, user.ID, req.TripSummary, finalGPSPath, &stationInfo)
+		tripID, saveFailureReason = h.saveUserTrip(session, user.ID, req.TripSummary, req.GPSPath, &stationInfo)
 		tripSaved = (tripID != nil)
 		if tripSaved {
 			fmt.Printf("DEBUG: Saved trip with ID %d for user %d\n", *tripID, user.ID)
@@ -814,21 +1442,29 @@ func (h *SimpleLiveTrackingHandler) StopMobileSession(c *gin.Context) {
 		}
 	}
 	
-	// Handle session cleanup - Redis first, S3 fallback
-	if h.redis != nil {
-		// Clean up Redis data (real-time approach)
-		if err := h.cleanupRedisSession(req.SessionID, user.ID, session.TrainNumber); err != nil {
-			fmt.Printf("ERROR: Redis cleanup failed: %v\n", err)
-			// Fallback to S3 operations if Redis fails
-			if err := h.handleStopSessionS3Operations(fileName, user.ID, false); err != nil {
+	h.forgetGPSRejectStreak(req.SessionID)
+	
+	// Handle session cleanup - live store first, S3 fallback
+	if h.store.TracksSessions() {
+		// Clean up live store data (real-time approach)
+		if err := h.cleanupLiveSession(req.SessionID, session.TrainNumber, trainMutex); err != nil {
+			fmt.Printf("ERROR: Live store cleanup failed: %v\n", err)
+			// Fallback to S3 operations if the live store fails
+			if err := h.handleStopSessionS3Operations(fileName, user.ID, false, trainMutex); err != nil {
 				fmt.Printf("ERROR: S3 fallback operations also failed: %v\n", err)
 			}
 		} else {
-			fmt.Printf("DEBUG: Successfully cleaned up Redis session data for user %d\n", user.ID)
+			fmt.Printf("DEBUG: Successfully cleaned up live session data for user %d\n", user.ID)
 		}
+		publishLiveEvent(h.store, LiveEvent{
+			Type:        liveEventSessionStopped,
+			TrainNumber: session.TrainNumber,
+			SessionID:   req.SessionID,
+			UserID:      user.ID,
+		})
 	} else {
-		// No Redis available, use S3 operations (legacy mode)
-		err := h.handleStopSessionS3Operations(fileName, user.ID, false)
+		// Live store keeps no sessions, use S3 operations (legacy mode)
+		err := h.handleStopSessionS3Operations(fileName, user.ID, false, trainMutex)
 		if err != nil {
 			fmt.Printf("ERROR: S3 operations failed: %v\n", err)
 		}
@@ -879,11 +1515,16 @@ func (h *SimpleLiveTrackingHandler) terminateUserSessions(userID uint) {
 	h.db.Where("user_id = ? AND status = ?", userID, "active").Find(&sessions)
 	
 	for _, session := range sessions {
-		// Remove user from train file or delete entire file
-		h.handleStopSessionS3Operations(session.FilePath, userID, false)
+		// Mark session as terminated, unless it ended concurrently
+		result := h.db.Model(&models.LiveTrackingSession{}).
+			Where("id = ? AND status = ?", session.ID, "active").
+			Update("status", "terminated")
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
 		
-		// Mark session as terminated
-		h.db.Model(&session).Update("status", "terminated")
+		// Remove it from live data like a stopped session, so clients see it leave the train
+		h.removeEndedSessionLiveData(session, liveEventSessionStopped)
 	}
 }
 
@@ -905,7 +1546,7 @@ func (h *SimpleLiveTrackingHandler) updateTrainsList() {
 	// Build trains list from active sessions
 	for _, session := range sessions {
 		// Try to read train file to get current data
-		trainData, err := h.s3.GetTrainData(session.FilePath)
+		trainData, err := h.trainFiles.GetTrain(session.TrainNumber)
 		if err != nil {
 			continue // File doesn't exist anymore
 		}
@@ -945,102 +1586,93 @@ func (h *SimpleLiveTrackingHandler) updateTrainsList() {
 }
 
 // Handle S3 operations when stopping session
-func (h *SimpleLiveTrackingHandler) handleStopSessionS3Operations(fileName string, userID uint, saveTrip bool) error {
-	// Get current train data
-	trainData, err := h.s3.GetTrainData(fileName)
-	if err != nil {
-		return fmt.Errorf("failed to read train file %s: %v", fileName, err)
-	}
+func (h *SimpleLiveTrackingHandler) handleStopSessionS3Operations(fileName string, userID uint, saveTrip bool, trainMutex TrainLock) error {
+	trainNumber, _ := s3TrainNumber(fileName)
 
-	// Remove this user from passengers
-	var remainingPassengers []models.Passenger
-	for _, passenger := range trainData.Passengers {
-		if passenger.UserID != userID {
-			remainingPassengers = append(remainingPassengers, passenger)
+	return h.modifyTrainFile(trainNumber, trainMutex, func(trainData *models.TrainData) (*models.TrainData, error) {
+		if trainData == nil {
+			return nil, fmt.Errorf("failed to read train file %s: %w", fileName, utils.ErrNotFound)
+		}
+
+		// Remove this user from passengers
+		var remainingPassengers []models.Passenger
+		for _, passenger := range trainData.Passengers {
+			if passenger.UserID != userID {
+				remainingPassengers = append(remainingPassengers, passenger)
+			}
+		}
+
+		if len(remainingPassengers) == 0 {
+			// No passengers left - delete the file
+			fmt.Printf("DEBUG: Deleting empty train file %s\n", fileName)
+			return nil, nil
 		}
-	}
 
-	if len(remainingPassengers) > 0 {
 		// Other passengers remain - update file
 		trainData.Passengers = remainingPassengers
 		h.recalculateAveragePosition(trainData)
 		trainData.LastUpdate = time.Now().Format(time.RFC3339)
-		
-		if err := h.s3.UploadJSON(fileName, *trainData); err != nil {
-			return fmt.Errorf("failed to update train file: %v", err)
-		}
-		fmt.Printf("DEBUG: Updated train file %s, removed user %d\n", fileName, userID)
-	} else {
-		// No passengers left - delete the file
-		if err := h.s3.DeleteFile(fileName); err != nil {
-			return fmt.Errorf("failed to delete train file: %v", err)
-		}
-		fmt.Printf("DEBUG: Deleted empty train file %s\n", fileName)
-	}
-
-	return nil
+		fmt.Printf("DEBUG: Updating train file %s, removed user %d\n", fileName, userID)
+		return trainData, nil
+	})
 }
 
 // Update location in specific train file  
-func (h *SimpleLiveTrackingHandler) updateLocationInTrainFile(fileName string, userID uint, req struct {
-	SessionID string   `json:"session_id" binding:"required"`
-	Latitude  float64  `json:"latitude" binding:"required,min=-90,max=90"`
-	Longitude float64  `json:"longitude" binding:"required,min=-180,max=180"`
-	Accuracy  *float64 `json:"accuracy,omitempty"`
-	Speed     *float64 `json:"speed,omitempty"`
-	Heading   *float64 `json:"heading,omitempty"`
-	Altitude  *float64 `json:"altitude,omitempty"`
-	// User status fields (optional)
-	StatusEmoji    *string `json:"status_emoji,omitempty"`
-	StatusMessage  *string `json:"status_message,omitempty"`
-}) (string, error) {
-	// Get train data from S3
-	trainData, err := h.s3.GetTrainData(fileName)
-	if err != nil {
-		return "", fmt.Errorf("failed to read train file: %v", err)
-	}
-	
-	// Find and update this user's passenger data
-	userFound := false
-	for i := range trainData.Passengers {
-		if trainData.Passengers[i].UserID == userID {
-			// Update passenger location data (preserving username and station name)
-			trainData.Passengers[i].Lat = req.Latitude
-			trainData.Passengers[i].Lng = req.Longitude
-			trainData.Passengers[i].Timestamp = time.Now().UnixMilli()
-			trainData.Passengers[i].Accuracy = req.Accuracy
-			trainData.Passengers[i].Speed = req.Speed
-			trainData.Passengers[i].Heading = req.Heading
-			trainData.Passengers[i].Altitude = req.Altitude
-			trainData.Passengers[i].SessionStatus = "active"
-			
-			// Update user status if provided
-			if req.StatusEmoji != nil && req.StatusMessage != nil {
-				trainData.Passengers[i].UserStatus = &models.UserStatus{
-					Emoji:     *req.StatusEmoji,
-					Message:   *req.StatusMessage,
-					Timestamp: time.Now().Format(time.RFC3339),
+func (h *SimpleLiveTrackingHandler) updateLocationInTrainFile(fileName string, userID uint, req LocationUpdate, trainMutex TrainLock) (string, error) {
+	return h.updateLocationInTrainFileAt(fileName, userID, req, time.Now().UnixMilli(), trainMutex)
+}
+
+// Update location in specific train file using the point's own timestamp (Unix milliseconds)
+func (h *SimpleLiveTrackingHandler) updateLocationInTrainFileAt(fileName string, userID uint, req LocationUpdate, timestamp int64, trainMutex TrainLock) (string, error) {
+	trainNumber, _ := s3TrainNumber(fileName)
+
+	err := h.modifyTrainFile(trainNumber, trainMutex, func(trainData *models.TrainData) (*models.TrainData, error) {
+		if trainData == nil {
+			return nil, fmt.Errorf("failed to read train file: %w", utils.ErrNotFound)
+		}
+
+		// Find and update this user's passenger data
+		userFound := false
+		for i := range trainData.Passengers {
+			if trainData.Passengers[i].UserID == userID {
+				// Update passenger location data (preserving username and station name)
+				trainData.Passengers[i].Lat = req.Latitude
+				trainData.Passengers[i].Lng = req.Longitude
+				trainData.Passengers[i].Timestamp = timestamp
+				trainData.Passengers[i].Accuracy = req.Accuracy
+				trainData.Passengers[i].Speed = req.Speed
+				trainData.Passengers[i].Heading = req.Heading
+				trainData.Passengers[i].Altitude = req.Altitude
+				trainData.Passengers[i].QualityFlag = req.QualityFlag
+				trainData.Passengers[i].SessionStatus = "active"
+
+				// Update user status if provided
+				if req.StatusEmoji != nil && req.StatusMessage != nil {
+					trainData.Passengers[i].UserStatus = &models.UserStatus{
+						Emoji:     *req.StatusEmoji,
+						Message:   *req.StatusMessage,
+						Timestamp: time.Now().Format(time.RFC3339),
+					}
 				}
+				// Note: Username and StationName are preserved, not overwritten
+				userFound = true
+				break
 			}
-			// Note: Username and StationName are preserved, not overwritten
-			userFound = true
-			break
 		}
-	}
-	
-	if !userFound {
-		return "", fmt.Errorf("user %d not found in train file %s", userID, fileName)
-	}
-	
-	// Recalculate average position and update timestamp
-	h.recalculateAveragePosition(trainData)
-	trainData.LastUpdate = time.Now().Format(time.RFC3339)
-	
-	// Upload updated data back to S3
-	if err := h.s3.UploadJSON(fileName, *trainData); err != nil {
+
+		if !userFound {
+			return nil, fmt.Errorf("user %d not found in train file %s", userID, fileName)
+		}
+
+		// Recalculate average position and update timestamp
+		h.recalculateAveragePosition(trainData)
+		trainData.LastUpdate = time.Now().Format(time.RFC3339)
+		return trainData, nil
+	})
+	if err != nil {
 		return "", fmt.Errorf("failed to update train file: %v", err)
 	}
-	
+
 	return fileName, nil
 }
 
@@ -1051,22 +1683,17 @@ func (h *SimpleLiveTrackingHandler) recalculateAveragePosition(trainData *models
 		return
 	}
 	
-	var totalLat, totalLng float64
 	activeCount := 0
-	
 	for _, passenger := range trainData.Passengers {
 		if passenger.SessionStatus == "active" {
-			totalLat += passenger.Lat
-			totalLng += passenger.Lng
 			activeCount++
 		}
 	}
 	
-	if activeCount > 0 {
-		trainData.AveragePosition = models.Position{
-			Lat: totalLat / float64(activeCount),
-			Lng: totalLng / float64(activeCount),
-		}
+	if estimate := aggregateTrainPosition(trainData.Passengers); estimate != nil {
+		trainData.AveragePosition = estimate.Position
+		trainData.ConfidenceRadius = &estimate.ConfidenceRadiusM
+		trainData.RouteMatch = h.routes.match(trainData.TrainID, estimate.Position)
 		trainData.PassengerCount = activeCount
 	}
 }
@@ -1074,13 +1701,28 @@ func (h *SimpleLiveTrackingHandler) recalculateAveragePosition(trainData *models
 // Save user trip data to trips table using mobile GPS path and statistics
 func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSession, userID uint, mobileSummary *TripSummary, gpsPath []GPSPoint, stationInfo *StationInfo) (*uint, string) {
 	
-	// Use mobile GPS path if provided, otherwise fallback to S3 data
+	// Use mobile GPS path if provided, then the server-side path history, otherwise fallback to S3 data
 	var trackingDataInterface interface{}
 	var routeCoordsInterface interface{}
 	var startLat, startLng, endLat, endLng float64
+	var s3TrackingData []models.Passenger
+	
+	pathSource := "mobile"
+	if len(gpsPath) == 0 && h.store.TracksSessions() {
+		serverGPSPath, err := h.getLiveGPSPath(session.SessionID)
+		if err == nil && len(serverGPSPath) > 0 {
+			gpsPath = serverGPSPath
+			pathSource = "server"
+		} else {
+			fmt.Printf("DEBUG: Server GPS path history not available for session %s\n", session.SessionID)
+		}
+	}
+	
+	// Sort and dedupe once - the stored path, route, start/end points and statistics all use this
+	gpsPath = normalizeGPSPath(gpsPath)
 	
 	if len(gpsPath) > 0 {
-		fmt.Printf("DEBUG: Using mobile GPS path with %d points\n", len(gpsPath))
+		fmt.Printf("DEBUG: Using %s GPS path with %d points\n", pathSource, len(gpsPath))
 		
 		// Convert GPS path to JSON bytes for database storage
 		jsonBytes, err := json.Marshal(gpsPath)
@@ -1092,21 +1734,13 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		// Use json.RawMessage for direct JSON storage
 		trackingDataInterface = json.RawMessage(jsonBytes)
 		
-		// Extract route coordinates for map display and serialize to JSON
-		var routeCoords []map[string]interface{}
-		for _, point := range gpsPath {
-			routeCoords = append(routeCoords, map[string]interface{}{
-				"lat":       point.Lat,
-				"lng":       point.Lng,
-				"timestamp": point.Timestamp,
-			})
-		}
-		routeBytes, err := json.Marshal(routeCoords)
+		// Extract simplified route coordinates for map display and serialize to JSON
+		routeCoords, err := h.buildRouteCoordinates(gpsPath)
 		if err != nil {
 			fmt.Printf("ERROR: Failed to marshal route coordinates: %v\n", err)
 			return nil, fmt.Sprintf("Failed to serialize route data: %v", err)
 		}
-		routeCoordsInterface = json.RawMessage(routeBytes)
+		routeCoordsInterface = routeCoords
 		
 		// Get start/end points from GPS path
 		startLat = gpsPath[0].Lat
@@ -1118,7 +1752,7 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		fmt.Printf("DEBUG: Falling back to S3 data for GPS path\n")
 		
 		// Fallback: Get tracking data from S3 file (legacy approach)
-		trainData, err := h.s3.GetTrainData(session.FilePath)
+		trainData, err := h.trainFiles.GetTrain(session.TrainNumber)
 		if err != nil {
 			fmt.Printf("ERROR: Could not read train data for trip saving: %v\n", err)
 			return nil, "Failed to read S3 train data"
@@ -1136,6 +1770,7 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 			fmt.Printf("ERROR: No tracking data found for user %d\n", userID)
 			return nil, "No tracking data found for user"
 		}
+		s3TrackingData = userTrackingData
 
 		// Use S3 data for tracking - serialize to JSON
 		s3JsonBytes, err := json.Marshal(userTrackingData)
@@ -1145,21 +1780,17 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		}
 		trackingDataInterface = json.RawMessage(s3JsonBytes)
 		
-		// Extract route coordinates from S3 data and serialize to JSON
-		var routeCoords []map[string]interface{}
+		// Extract simplified route coordinates from S3 data and serialize to JSON
+		s3Path := make([]GPSPoint, 0, len(userTrackingData))
 		for _, point := range userTrackingData {
-			routeCoords = append(routeCoords, map[string]interface{}{
-				"lat":       point.Lat,
-				"lng":       point.Lng,
-				"timestamp": point.Timestamp,
-			})
+			s3Path = append(s3Path, GPSPoint{Lat: point.Lat, Lng: point.Lng, Timestamp: point.Timestamp})
 		}
-		s3RouteBytes, err := json.Marshal(routeCoords)
+		s3RouteCoords, err := h.buildRouteCoordinates(s3Path)
 		if err != nil {
 			fmt.Printf("ERROR: Failed to marshal S3 route coordinates: %v\n", err)
 			return nil, fmt.Sprintf("Failed to serialize S3 route data: %v", err)
 		}
-		routeCoordsInterface = json.RawMessage(s3RouteBytes)
+		routeCoordsInterface = s3RouteCoords
 		
 		// Get start/end points from S3 data
 		startLat = userTrackingData[0].Lat
@@ -1168,48 +1799,24 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		endLng = userTrackingData[len(userTrackingData)-1].Lng
 	}
 
-	// Use mobile-calculated stats if provided, otherwise fallback to server calculation
+	// Statistics are always calculated by the server from the GPS path; the app's summary is only
+	// stored for comparison (see verifyTripSummary)
 	var stats TripStatistics
 	var durationSeconds int
-	
-	if mobileSummary != nil {
-		fmt.Printf("DEBUG: Using mobile-calculated trip statistics\n")
-		// Use mobile statistics (preferred)
-		durationSeconds = mobileSummary.DurationSeconds
-		stats.TotalDistanceKm = mobileSummary.TotalDistanceKm
-		stats.MaxSpeedKmh = mobileSummary.MaxSpeedKmh
-		stats.AvgSpeedKmh = mobileSummary.AvgSpeedKmh
-		
-		if mobileSummary.MaxElevationM != nil {
-			stats.MaxElevationM = int(*mobileSummary.MaxElevationM)
-		}
-		if mobileSummary.MinElevationM != nil {
-			stats.MinElevationM = int(*mobileSummary.MinElevationM)
-		}
-		if mobileSummary.ElevationGainM != nil {
-			stats.ElevationGainM = int(*mobileSummary.ElevationGainM)
-		}
-		
-		if mobileSummary.MaxSpeedLocation != nil {
-			stats.MaxSpeedLat = &mobileSummary.MaxSpeedLocation.Lat
-			stats.MaxSpeedLng = &mobileSummary.MaxSpeedLocation.Lng
-		}
-		if mobileSummary.MaxElevationLocation != nil {
-			stats.MaxElevationLat = &mobileSummary.MaxElevationLocation.Lat
-			stats.MaxElevationLng = &mobileSummary.MaxElevationLocation.Lng
-		}
+	pathPoints := len(gpsPath)
+	
+	if len(gpsPath) > 1 {
+		fmt.Printf("DEBUG: Calculating trip statistics from %s GPS path\n", pathSource)
+		durationSeconds = int((gpsPath[len(gpsPath)-1].Timestamp - gpsPath[0].Timestamp) / 1000)
+		stats = h.calculateTripStatisticsFromGPS(gpsPath)
+	} else if len(s3TrackingData) > 1 {
+		fmt.Printf("DEBUG: Calculating trip statistics from S3 tracking data\n")
+		pathPoints = len(s3TrackingData)
+		durationSeconds = int((s3TrackingData[len(s3TrackingData)-1].Timestamp - s3TrackingData[0].Timestamp) / 1000)
+		stats = h.calculateTripStatistics(s3TrackingData)
 	} else {
-		fmt.Printf("DEBUG: Falling back to server-calculated trip statistics\n")
-		// Fallback: Calculate basic stats from available data
-		if len(gpsPath) > 1 {
-			// Calculate from mobile GPS path
-			durationSeconds = int((gpsPath[len(gpsPath)-1].Timestamp - gpsPath[0].Timestamp) / 1000)
-			stats = h.calculateTripStatisticsFromGPS(gpsPath)
-		} else {
-			// Calculate from S3 data (legacy fallback)
-			durationSeconds = int(time.Now().Sub(session.StartedAt).Seconds())
-			// Basic stats only since S3 has limited data
-		}
+		// Not enough points for statistics, only the duration is known
+		durationSeconds = int(time.Now().Sub(session.StartedAt).Seconds())
 	}
 
 	// Create trip record
@@ -1228,7 +1835,7 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		ToStationID:      stationInfo.ToStationID,
 		ToStationName:    stationInfo.ToStationName,
 		
-		// Statistical data (mobile-calculated preferred)
+		// Statistical data (server-calculated)
 		TotalDistanceKm:  stats.TotalDistanceKm,
 		MaxSpeedKmh:      stats.MaxSpeedKmh,
 		AvgSpeedKmh:      stats.AvgSpeedKmh,
@@ -1236,6 +1843,11 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		MinElevationM:    stats.MinElevationM,
 		ElevationGainM:   stats.ElevationGainM,
 		DurationSeconds:  durationSeconds,
+		ElevationLossM:     stats.ElevationLossM,
+		MovingTimeSeconds:  stats.MovingTimeSeconds,
+		StoppedTimeSeconds: stats.StoppedTimeSeconds,
+		AvgMovingSpeedKmh:  stats.AvgMovingSpeedKmh,
+		StopCount:          stats.StopCount,
 		
 		// Position data
 		StartLatitude:    startLat,
@@ -1256,8 +1868,18 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 		CompletedAt:      time.Now(),
 	}
 
+	verifyTripSummary(&trip, mobileSummary, pathPoints)
+	if trip.StatsFlagged {
+		fmt.Printf("WARNING: Trip of session %s flagged, app summary doesn't match server statistics: %s\n",
+			session.SessionID, *trip.StatsFlagReason)
+	}
+
+	// Large tracking data can live in S3 instead of the trip row
+	h.storeTripTrackingData(&trip, session)
+
 	// Save to database
 	if err := h.db.Create(&trip).Error; err != nil {
+		h.deleteTripTrackingData(trip.TrackingDataKey)
 		fmt.Printf("ERROR: Failed to save trip: %v\n", err)
 		return nil, fmt.Sprintf("Database error: %v", err)
 	}
@@ -1269,8 +1891,8 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 	}
 	
 	if len(gpsPath) > 0 {
-		fmt.Printf("DEBUG: Saved trip ID %d with mobile GPS path (%d points) - %.2fkm, %.1fkm/h max, %ds duration, %s\n", 
-			trip.ID, len(gpsPath), stats.TotalDistanceKm, stats.MaxSpeedKmh, durationSeconds, stationLog)
+		fmt.Printf("DEBUG: Saved trip ID %d with %s GPS path (%d points) - %.2fkm, %.1fkm/h max, %ds duration, %s\n", 
+			trip.ID, pathSource, len(gpsPath), stats.TotalDistanceKm, stats.MaxSpeedKmh, durationSeconds, stationLog)
 	} else {
 		fmt.Printf("DEBUG: Saved trip ID %d with S3 fallback data - %.2fkm, %.1fkm/h max, %ds duration, %s\n", 
 			trip.ID, stats.TotalDistanceKm, stats.MaxSpeedKmh, durationSeconds, stationLog)
@@ -1279,7 +1901,7 @@ func (h *SimpleLiveTrackingHandler) saveUserTrip(session models.LiveTrackingSess
 	return &trip.ID, ""
 }
 
-// Trip statistics structure (server-calculated fallback)
+// Trip statistics structure (server-calculated, stored on the trip)
 type TripStatistics struct {
 	TotalDistanceKm  float64
 	MaxSpeedKmh      float64
@@ -1291,9 +1913,14 @@ type TripStatistics struct {
 	MaxSpeedLng      *float64
 	MaxElevationLat  *float64
 	MaxElevationLng  *float64
+	ElevationLossM     int // ElevationGainM/ElevationLossM are smoothed cumulative ascent/descent
+	MovingTimeSeconds  int
+	StoppedTimeSeconds int
+	AvgMovingSpeedKmh  float64
+	StopCount          int
 }
 
-// Mobile-calculated trip summary (preferred approach)
+// Mobile-calculated trip summary (stored for comparison, the server statistics win)
 type TripSummary struct {
 	TotalDistanceKm     float64                `json:"total_distance_km"`
 	MaxSpeedKmh         float64                `json:"max_speed_kmh"`
@@ -1322,6 +1949,22 @@ type GPSPoint struct {
 	Heading   *float64 `json:"heading,omitempty"`
 }
 
+// LocationUpdate is a single live location update (same shape as the /update request body)
+type LocationUpdate struct {
+	SessionID string   `json:"session_id" binding:"required"`
+	Latitude  float64  `json:"latitude" binding:"required,min=-90,max=90"`
+	Longitude float64  `json:"longitude" binding:"required,min=-180,max=180"`
+	Accuracy  *float64 `json:"accuracy,omitempty"`
+	Speed     *float64 `json:"speed,omitempty"`
+	Heading   *float64 `json:"heading,omitempty"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+	// User status fields (optional)
+	StatusEmoji    *string `json:"status_emoji,omitempty"`
+	StatusMessage  *string `json:"status_message,omitempty"`
+	// Set by the server after plausibility checks, never bound from the request
+	QualityFlag    string  `json:"-"`
+}
+
 // Station information for trip
 type StationInfo struct {
 	TrainRelation   *string `json:"train_relation,omitempty"`
@@ -1333,76 +1976,22 @@ type StationInfo struct {
 
 // Calculate advanced trip statistics from GPS tracking data
 func (h *SimpleLiveTrackingHandler) calculateTripStatistics(trackingData []models.Passenger) TripStatistics {
-	stats := TripStatistics{}
-	
-	if len(trackingData) < 2 {
-		return stats // Not enough data
-	}
-	
-	var totalDistance float64 = 0
-	var totalSpeed float64 = 0
-	var speedCount int = 0
-	var maxSpeed float64 = 0
-	var maxElevation float64 = -1000
-	var minElevation float64 = 10000
-	
-	// Process each GPS point
-	for i, point := range trackingData {
-		// Calculate distance between consecutive points
-		if i > 0 {
-			prevPoint := trackingData[i-1]
-			distance := calculateDistance(prevPoint.Lat, prevPoint.Lng, point.Lat, point.Lng)
-			totalDistance += distance
-		}
-		
-		// Speed analysis
-		if point.Speed != nil && *point.Speed > 0 {
-			speed := *point.Speed
-			totalSpeed += speed
-			speedCount++
-			
-			if speed > maxSpeed {
-				maxSpeed = speed
-				stats.MaxSpeedLat = &point.Lat
-				stats.MaxSpeedLng = &point.Lng
-			}
-		}
-		
-		// Elevation analysis (if available)
-		if point.Altitude != nil {
-			altitude := *point.Altitude
-			if altitude > maxElevation {
-				maxElevation = altitude
-				stats.MaxElevationLat = &point.Lat
-				stats.MaxElevationLng = &point.Lng
-			}
-			if altitude < minElevation {
-				minElevation = altitude
-			}
-		}
-	}
-	
-	// Finalize statistics
-	stats.TotalDistanceKm = totalDistance
-	stats.MaxSpeedKmh = maxSpeed * 3.6 // Convert m/s to km/h
-	if speedCount > 0 {
-		stats.AvgSpeedKmh = (totalSpeed / float64(speedCount)) * 3.6
-	}
-	
-	if maxElevation > -1000 {
-		stats.MaxElevationM = int(maxElevation)
-	}
-	if minElevation < 10000 {
-		stats.MinElevationM = int(minElevation)
-	}
-	if maxElevation > -1000 && minElevation < 10000 {
-		stats.ElevationGainM = int(maxElevation - minElevation)
+	gpsPath := make([]GPSPoint, 0, len(trackingData))
+	for _, point := range trackingData {
+		gpsPath = append(gpsPath, GPSPoint{
+			Lat:       point.Lat,
+			Lng:       point.Lng,
+			Timestamp: point.Timestamp,
+			Speed:     point.Speed,
+			Altitude:  point.Altitude,
+			Accuracy:  point.Accuracy,
+			Heading:   point.Heading,
+		})
 	}
-	
-	return stats
+	return h.calculateTripStatisticsFromGPS(normalizeGPSPath(gpsPath))
 }
 
-// Calculate trip statistics from mobile GPS points
+// Calculate trip statistics from mobile GPS points; the path must be normalized (see normalizeGPSPath)
 func (h *SimpleLiveTrackingHandler) calculateTripStatisticsFromGPS(gpsPath []GPSPoint) TripStatistics {
 	stats := TripStatistics{}
 	
@@ -1410,7 +1999,8 @@ func (h *SimpleLiveTrackingHandler) calculateTripStatisticsFromGPS(gpsPath []GPS
 		return stats // Not enough data
 	}
 	
-	var totalDistance float64 = 0
+	path := gpsPath
+	
 	var totalSpeed float64 = 0
 	var speedCount int = 0
 	var maxSpeed float64 = 0
@@ -1418,13 +2008,8 @@ func (h *SimpleLiveTrackingHandler) calculateTripStatisticsFromGPS(gpsPath []GPS
 	var minElevation float64 = 10000
 	
 	// Process each GPS point
-	for i, point := range gpsPath {
-		// Calculate distance between consecutive points
-		if i > 0 {
-			prevPoint := gpsPath[i-1]
-			distance := calculateDistance(prevPoint.Lat, prevPoint.Lng, point.Lat, point.Lng)
-			totalDistance += distance
-		}
+	for i := range path {
+		point := &path[i]
 		
 		// Speed analysis
 		if point.Speed != nil && *point.Speed > 0 {
@@ -1454,8 +2039,16 @@ func (h *SimpleLiveTrackingHandler) calculateTripStatisticsFromGPS(gpsPath []GPS
 	}
 	
 	// Finalize statistics
-	stats.TotalDistanceKm = totalDistance
+	stats.TotalDistanceKm = jitterFreeDistanceKm(path)
 	stats.MaxSpeedKmh = maxSpeed * 3.6 // Convert m/s to km/h
+	
+	motion := analyzeTripMotion(path)
+	stats.MovingTimeSeconds = int(motion.moving.Seconds())
+	stats.StoppedTimeSeconds = int(motion.stopped.Seconds())
+	stats.StopCount = motion.stops
+	if motion.moving > 0 {
+		stats.AvgMovingSpeedKmh = stats.TotalDistanceKm / motion.moving.Hours()
+	}
 	if speedCount > 0 {
 		stats.AvgSpeedKmh = (totalSpeed / float64(speedCount)) * 3.6
 	}
@@ -1466,9 +2059,9 @@ func (h *SimpleLiveTrackingHandler) calculateTripStatisticsFromGPS(gpsPath []GPS
 	if minElevation < 10000 {
 		stats.MinElevationM = int(minElevation)
 	}
-	if maxElevation > -1000 && minElevation < 10000 {
-		stats.ElevationGainM = int(maxElevation - minElevation)
-	}
+	gain, loss := smoothedElevationChange(path)
+	stats.ElevationGainM = int(math.Round(gain))
+	stats.ElevationLossM = int(math.Round(loss))
 	
 	return stats
 }
@@ -1491,12 +2084,62 @@ func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c / 1000 // Return distance in kilometers
 }
 
-// startCacheUpdater starts a background goroutine to update trains list cache every 5 seconds
-func (h *SimpleLiveTrackingHandler) startCacheUpdater() {
-	if h.redis == nil {
-		return // No Redis, no cache updater needed
+// validateGPSBatch drops invalid and duplicate points and returns the rest ordered by timestamp,
+// together with a count of rejected points per reason
+func validateGPSBatch(points []GPSPoint, sessionStartedAt time.Time, now time.Time) ([]GPSPoint, map[string]int) {
+	rejectedReasons := make(map[string]int)
+	var valid []GPSPoint
+	
+	// Allow a little clock skew between the phone and the server
+	earliest := sessionStartedAt.Add(-1 * time.Minute).UnixMilli()
+	latest := now.Add(1 * time.Minute).UnixMilli()
+	
+	for _, point := range points {
+		switch {
+		case point.Lat < -90 || point.Lat > 90 || point.Lng < -180 || point.Lng > 180:
+			rejectedReasons["invalid_coordinates"]++
+		case point.Lat == 0 && point.Lng == 0:
+			rejectedReasons["invalid_coordinates"]++
+		case point.Timestamp <= 0:
+			rejectedReasons["missing_timestamp"]++
+		case point.Timestamp < earliest:
+			rejectedReasons["before_session_start"]++
+		case point.Timestamp > latest:
+			rejectedReasons["timestamp_in_future"]++
+		default:
+			valid = append(valid, point)
+		}
+	}
+	
+	deduped := normalizeGPSPath(valid)
+	if duplicates := len(valid) - len(deduped); duplicates > 0 {
+		rejectedReasons["duplicate"] += duplicates
+	}
+	
+	return deduped, rejectedReasons
+}
+
+// normalizeGPSPath sorts points by timestamp and keeps only the first point for each timestamp
+func normalizeGPSPath(points []GPSPoint) []GPSPoint {
+	sorted := make([]GPSPoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+	
+	result := make([]GPSPoint, 0, len(sorted))
+	for i, point := range sorted {
+		if i > 0 && point.Timestamp == sorted[i-1].Timestamp {
+			continue
+		}
+		result = append(result, point)
 	}
 	
+	return result
+}
+
+// startCacheUpdater starts a background goroutine to update trains list cache every 5 seconds
+func (h *SimpleLiveTrackingHandler) startCacheUpdater(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 	
@@ -1504,6 +2147,8 @@ func (h *SimpleLiveTrackingHandler) startCacheUpdater() {
 	
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			h.updateTrainsListCache()
 		}
@@ -1528,8 +2173,8 @@ func (h *SimpleLiveTrackingHandler) updateTrainsListCache() {
 
 // getCachedTrainsList returns cached trains list or generates new one if cache is stale
 func (h *SimpleLiveTrackingHandler) getCachedTrainsList() map[string]interface{} {
-	if h.redis == nil {
-		// No Redis, use direct database query (old behavior)
+	if !h.store.TracksSessions() {
+		// Legacy mode, use direct database query (old behavior)
 		return h.generateTrainsListFromDatabase()
 	}
 	
@@ -1551,62 +2196,50 @@ func (h *SimpleLiveTrackingHandler) getCachedTrainsList() map[string]interface{}
 	return h.generateTrainsListFromDatabase()
 }
 
-// startRedisSyncToS3 starts a background goroutine to sync Redis data to S3 every 88 seconds
-func (h *SimpleLiveTrackingHandler) startRedisSyncToS3() {
-	if h.redis == nil {
-		return // No Redis, no sync needed
-	}
-	
+// startLiveSyncToS3 starts a background goroutine to sync live train data to S3 every 88 seconds
+func (h *SimpleLiveTrackingHandler) startLiveSyncToS3(ctx context.Context) {
 	ticker := time.NewTicker(88 * time.Second)
 	defer ticker.Stop()
 	
-	fmt.Printf("INFO: Started Redis to S3 sync process (88-second interval)\n")
+	fmt.Printf("INFO: Started %s live store to S3 sync process (88-second interval)\n", h.store.Name())
 	
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			h.syncRedisToS3()
+			h.syncLiveToS3()
 		}
 	}
 }
 
-// syncRedisToS3 syncs all live train data from Redis to S3 for backup/failover
-func (h *SimpleLiveTrackingHandler) syncRedisToS3() {
-	if h.redis == nil {
+// syncLiveToS3 syncs all live train data from the live store to S3 for backup/failover
+func (h *SimpleLiveTrackingHandler) syncLiveToS3() {
+	if !h.store.TracksSessions() {
 		return
 	}
 	
-	ctx := context.Background()
-	
-	// Get all live train keys from Redis
-	trainKeys, err := h.redis.Keys(ctx, "train_live:*").Result()
+	// Get all live trains from the store's index
+	trainNumbers, err := h.store.ListTrains()
 	if err != nil {
-		fmt.Printf("ERROR: Failed to get train keys from Redis: %v\n", err)
+		fmt.Printf("ERROR: Failed to list live trains: %v\n", err)
 		return
 	}
 	
 	syncCount := 0
-	for _, key := range trainKeys {
-		// Extract train number from key (train_live:TRAIN-123 -> TRAIN-123)
-		trainNumber := key[11:] // Remove "train_live:" prefix
-		
-		// Get train data from Redis
-		trainDataStr, err := h.redis.Get(ctx, key).Result()
+	for _, trainNumber := range trainNumbers {
+		// Get train data from the live store
+		trainData, err := h.store.GetTrain(trainNumber)
+		if err == ErrLiveStoreNotFound {
+			continue // Expired since it was listed
+		}
 		if err != nil {
 			fmt.Printf("ERROR: Failed to get train data for %s: %v\n", trainNumber, err)
 			continue
 		}
 		
-		// Parse train data
-		var trainData models.TrainData
-		if err := json.Unmarshal([]byte(trainDataStr), &trainData); err != nil {
-			fmt.Printf("ERROR: Failed to parse train data for %s: %v\n", trainNumber, err)
-			continue
-		}
-		
-		// Sync to S3 (same structure as before)
-		fileName := fmt.Sprintf("trains/train-%s.json", trainNumber)
-		if err := h.s3.UploadJSON(fileName, trainData); err != nil {
+		// Sync to S3 (same structure as before, the train file keeps its fencing token)
+		if err := h.trainFiles.PutTrain(trainNumber, trainData, 0); err != nil {
 			fmt.Printf("ERROR: Failed to sync train %s to S3: %v\n", trainNumber, err)
 			continue
 		}
@@ -1615,38 +2248,31 @@ func (h *SimpleLiveTrackingHandler) syncRedisToS3() {
 	}
 	
 	if syncCount > 0 {
-		fmt.Printf("INFO: Synced %d trains from Redis to S3 (88-second backup)\n", syncCount)
+		fmt.Printf("INFO: Synced %d trains from %s live store to S3 (88-second backup)\n", syncCount, h.store.Name())
 	}
 }
 
-// Redis GPS Helper Methods
+// Live store GPS helper methods
 
-// storeGPSInRedis stores user's GPS position in Redis for real-time tracking
-func (h *SimpleLiveTrackingHandler) storeGPSInRedis(sessionID string, userID uint, trainNumber string, req struct {
-	SessionID string   `json:"session_id" binding:"required"`
-	Latitude  float64  `json:"latitude" binding:"required,min=-90,max=90"`
-	Longitude float64  `json:"longitude" binding:"required,min=-180,max=180"`
-	Accuracy  *float64 `json:"accuracy,omitempty"`
-	Speed     *float64 `json:"speed,omitempty"`
-	Heading   *float64 `json:"heading,omitempty"`
-	Altitude  *float64 `json:"altitude,omitempty"`
-	// User status fields (optional)
-	StatusEmoji    *string `json:"status_emoji,omitempty"`
-	StatusMessage  *string `json:"status_message,omitempty"`
-}, user *models.User) error {
-	if h.redis == nil {
-		return fmt.Errorf("Redis not available")
+// storeLiveGPS stores user's GPS position in the live store for real-time tracking. The caller
+// must hold trainMutex.
+func (h *SimpleLiveTrackingHandler) storeLiveGPS(sessionID string, userID uint, trainNumber string, req LocationUpdate, user *models.User, trainMutex TrainLock) error {
+	return h.storeLiveGPSAt(sessionID, userID, trainNumber, req, time.Now().UnixMilli(), trainMutex)
+}
+
+// storeLiveGPSAt stores user's GPS position in the live store using the point's own timestamp (Unix milliseconds)
+func (h *SimpleLiveTrackingHandler) storeLiveGPSAt(sessionID string, userID uint, trainNumber string, req LocationUpdate, timestamp int64, trainMutex TrainLock) error {
+	if !h.store.TracksSessions() {
+		return fmt.Errorf("%s live store keeps no session positions", h.store.Name())
 	}
 	
-	ctx := context.Background()
-	
 	// Store individual session data with all GPS metadata
 	sessionData := map[string]interface{}{
 		"user_id":      userID,
 		"train_number": trainNumber,
 		"lat":          req.Latitude,
 		"lng":          req.Longitude,
-		"timestamp":    time.Now().UnixMilli(),
+		"timestamp":    timestamp,
 		"status":       "active",
 	}
 	
@@ -1663,11 +2289,14 @@ func (h *SimpleLiveTrackingHandler) storeGPSInRedis(sessionID string, userID uin
 	if req.Altitude != nil {
 		sessionData["altitude"] = *req.Altitude
 	}
+	if req.QualityFlag != "" {
+		sessionData["quality_flag"] = req.QualityFlag
+	}
 	
 	// Handle user status - persist last status or clear if explicitly requested
 	if req.StatusEmoji != nil && req.StatusMessage != nil {
 		if *req.StatusEmoji == "" && *req.StatusMessage == "" {
-			// Clear status - remove from Redis
+			// Clear status - remove from the live store
 			fmt.Printf("DEBUG: User %d clearing status\n", userID)
 			// Don't store status fields - they will be absent from sessionData
 		} else {
@@ -1678,7 +2307,7 @@ func (h *SimpleLiveTrackingHandler) storeGPSInRedis(sessionID string, userID uin
 			fmt.Printf("DEBUG: User %d set status: %s %s\n", userID, *req.StatusEmoji, *req.StatusMessage)
 		}
 	} else {
-		// No status fields sent - preserve existing status by reading from current Redis
+		// No status fields sent - preserve existing status by reading from the live store
 		fmt.Printf("DEBUG: User %d sent GPS without status - preserving last status\n", userID)
 		if err := h.preserveExistingStatus(sessionID, sessionData); err != nil {
 			fmt.Printf("DEBUG: Failed to preserve status for session %s: %v\n", sessionID, err)
@@ -1692,56 +2321,66 @@ func (h *SimpleLiveTrackingHandler) storeGPSInRedis(sessionID string, userID uin
 		sessionData["station_name"] = userCache.StationName
 	}
 	
-	sessionJSON, err := json.Marshal(sessionData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal session data: %v", err)
+	// Store session data with 10-minute expiration (auto-cleanup)
+	if err := h.store.SetSession(sessionID, sessionData); err != nil {
+		return err
 	}
 	
-	// Store session data with 10-minute expiration (auto-cleanup)
-	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
-	if err := h.redis.Set(ctx, sessionKey, sessionJSON, 10*time.Minute).Err(); err != nil {
-		return fmt.Errorf("failed to store session in Redis: %v", err)
+	// Append the full point to the session's path history (used for trip saving)
+	point := GPSPoint{
+		Lat:       req.Latitude,
+		Lng:       req.Longitude,
+		Timestamp: timestamp,
+		Speed:     req.Speed,
+		Altitude:  req.Altitude,
+		Accuracy:  req.Accuracy,
+		Heading:   req.Heading,
+	}
+	if err := h.store.AppendPath(sessionID, point); err != nil {
+		fmt.Printf("WARNING: Failed to append GPS path history for session %s: %v\n", sessionID, err)
 	}
 	
-	// Update train's live data
-	return h.updateTrainDataInRedis(trainNumber)
+	// Update train's live data and let every instance's WebSocket hub know
+	if err := h.updateLiveTrainData(trainNumber, trainMutex); err != nil {
+		return err
+	}
+	publishLiveEvent(h.store, LiveEvent{
+		Type:        liveEventLocationUpdated,
+		TrainNumber: trainNumber,
+		SessionID:   sessionID,
+		UserID:      userID,
+	})
+	return nil
 }
 
-// updateTrainDataInRedis rebuilds the train data from all active sessions for that train
-func (h *SimpleLiveTrackingHandler) updateTrainDataInRedis(trainNumber string) error {
-	if h.redis == nil {
-		return fmt.Errorf("Redis not available")
+// updateLiveTrainData rebuilds the train data from all active sessions for that train. The
+// caller must hold trainMutex; the writes are fenced with its token.
+func (h *SimpleLiveTrackingHandler) updateLiveTrainData(trainNumber string, trainMutex TrainLock) error {
+	if !h.store.TracksSessions() {
+		return fmt.Errorf("%s live store keeps no session positions", h.store.Name())
 	}
-	
-	ctx := context.Background()
+	if trainMutex == nil || !trainMutex.Held() {
+		return fmt.Errorf("train lock for %s not held, not writing train data", trainNumber)
+	}
+	fence := trainMutex.Token()
 	
 	// Get all sessions for this train from database (source of truth)
 	var sessions []models.LiveTrackingSession
 	h.db.Where("train_number = ? AND status = ?", trainNumber, "active").Find(&sessions)
 	
 	if len(sessions) == 0 {
-		// No active sessions, remove train from Redis
-		trainKey := fmt.Sprintf("train_live:%s", trainNumber)
-		h.redis.Del(ctx, trainKey)
-		return nil
+		// No active sessions, remove train from the live store
+		return h.store.DeleteTrain(trainNumber, fence)
 	}
 	
 	var passengers []models.Passenger
-	var totalLat, totalLng float64
 	activeCount := 0
 	
-	// Get GPS data for each session from Redis
+	// Get GPS data for each session from the live store
 	for _, session := range sessions {
-		sessionKey := fmt.Sprintf("live_session:%s", session.SessionID)
-		sessionDataStr, err := h.redis.Get(ctx, sessionKey).Result()
-		
+		sessionData, err := h.store.GetSession(session.SessionID)
 		if err != nil {
-			// Session not in Redis, skip (might be expired or not updated yet)
-			continue
-		}
-		
-		var sessionData map[string]interface{}
-		if err := json.Unmarshal([]byte(sessionDataStr), &sessionData); err != nil {
+			// Session not in the live store, skip (might be expired or not updated yet)
 			continue
 		}
 		
@@ -1767,7 +2406,7 @@ func (h *SimpleLiveTrackingHandler) updateTrainDataInRedis(trainNumber string) e
 			StationName:   stationName,
 		}
 		
-		// Add user status if available in Redis
+		// Add user status if available in the live store
 		if statusEmoji, hasEmoji := sessionData["status_emoji"].(string); hasEmoji {
 			if statusMessage, hasMessage := sessionData["status_message"].(string); hasMessage {
 				if statusTimestamp, hasTimestamp := sessionData["status_timestamp"].(string); hasTimestamp {
@@ -1776,14 +2415,14 @@ func (h *SimpleLiveTrackingHandler) updateTrainDataInRedis(trainNumber string) e
 						Message:   statusMessage,
 						Timestamp: statusTimestamp,
 					}
-					fmt.Printf("DEBUG: Retrieved status from Redis for user %d: %s %s\n", session.UserID, statusEmoji, statusMessage)
+					fmt.Printf("DEBUG: Retrieved status from live store for user %d: %s %s\n", session.UserID, statusEmoji, statusMessage)
 				}
 			}
 		} else {
-			fmt.Printf("DEBUG: No status found in Redis for user %d (keys: %v)\n", session.UserID, sessionData)
+			fmt.Printf("DEBUG: No status found in live store for user %d (keys: %v)\n", session.UserID, sessionData)
 		}
 		
-		// Add optional GPS metadata if available in Redis
+		// Add optional GPS metadata if available in the live store
 		if accuracy, exists := sessionData["accuracy"]; exists {
 			if val, ok := accuracy.(float64); ok {
 				passenger.Accuracy = &val
@@ -1804,126 +2443,86 @@ func (h *SimpleLiveTrackingHandler) updateTrainDataInRedis(trainNumber string) e
 				passenger.Altitude = &val
 			}
 		}
+		passenger.QualityFlag, _ = sessionData["quality_flag"].(string)
 		
 		passengers = append(passengers, passenger)
-		totalLat += lat
-		totalLng += lng
 		activeCount++
 	}
 	
 	if activeCount == 0 {
 		// No GPS data available, remove train
-		trainKey := fmt.Sprintf("train_live:%s", trainNumber)
-		h.redis.Del(ctx, trainKey)
-		return nil
+		return h.store.DeleteTrain(trainNumber, fence)
 	}
 	
+	estimate := aggregateTrainPosition(passengers)
+	
 	// Build train data structure
 	trainData := models.TrainData{
-		TrainID:         trainNumber,
-		Route:           fmt.Sprintf("Route information for train %s", trainNumber),
-		PassengerCount:  activeCount,
-		AveragePosition: models.Position{Lat: totalLat / float64(activeCount), Lng: totalLng / float64(activeCount)},
+		TrainID:          trainNumber,
+		PassengerCount:   activeCount,
+		AveragePosition:  estimate.Position,
+		ConfidenceRadius: &estimate.ConfidenceRadiusM,
+		RouteMatch:       h.routes.match(trainNumber, estimate.Position),
 		Passengers:      passengers,
 		LastUpdate:      time.Now().Format(time.RFC3339),
 		Status:          "active",
-		DataSource:      "redis-live-gps",
-	}
-	
-	trainJSON, err := json.Marshal(trainData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal train data: %v", err)
+		DataSource:      fmt.Sprintf("%s-live-gps", h.store.Name()),
 	}
+	applyTrainMetadata(&trainData, h.getTrain(sessions[0].TrainID), h.routes)
 	
 	// Store train data with 15-minute expiration
-	trainKey := fmt.Sprintf("train_live:%s", trainNumber)
-	if err := h.redis.Set(ctx, trainKey, trainJSON, 15*time.Minute).Err(); err != nil {
-		return fmt.Errorf("failed to store train data in Redis: %v", err)
+	if err := h.store.PutTrain(trainNumber, &trainData, fence); err != nil {
+		return fmt.Errorf("failed to store train data in %s live store: %v", h.store.Name(), err)
 	}
+	h.archive.RecordSnapshot(&trainData)
 	
 	return nil
 }
 
-// getTrainDataFromRedis gets train data from Redis with S3 failover
-func (h *SimpleLiveTrackingHandler) getTrainDataFromRedis(trainNumber string) (*models.TrainData, error) {
-	if h.redis != nil {
-		// Try Redis first
-		ctx := context.Background()
-		trainKey := fmt.Sprintf("train_live:%s", trainNumber)
-		trainDataStr, err := h.redis.Get(ctx, trainKey).Result()
-		
-		if err == nil {
-			var trainData models.TrainData
-			if err := json.Unmarshal([]byte(trainDataStr), &trainData); err == nil {
-				return &trainData, nil
-			}
-		}
+// getLiveGPSPath gets complete GPS tracking history for a user session from the live store
+func (h *SimpleLiveTrackingHandler) getLiveGPSPath(sessionID string) ([]GPSPoint, error) {
+	// Read the full path history recorded by storeLiveGPS
+	gpsPath, err := h.store.GetPath(sessionID)
+	if err != nil {
+		return nil, err
 	}
 	
-	// Fallback to S3 if Redis fails or data not found
-	fileName := fmt.Sprintf("trains/train-%s.json", trainNumber)
-	return h.s3.GetTrainData(fileName)
-}
-
-// getGPSPathFromRedis gets complete GPS tracking history for a user session from Redis
-func (h *SimpleLiveTrackingHandler) getGPSPathFromRedis(sessionID string, userID uint, trainNumber string) ([]GPSPoint, error) {
-	if h.redis == nil {
-		return nil, fmt.Errorf("Redis not available")
+	if len(gpsPath) > 0 {
+		// Batched offline uploads can arrive after newer live points - return the path in time order
+		return normalizeGPSPath(gpsPath), nil
 	}
 	
-	ctx := context.Background()
-	
-	// First try to get the session data from Redis
-	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
-	sessionDataStr, err := h.redis.Get(ctx, sessionKey).Result()
-	
+	// No history (e.g. session started before path history existed) - use the latest position
+	sessionData, err := h.store.GetSession(sessionID)
 	if err != nil {
-		return nil, fmt.Errorf("session not found in Redis: %v", err)
-	}
-	
-	var sessionData map[string]interface{}
-	if err := json.Unmarshal([]byte(sessionDataStr), &sessionData); err != nil {
-		return nil, fmt.Errorf("failed to parse session data: %v", err)
+		return nil, fmt.Errorf("session not found in live store: %v", err)
 	}
 	
-	// For now, we only have the latest GPS position in Redis
-	// In a production system, you'd store a GPS path history
-	// But we can create a single point from the current position
-	lat, _ := sessionData["lat"].(float64)
-	lng, _ := sessionData["lng"].(float64)
-	timestamp, _ := sessionData["timestamp"].(float64)
-	
-	// Create GPS path with current position
-	// Note: This gives us the most recent position from Redis
-	gpsPath := []GPSPoint{
+	position := sessionPosition(sessionData)
+	return []GPSPoint{
 		{
-			Lat:       lat,
-			Lng:       lng,
-			Timestamp: int64(timestamp),
+			Lat:       position.Lat,
+			Lng:       position.Lng,
+			Timestamp: position.Timestamp,
 		},
-	}
-	
-	return gpsPath, nil
+	}, nil
 }
 
-// cleanupRedisSession removes user session data from Redis
-func (h *SimpleLiveTrackingHandler) cleanupRedisSession(sessionID string, userID uint, trainNumber string) error {
-	if h.redis == nil {
-		return nil // No Redis, nothing to clean
+// cleanupLiveSession removes user session data from the live store. The caller must hold trainMutex.
+func (h *SimpleLiveTrackingHandler) cleanupLiveSession(sessionID string, trainNumber string, trainMutex TrainLock) error {
+	if !h.store.TracksSessions() {
+		return nil // Legacy mode, nothing to clean
 	}
 	
-	ctx := context.Background()
-	
-	// Remove user's session data
-	sessionKey := fmt.Sprintf("live_session:%s", sessionID)
-	if err := h.redis.Del(ctx, sessionKey).Err(); err != nil {
-		fmt.Printf("WARNING: Failed to delete session from Redis: %v\n", err)
+	// Remove user's session data and path history
+	if err := h.store.DeleteSession(sessionID); err != nil {
+		fmt.Printf("WARNING: Failed to delete session from live store: %v\n", err)
 	} else {
-		fmt.Printf("DEBUG: Cleaned up Redis session data for %s\n", sessionID)
+		fmt.Printf("DEBUG: Cleaned up live session data for %s\n", sessionID)
 	}
 	
 	// Update the train data to remove this user
-	return h.updateTrainDataInRedis(trainNumber)
+	return h.updateLiveTrainData(trainNumber, trainMutex)
 }
 
 // generateTrainsListFromDatabaseOptimized - Optimized version with reduced S3 calls
@@ -2001,8 +2600,7 @@ func (h *SimpleLiveTrackingHandler) generateTrainsListFromDatabase() map[string]
 		}
 		
 		// Try to get additional data from S3 train file (optional enhancement)
-		fileName := fmt.Sprintf("trains/train-%s.json", trainNumber)
-		trainData, err := h.s3.GetTrainData(fileName)
+		trainData, err := h.trainFiles.GetTrain(trainNumber)
 		
 		var avgPosition models.Position
 		var lastUpdate string
@@ -1,20 +1,21 @@
 package handlers
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 
 	"github.com/modernland/golang-live-tracking/middleware"
 )
 
+// Spotter locations expire after this long without a heartbeat
+const spotterLocationTTL = 5 * time.Minute
+
 // SpotterLocation represents a user's location while viewing the map
 type SpotterLocation struct {
 	UserID           uint    `json:"user_id"`
@@ -40,6 +41,18 @@ type PublicSpotterLocation struct {
 	IsActive   bool    `json:"is_active"`
 }
 
+// NearbySpotterResponse is a spotter near the queried point, filtered like PublicSpotterLocation
+type NearbySpotterResponse struct {
+	PublicSpotterLocation
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// AdminNearbySpotterResponse is a spotter near the queried point with all data (admin only)
+type AdminNearbySpotterResponse struct {
+	SpotterLocation
+	DistanceKm float64 `json:"distance_km"`
+}
+
 // SpottersResponse for public API responses
 type SpottersResponse struct {
 	Spotters    []PublicSpotterLocation `json:"spotters"`
@@ -58,25 +71,29 @@ type AdminSpottersResponse struct {
 // SpotterHandler handles train spotter location tracking
 type SpotterHandler struct {
 	db          *gorm.DB
-	redis       *redis.Client
+	store       LiveStore
+	supported   atomic.Bool // the live store currently keeps spotters
 	cache       []SpotterLocation
 	cacheMutex  sync.RWMutex
 	lastCacheUpdate time.Time
 }
 
 // NewSpotterHandler creates a new spotter location handler
-func NewSpotterHandler(db *gorm.DB, redisClient *redis.Client) *SpotterHandler {
+func NewSpotterHandler(db *gorm.DB, store LiveStore) *SpotterHandler {
 	handler := &SpotterHandler{
 		db:    db,
-		redis: redisClient,
+		store: store,
 		cache: make([]SpotterLocation, 0),
 	}
 	
-	// Start cache updater if Redis is available
-	if redisClient != nil {
-		fmt.Printf("INFO: Starting spotter location cache updater\n")
-		go handler.startCacheUpdater()
+	// Start cache updater - it keeps running so spotters come back when the live store
+	// switches back from a fallback that keeps none
+	handler.updateSpotterCache()
+	if !handler.supported.Load() {
+		fmt.Printf("INFO: %s live store keeps no spotters, spotter locations disabled\n", store.Name())
 	}
+	fmt.Printf("INFO: Starting spotter location cache updater (%s live store)\n", store.Name())
+	go handler.startCacheUpdater()
 	
 	return handler
 }
@@ -128,7 +145,7 @@ func (h *SpotterHandler) UpdateSpotterLocation(c *gin.Context) {
 		HideIdentity: req.HideIdentity,
 	}
 
-	// Store in Redis with 5-minute expiration
+	// Store in the live store with 5-minute expiration
 	if err := h.storeSpotterLocation(spotter); err != nil {
 		fmt.Printf("ERROR: Failed to store spotter location: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -157,8 +174,8 @@ func (h *SpotterHandler) GetActiveSpotters(c *gin.Context) {
 		fmt.Printf("DEBUG: Admin user %d requesting spotter list\n", user.ID)
 	}
 	
-	if h.redis == nil {
-		// No Redis available, return empty list
+	if !h.supported.Load() {
+		// Live store keeps no spotters, return empty list
 		if isAdmin {
 			c.JSON(http.StatusOK, AdminSpottersResponse{
 				Spotters:    []SpotterLocation{},
@@ -212,23 +229,7 @@ func (h *SpotterHandler) GetActiveSpotters(c *gin.Context) {
 			continue
 		}
 		
-		publicSpotter := PublicSpotterLocation{
-			Latitude:   spotter.Latitude,
-			Longitude:  spotter.Longitude,
-			LastUpdate: spotter.LastUpdate,
-			IsActive:   spotter.IsActive,
-		}
-		
-		// Handle identity privacy
-		if spotter.HideIdentity {
-			publicSpotter.Username = "Anonymous User"
-			// Don't include UserID for anonymous users
-		} else {
-			publicSpotter.UserID = &spotter.UserID
-			publicSpotter.Username = spotter.Username
-		}
-		
-		publicSpotters = append(publicSpotters, publicSpotter)
+		publicSpotters = append(publicSpotters, toPublicSpotter(spotter))
 	}
 	
 	c.JSON(http.StatusOK, SpottersResponse{
@@ -240,27 +241,102 @@ func (h *SpotterHandler) GetActiveSpotters(c *gin.Context) {
 	fmt.Printf("DEBUG: Returned %d public spotters (filtered from %d total)\n", len(publicSpotters), len(spotters))
 }
 
+// GetNearbySpotters handles GET /api/spotters/nearby?lat=&lng=&radius=&limit=
+// Returns spotters nearest first, filtered by privacy settings unless the user is an admin
+func (h *SpotterHandler) GetNearbySpotters(c *gin.Context) {
+	query, err := parseNearbyQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 
-// storeSpotterLocation stores spotter data in Redis
-func (h *SpotterHandler) storeSpotterLocation(spotter SpotterLocation) error {
-	if h.redis == nil {
-		return fmt.Errorf("Redis not available")
+	user, exists := middleware.GetUserFromContext(c)
+	isAdmin := exists && user.Role == "admin"
+
+	var nearby []NearbySpotter
+	if h.supported.Load() {
+		// Hidden spotters are filtered after the search, so fetch the most the index may return
+		nearby, err = h.store.NearbySpotters(query.Lat, query.Lng, query.RadiusKm, nearbyMaxLimit)
+		if err != nil {
+			fmt.Printf("ERROR: Failed to find spotters near (%.6f, %.6f): %v\n", query.Lat, query.Lng, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to find nearby spotters",
+			})
+			return
+		}
 	}
-	
-	ctx := context.Background()
-	key := fmt.Sprintf("spotter_location:%d", spotter.UserID)
-	
-	data, err := json.Marshal(spotter)
-	if err != nil {
-		return fmt.Errorf("failed to marshal spotter data: %v", err)
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	if isAdmin {
+		spotters := make([]AdminNearbySpotterResponse, 0, len(nearby))
+		for _, spotter := range nearby {
+			if time.Since(time.UnixMilli(spotter.Spotter.LastUpdate)) > spotterLocationTTL {
+				continue
+			}
+			if len(spotters) == query.Limit {
+				break
+			}
+			spotters = append(spotters, AdminNearbySpotterResponse{SpotterLocation: spotter.Spotter, DistanceKm: spotter.DistanceKm})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"spotters":     spotters,
+			"total":        len(spotters),
+			"radius_km":    query.RadiusKm,
+			"last_updated": time.Now().Format(time.RFC3339),
+		})
+		return
 	}
-	
-	// Store with 5-minute expiration (auto-cleanup)
-	if err := h.redis.Set(ctx, key, data, 5*time.Minute).Err(); err != nil {
-		return fmt.Errorf("failed to store in Redis: %v", err)
+
+	spotters := make([]NearbySpotterResponse, 0, len(nearby))
+	for _, spotter := range nearby {
+		// Skip spotters who hide their location completely
+		if spotter.Spotter.HideLocation || time.Since(time.UnixMilli(spotter.Spotter.LastUpdate)) > spotterLocationTTL {
+			continue
+		}
+		if len(spotters) == query.Limit {
+			break
+		}
+		spotters = append(spotters, NearbySpotterResponse{PublicSpotterLocation: toPublicSpotter(spotter.Spotter), DistanceKm: spotter.DistanceKm})
 	}
-	
-	return nil
+	c.JSON(http.StatusOK, gin.H{
+		"spotters":     spotters,
+		"total":        len(spotters),
+		"radius_km":    query.RadiusKm,
+		"last_updated": time.Now().Format(time.RFC3339),
+	})
+}
+
+// toPublicSpotter applies the identity privacy setting for public responses
+func toPublicSpotter(spotter SpotterLocation) PublicSpotterLocation {
+	publicSpotter := PublicSpotterLocation{
+		Latitude:   spotter.Latitude,
+		Longitude:  spotter.Longitude,
+		LastUpdate: spotter.LastUpdate,
+		IsActive:   spotter.IsActive,
+	}
+
+	// Handle identity privacy
+	if spotter.HideIdentity {
+		publicSpotter.Username = "Anonymous User"
+		// Don't include UserID for anonymous users
+	} else {
+		userID := spotter.UserID
+		publicSpotter.UserID = &userID
+		publicSpotter.Username = spotter.Username
+	}
+
+	return publicSpotter
+}
+
+// storeSpotterLocation stores spotter data in the live store
+func (h *SpotterHandler) storeSpotterLocation(spotter SpotterLocation) error {
+	return h.store.PutSpotter(spotter)
 }
 
 // startCacheUpdater runs background cache updates every 30 seconds
@@ -278,36 +354,22 @@ func (h *SpotterHandler) startCacheUpdater() {
 	}
 }
 
-// updateSpotterCache refreshes the cached spotter list from Redis
+// updateSpotterCache refreshes the cached spotter list from the live store and records
+// whether the live store currently keeps spotters
 func (h *SpotterHandler) updateSpotterCache() {
-	if h.redis == nil {
+	stored, err := h.store.ListSpotters()
+	h.supported.Store(err != ErrLiveStoreUnsupported)
+	if err == ErrLiveStoreUnsupported {
 		return
 	}
-	
-	ctx := context.Background()
-	
-	// Get all spotter location keys
-	keys, err := h.redis.Keys(ctx, "spotter_location:*").Result()
 	if err != nil {
-		fmt.Printf("ERROR: Failed to get spotter keys: %v\n", err)
+		fmt.Printf("ERROR: Failed to get spotter locations: %v\n", err)
 		return
 	}
 	
+	// Only include recent spotters (within 5 minutes)
 	var spotters []SpotterLocation
-	
-	// Fetch each spotter's data
-	for _, key := range keys {
-		data, err := h.redis.Get(ctx, key).Result()
-		if err != nil {
-			continue // Skip expired or invalid keys
-		}
-		
-		var spotter SpotterLocation
-		if err := json.Unmarshal([]byte(data), &spotter); err != nil {
-			continue // Skip malformed data
-		}
-		
-		// Only include recent spotters (within 5 minutes)
+	for _, spotter := range stored {
 		if time.Since(time.UnixMilli(spotter.LastUpdate)) <= 5*time.Minute {
 			spotters = append(spotters, spotter)
 		}
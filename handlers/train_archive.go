@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils"
+)
+
+// Archive object layout: archive/<yyyy>/<mm>/<dd>/train-<n>/<unix ms>.ndjson.gz, one part per
+// flush, dated in the schedule timezone
+const archiveKeyPrefix = "archive/"
+
+// Archive record types
+const (
+	archiveRecordSnapshot = "snapshot" // aggregated train position (passengers are in the point records)
+	archiveRecordPoint    = "point"    // one passenger GPS point
+)
+
+const (
+	archiveMaxPendingRecords = 200000 // records kept in memory while S3 is unavailable, oldest dropped first
+	archiveMaxWriteAttempts  = 5      // part keys tried before giving up when another writer took the key
+	archivePruneInterval     = 24 * time.Hour
+)
+
+// ArchiveRecord is one NDJSON line of a train's daily archive
+type ArchiveRecord struct {
+	Type        string            `json:"type"`
+	TrainNumber string            `json:"train_number"`
+	Timestamp   int64             `json:"timestamp"` // Unix milliseconds
+	SessionID   string            `json:"session_id,omitempty"`
+	UserID      uint              `json:"user_id,omitempty"`
+	Point       *GPSPoint         `json:"point,omitempty"`
+	Train       *models.TrainData `json:"train,omitempty"`
+}
+
+// TrainArchiver buffers train snapshots and passenger points and writes them as gzip NDJSON parts
+// per train and day, so any train's movement can be reconstructed after the live data expired.
+// Each flush writes new part objects (never rewrites one), so several instances can archive the
+// same train safely and a flush costs the same however much the day already holds.
+type TrainArchiver struct {
+	s3            *utils.S3Client
+	flushInterval time.Duration
+	retention     time.Duration // 0 keeps archives forever
+
+	pending      map[string][]ArchiveRecord // buffered records per part prefix (train and day)
+	pendingCount int
+	mutex        sync.Mutex
+	flushMutex   sync.Mutex // one flush at a time
+}
+
+// NewTrainArchiver creates an archiver writing to S3. retention of 0 keeps archives forever.
+func NewTrainArchiver(s3Client *utils.S3Client, flushInterval, retention time.Duration) *TrainArchiver {
+	return &TrainArchiver{
+		s3:            s3Client,
+		flushInterval: flushInterval,
+		retention:     retention,
+		pending:       make(map[string][]ArchiveRecord),
+	}
+}
+
+// Start starts the background flush and retention loops. They stop when ctx is cancelled;
+// call Flush afterwards to write what is still buffered.
+func (a *TrainArchiver) Start(ctx context.Context) {
+	go a.runFlusher(ctx)
+	if a.retention > 0 {
+		go a.runPruner(ctx)
+	}
+}
+
+func (a *TrainArchiver) runFlusher(ctx context.Context) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	fmt.Printf("INFO: Started train archiver (flush interval: %s, retention: %s)\n", a.flushInterval, a.retention)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Flush()
+		}
+	}
+}
+
+func (a *TrainArchiver) runPruner(ctx context.Context) {
+	ticker := time.NewTicker(archivePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		a.pruneExpired()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecordSnapshot archives a train's aggregated live data. Safe to call on a nil archiver.
+func (a *TrainArchiver) RecordSnapshot(trainData *models.TrainData) {
+	if a == nil || trainData == nil {
+		return
+	}
+
+	snapshot := *trainData
+	snapshot.Passengers = nil // Kept once per point, not repeated in every snapshot
+	a.add(ArchiveRecord{
+		Type:        archiveRecordSnapshot,
+		TrainNumber: trainData.TrainID,
+		Timestamp:   time.Now().UnixMilli(),
+		Train:       &snapshot,
+	})
+}
+
+// RecordPoints archives GPS points of one passenger session. Safe to call on a nil archiver.
+func (a *TrainArchiver) RecordPoints(trainNumber string, sessionID string, userID uint, points ...GPSPoint) {
+	if a == nil {
+		return
+	}
+
+	for i := range points {
+		point := points[i]
+		a.add(ArchiveRecord{
+			Type:        archiveRecordPoint,
+			TrainNumber: trainNumber,
+			Timestamp:   point.Timestamp,
+			SessionID:   sessionID,
+			UserID:      userID,
+			Point:       &point,
+		})
+	}
+}
+
+func (a *TrainArchiver) add(record ArchiveRecord) {
+	key := archivePartPrefix(time.UnixMilli(record.Timestamp), record.TrainNumber)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.pending[key] = append(a.pending[key], record)
+	a.pendingCount++
+	if a.pendingCount > archiveMaxPendingRecords {
+		a.dropOldest()
+	}
+}
+
+// dropOldest drops the oldest buffered batch when S3 has been unavailable for too long.
+// Caller must hold the mutex.
+func (a *TrainArchiver) dropOldest() {
+	oldestKey := ""
+	oldestTimestamp := int64(0)
+	for key, records := range a.pending {
+		if oldestKey == "" || records[0].Timestamp < oldestTimestamp {
+			oldestKey = key
+			oldestTimestamp = records[0].Timestamp
+		}
+	}
+	fmt.Printf("WARNING: Train archive buffer full, dropping %d records for %s\n", len(a.pending[oldestKey]), oldestKey)
+	a.pendingCount -= len(a.pending[oldestKey])
+	delete(a.pending, oldestKey)
+}
+
+// Flush writes all buffered records as new archive parts. Records that couldn't be written stay
+// buffered for the next flush.
+func (a *TrainArchiver) Flush() {
+	a.flushMutex.Lock()
+	defer a.flushMutex.Unlock()
+
+	a.mutex.Lock()
+	pending := a.pending
+	a.pending = make(map[string][]ArchiveRecord)
+	a.pendingCount = 0
+	a.mutex.Unlock()
+
+	recordCount := 0
+	for prefix, records := range pending {
+		key, err := a.writePart(prefix, records)
+		if err != nil {
+			fmt.Printf("ERROR: Failed to archive %d records to %s, keeping them for the next flush: %v\n", len(records), prefix, err)
+			a.requeue(prefix, records)
+			continue
+		}
+		fmt.Printf("DEBUG: Archived %d records to %s\n", len(records), key)
+		recordCount += len(records)
+	}
+
+	if recordCount > 0 {
+		fmt.Printf("INFO: Archived %d records to %d train archives\n", recordCount, len(pending))
+	}
+}
+
+// requeue puts records that failed to flush back in front of anything buffered since
+func (a *TrainArchiver) requeue(key string, records []ArchiveRecord) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.pending[key] = append(records, a.pending[key]...)
+	a.pendingCount += len(records)
+	for a.pendingCount > archiveMaxPendingRecords {
+		a.dropOldest()
+	}
+}
+
+// writePart writes the records as a new part object under prefix and returns its key. Parts
+// are created with If-None-Match, so a key another instance wrote in the same millisecond is
+// never overwritten - the next millisecond is tried instead.
+func (a *TrainArchiver) writePart(prefix string, records []ArchiveRecord) (string, error) {
+	var part bytes.Buffer
+	gzipWriter := gzip.NewWriter(&part)
+	encoder := json.NewEncoder(gzipWriter)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return "", fmt.Errorf("failed to encode archive record: %v", err)
+		}
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress archive records: %v", err)
+	}
+
+	partTime := time.Now().UnixMilli()
+	for attempt := 1; ; attempt++ {
+		key := fmt.Sprintf("%s%d.ndjson.gz", prefix, partTime)
+		_, err := a.s3.UploadIfMatch(key, part.Bytes(), "application/gzip", "")
+		if err == nil {
+			return key, nil
+		}
+		if !errors.Is(err, utils.ErrPreconditionFailed) || attempt == archiveMaxWriteAttempts {
+			return "", err
+		}
+		partTime++
+	}
+}
+
+// ReadDay returns every archived record of a train on the given day (schedule timezone), merged
+// from all parts in the order they were archived. Returns utils.ErrNotFound when nothing was
+// archived that day.
+func (a *TrainArchiver) ReadDay(day time.Time, trainNumber string) ([]ArchiveRecord, error) {
+	keys, err := a.s3.ListFiles(archivePartPrefix(day, trainNumber))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, utils.ErrNotFound
+	}
+	sort.Strings(keys) // Same-length millisecond names sort by write time
+
+	var records []ArchiveRecord
+	for _, key := range keys {
+		partRecords, err := a.readPart(key)
+		if errors.Is(err, utils.ErrNotFound) {
+			continue // Pruned since the listing
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, partRecords...)
+	}
+	return records, nil
+}
+
+// readPart decodes one archive part
+func (a *TrainArchiver) readPart(key string) ([]ArchiveRecord, error) {
+	data, _, err := a.s3.GetObjectWithETag(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive part %s: %v", key, err)
+	}
+	defer gzipReader.Close()
+
+	var records []ArchiveRecord
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var record ArchiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // Skip malformed lines
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return records, fmt.Errorf("failed to read archive part %s: %v", key, err)
+	}
+	return records, nil
+}
+
+// pruneExpired deletes archive parts of days older than the retention period
+func (a *TrainArchiver) pruneExpired() {
+	now := time.Now().In(scheduleLocation)
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, scheduleLocation).Add(-a.retention)
+
+	keys, err := a.s3.ListFiles(archiveKeyPrefix)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to list train archives for retention: %v\n", err)
+		return
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		day, ok := archiveObjectDay(key)
+		if !ok || !day.Before(cutoff) {
+			continue
+		}
+		if err := a.s3.DeleteFile(key); err != nil {
+			fmt.Printf("ERROR: Failed to delete expired archive part %s: %v\n", key, err)
+			continue
+		}
+		deleted++
+	}
+
+	if deleted > 0 {
+		fmt.Printf("INFO: Deleted %d train archive parts older than %s\n", deleted, cutoff.Format("2006-01-02"))
+	}
+}
+
+// archivePartPrefix returns the prefix of a train's archive parts on the day of t (schedule timezone)
+func archivePartPrefix(t time.Time, trainNumber string) string {
+	return fmt.Sprintf("%s%s/train-%s/", archiveKeyPrefix, t.In(scheduleLocation).Format("2006/01/02"), trainNumber)
+}
+
+// archiveObjectDay parses the day of an archive part key
+func archiveObjectDay(key string) (time.Time, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, archiveKeyPrefix), "/")
+	if len(parts) != 5 {
+		return time.Time{}, false
+	}
+	day, err := time.ParseInLocation("2006/01/02", strings.Join(parts[:3], "/"), scheduleLocation)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
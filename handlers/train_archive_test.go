@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils"
+	"github.com/modernland/golang-live-tracking/utils/s3test"
+)
+
+func TestTrainArchiverWritesPartsAndMergesThem(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+
+	// Two instances archiving the same train on the same day
+	first := NewTrainArchiver(server.Client(), time.Minute, 0)
+	second := NewTrainArchiver(server.Client(), time.Minute, 0)
+
+	now := time.Now()
+	first.RecordPoints("KA-201", "session-1", 1, GPSPoint{Lat: -6.1, Lng: 106.8, Timestamp: now.UnixMilli()})
+	first.Flush()
+	second.RecordPoints("KA-201", "session-2", 2, GPSPoint{Lat: -6.2, Lng: 106.9, Timestamp: now.UnixMilli()})
+	second.Flush()
+	first.RecordSnapshot(&models.TrainData{TrainID: "KA-201", PassengerCount: 2})
+	first.Flush()
+
+	prefix := archivePartPrefix(now, "KA-201")
+	var parts []string
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			parts = append(parts, key)
+		}
+	}
+	if len(parts) != 3 {
+		t.Fatalf("parts = %v, want one per flush", parts)
+	}
+
+	records, err := first.ReadDay(now, "KA-201")
+	if err != nil {
+		t.Fatalf("ReadDay failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	if records[0].SessionID != "session-1" || records[1].SessionID != "session-2" || records[2].Type != archiveRecordSnapshot {
+		t.Fatalf("records not in archive order: %+v", records)
+	}
+
+	if _, err := first.ReadDay(now, "KA-2"); !errors.Is(err, utils.ErrNotFound) {
+		t.Fatalf("ReadDay of another train = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTrainArchiverPrunesExpiredParts(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	archiver := NewTrainArchiver(server.Client(), time.Minute, 7*24*time.Hour)
+
+	now := time.Now()
+	old := now.AddDate(0, 0, -30)
+	archiver.RecordPoints("KA-202", "session-1", 1, GPSPoint{Lat: -6.1, Lng: 106.8, Timestamp: old.UnixMilli()})
+	archiver.RecordPoints("KA-202", "session-1", 1, GPSPoint{Lat: -6.1, Lng: 106.8, Timestamp: now.UnixMilli()})
+	archiver.Flush()
+
+	archiver.pruneExpired()
+
+	if _, err := archiver.ReadDay(old, "KA-202"); !errors.Is(err, utils.ErrNotFound) {
+		t.Fatalf("ReadDay of expired day = %v, want ErrNotFound", err)
+	}
+	if records, err := archiver.ReadDay(now, "KA-202"); err != nil || len(records) != 1 {
+		t.Fatalf("ReadDay of today = %d records, %v; want 1 record", len(records), err)
+	}
+}
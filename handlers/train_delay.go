@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"math"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Delay estimation parameters
+const (
+	delayAtStationRadiusKm = 0.3 // a train this close to a scheduled stop is treated as standing at it
+	secondsPerDay          = 24 * 60 * 60
+)
+
+// scheduleLocation is the timezone of the timetable's arrival and departure times
+var scheduleLocation = time.Local
+
+// SetScheduleTimezone sets the timezone the timetable's arrival and departure times are in
+func SetScheduleTimezone(name string) error {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	scheduleLocation = location
+	return nil
+}
+
+// routeStop is a scheduled stop with its times as offsets from the train's first scheduled time
+type routeStop struct {
+	stationID     uint
+	stationName   string
+	stopSequence  int
+	isPassThrough bool
+	arrival       *int64 // seconds after the origin's first scheduled time
+	departure     *int64
+	onRoute       bool // placed on the route geometry
+	chainageKm    float64
+}
+
+// earliest returns the first scheduled time at the stop (arrival, or departure at the origin)
+func (s routeStop) earliest() *int64 {
+	if s.arrival != nil {
+		return s.arrival
+	}
+	return s.departure
+}
+
+// latest returns the last scheduled time at the stop (departure, or arrival at the terminus)
+func (s routeStop) latest() *int64 {
+	if s.departure != nil {
+		return s.departure
+	}
+	return s.arrival
+}
+
+// buildRouteStops converts schedule times of day into offsets from the first scheduled time,
+// rolling over midnight for overnight trains. Also returns the first scheduled time in seconds
+// after midnight, or -1 when the schedule has no times.
+func buildRouteStops(schedule []models.ScheduleDetail) ([]routeStop, int64) {
+	stops := make([]routeStop, len(schedule))
+	origin := int64(-1)
+	var previous, dayShift int64
+
+	offsetOf := func(value *string) *int64 {
+		seconds, ok := parseScheduleTime(value)
+		if !ok {
+			return nil
+		}
+		if origin < 0 {
+			origin = seconds
+			previous = seconds
+		}
+		for seconds+dayShift < previous {
+			dayShift += secondsPerDay
+		}
+		previous = seconds + dayShift
+		offset := previous - origin
+		return &offset
+	}
+
+	for i, stop := range schedule {
+		stops[i] = routeStop{
+			stationID:     stop.StationID,
+			stationName:   stop.Station.StationName,
+			stopSequence:  stop.StopSequence,
+			isPassThrough: stop.IsPassThrough,
+		}
+		stops[i].arrival = offsetOf(stop.ArrivalTime)
+		stops[i].departure = offsetOf(stop.DepartureTime)
+	}
+
+	return stops, origin
+}
+
+// parseScheduleTime parses a schedule time of day ("15:04:05" or "15:04") into seconds after midnight
+func parseScheduleTime(value *string) (int64, bool) {
+	if value == nil || *value == "" {
+		return 0, false
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if parsed, err := time.Parse(layout, *value); err == nil {
+			return int64(parsed.Hour()*3600 + parsed.Minute()*60 + parsed.Second()), true
+		}
+	}
+	return 0, false
+}
+
+// scheduledWindowAt returns when the timetable expects the train at a chainage, as offsets from
+// the first scheduled time, and the index of the first stop still ahead. At a station the window
+// spans arrival to departure; between stations the time is interpolated by distance. Before the
+// origin the window is open-ended (waiting to depart is never early).
+func (r *trainRoute) scheduledWindowAt(chainageKm float64) (from, to int64, openStart bool, nextStop int, ok bool) {
+	var timed []int
+	for i, stop := range r.stops {
+		if stop.onRoute && stop.earliest() != nil {
+			timed = append(timed, i)
+		}
+	}
+	if len(timed) == 0 {
+		return 0, 0, false, 0, false
+	}
+
+	for _, i := range timed {
+		stop := r.stops[i]
+		if math.Abs(chainageKm-stop.chainageKm) <= delayAtStationRadiusKm {
+			return *stop.earliest(), *stop.latest(), i == timed[0], i, true
+		}
+	}
+
+	first, last := r.stops[timed[0]], r.stops[timed[len(timed)-1]]
+	if chainageKm < first.chainageKm {
+		return *first.latest(), *first.latest(), true, timed[0], true
+	}
+	if chainageKm > last.chainageKm {
+		return *last.earliest(), *last.earliest(), false, len(r.stops), true
+	}
+
+	for k := 1; k < len(timed); k++ {
+		previous, next := r.stops[timed[k-1]], r.stops[timed[k]]
+		if chainageKm > next.chainageKm || next.chainageKm <= previous.chainageKm {
+			continue
+		}
+		fraction := (chainageKm - previous.chainageKm) / (next.chainageKm - previous.chainageKm)
+		departed, arriving := *previous.latest(), *next.earliest()
+		expected := departed + int64(math.Round(fraction*float64(arriving-departed)))
+		return expected, expected, false, timed[k], true
+	}
+
+	return 0, 0, false, 0, false
+}
+
+// estimateDelay compares a train's matched route position with its timetable and projects
+// the current delay onto the remaining stops. Returns nil without a route match or timetable.
+func (m *routeMatcher) estimateDelay(trainNumber string, match *models.RouteMatch, now time.Time) *models.TrainDelay {
+	if match == nil {
+		return nil
+	}
+	route := m.getRoute(trainNumber)
+	if route == nil || route.originTimeOfDay < 0 {
+		return nil
+	}
+
+	from, to, openStart, nextStop, ok := route.scheduledWindowAt(match.ChainageKm)
+	if !ok {
+		return nil
+	}
+
+	// The timetable has no date - pick the service day (origin departure yesterday, today or
+	// tomorrow) that explains the current position with the smallest delay
+	now = now.In(scheduleLocation)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, scheduleLocation)
+	var serviceStart time.Time
+	var delay time.Duration
+	for day := -1; day <= 1; day++ {
+		start := midnight.AddDate(0, 0, day).Add(time.Duration(route.originTimeOfDay) * time.Second)
+		expectedFrom := start.Add(time.Duration(from) * time.Second)
+		expectedTo := start.Add(time.Duration(to) * time.Second)
+
+		var candidate time.Duration
+		switch {
+		case now.After(expectedTo):
+			candidate = now.Sub(expectedTo)
+		case now.Before(expectedFrom) && !openStart:
+			candidate = now.Sub(expectedFrom)
+		}
+
+		if serviceStart.IsZero() || absDuration(candidate) < absDuration(delay) {
+			serviceStart = start
+			delay = candidate
+		}
+	}
+
+	result := &models.TrainDelay{
+		DelayMinutes:   int(math.Round(delay.Minutes())),
+		ServiceDate:    serviceStart.Format("2006-01-02"),
+		EstimatedAt:    now.Format(time.RFC3339),
+		RemainingStops: []models.StopETA{},
+	}
+
+	// Trains can arrive early, but don't leave before the timetable says so
+	departureDelay := delay
+	if departureDelay < 0 {
+		departureDelay = 0
+	}
+	for _, stop := range route.stops[nextStop:] {
+		eta := models.StopETA{
+			StationID:     stop.stationID,
+			StationName:   stop.stationName,
+			StopSequence:  stop.stopSequence,
+			IsPassThrough: stop.isPassThrough,
+		}
+		if stop.arrival != nil {
+			scheduled := serviceStart.Add(time.Duration(*stop.arrival) * time.Second)
+			eta.ScheduledArrival = formatScheduleTime(scheduled)
+			eta.EstimatedArrival = formatScheduleTime(scheduled.Add(delay))
+		}
+		if stop.departure != nil {
+			scheduled := serviceStart.Add(time.Duration(*stop.departure) * time.Second)
+			eta.ScheduledDeparture = formatScheduleTime(scheduled)
+			eta.EstimatedDeparture = formatScheduleTime(scheduled.Add(departureDelay))
+		}
+		result.RemainingStops = append(result.RemainingStops, eta)
+	}
+
+	return result
+}
+
+func formatScheduleTime(t time.Time) *string {
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
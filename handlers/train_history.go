@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils"
+)
+
+// History query limits
+const (
+	historyDefaultRange      = 24 * time.Hour
+	historyMaxRange          = 7 * 24 * time.Hour
+	historyDefaultResolution = time.Minute
+	historyPassengerWindow   = 2 * time.Minute // passenger points older than this don't show at a frame
+)
+
+// TrainHistoryPoint is a train's archived position at one moment
+type TrainHistoryPoint struct {
+	Timestamp        int64              `json:"timestamp"` // Unix milliseconds
+	Time             string             `json:"time"`
+	Position         models.Position    `json:"position"`
+	PassengerCount   int                `json:"passenger_count"`
+	ConfidenceRadius *float64           `json:"confidence_radius,omitempty"`
+	RouteMatch       *models.RouteMatch `json:"route_match,omitempty"`
+	Passengers       []models.Passenger `json:"passengers,omitempty"` // only filled for replay
+
+	train *models.TrainData
+}
+
+// TrainHistoryQuery selects a train's archived history
+type TrainHistoryQuery struct {
+	TrainNumber string
+	From        time.Time
+	To          time.Time
+	Resolution  time.Duration // at most one point per resolution interval
+}
+
+// parseTrainHistoryQuery parses from/to (RFC3339 or Unix milliseconds) and resolution (Go duration
+// or seconds). to defaults to now, from to a day before to.
+func parseTrainHistoryQuery(trainNumber, fromParam, toParam, resolutionParam string) (TrainHistoryQuery, error) {
+	query := TrainHistoryQuery{TrainNumber: trainNumber, To: time.Now(), Resolution: historyDefaultResolution}
+	if trainNumber == "" {
+		return query, fmt.Errorf("train number is required")
+	}
+
+	if toParam != "" {
+		to, err := parseHistoryTime(toParam)
+		if err != nil {
+			return query, fmt.Errorf("invalid to: %v", err)
+		}
+		query.To = to
+	}
+	query.From = query.To.Add(-historyDefaultRange)
+	if fromParam != "" {
+		from, err := parseHistoryTime(fromParam)
+		if err != nil {
+			return query, fmt.Errorf("invalid from: %v", err)
+		}
+		query.From = from
+	}
+	if !query.From.Before(query.To) {
+		return query, fmt.Errorf("from must be before to")
+	}
+	if query.To.Sub(query.From) > historyMaxRange {
+		return query, fmt.Errorf("range must not exceed %s", historyMaxRange)
+	}
+
+	if resolutionParam != "" {
+		resolution, err := time.ParseDuration(resolutionParam)
+		if err != nil {
+			seconds, convErr := strconv.Atoi(resolutionParam)
+			if convErr != nil {
+				return query, fmt.Errorf("invalid resolution: %s", resolutionParam)
+			}
+			resolution = time.Duration(seconds) * time.Second
+		}
+		if resolution < time.Second {
+			return query, fmt.Errorf("resolution must be at least 1s")
+		}
+		query.Resolution = resolution
+	}
+
+	return query, nil
+}
+
+func parseHistoryTime(value string) (time.Time, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// loadTrainHistory reads a train's archived snapshots in the query range, time-ordered and
+// downsampled to the query resolution. With passengers, each point also carries the latest
+// archived point of every passenger session at that moment.
+func loadTrainHistory(archive *TrainArchiver, query TrainHistoryQuery, passengers bool) ([]TrainHistoryPoint, error) {
+	if archive == nil {
+		return nil, fmt.Errorf("train archive is disabled")
+	}
+
+	fromMillis := query.From.UnixMilli()
+	toMillis := query.To.UnixMilli()
+
+	var snapshots, points []ArchiveRecord
+	from := query.From.In(scheduleLocation)
+	firstDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, scheduleLocation)
+	for day := firstDay; day.Before(query.To); day = day.AddDate(0, 0, 1) {
+		records, err := archive.ReadDay(day, query.TrainNumber)
+		if errors.Is(err, utils.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive of %s: %v", day.Format("2006-01-02"), err)
+		}
+
+		for _, record := range records {
+			if record.Timestamp < fromMillis || record.Timestamp > toMillis {
+				continue
+			}
+			switch {
+			case record.Type == archiveRecordSnapshot && record.Train != nil:
+				snapshots = append(snapshots, record)
+			case record.Type == archiveRecordPoint && record.Point != nil && passengers:
+				points = append(points, record)
+			}
+		}
+	}
+
+	// Several instances append to the same archive, so records are only roughly in order
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Timestamp < snapshots[j].Timestamp })
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+
+	// Keep the last snapshot of each resolution interval
+	resolutionMillis := query.Resolution.Milliseconds()
+	var history []TrainHistoryPoint
+	for _, snapshot := range snapshots {
+		point := TrainHistoryPoint{
+			Timestamp:        snapshot.Timestamp,
+			Time:             time.UnixMilli(snapshot.Timestamp).In(scheduleLocation).Format(time.RFC3339),
+			Position:         snapshot.Train.AveragePosition,
+			PassengerCount:   snapshot.Train.PassengerCount,
+			ConfidenceRadius: snapshot.Train.ConfidenceRadius,
+			RouteMatch:       snapshot.Train.RouteMatch,
+			train:            snapshot.Train,
+		}
+		if n := len(history); n > 0 && history[n-1].Timestamp/resolutionMillis == snapshot.Timestamp/resolutionMillis {
+			history[n-1] = point
+			continue
+		}
+		history = append(history, point)
+	}
+
+	if passengers {
+		attachHistoryPassengers(history, points)
+	}
+
+	return history, nil
+}
+
+// attachHistoryPassengers fills each history point with the latest recent point of every
+// passenger session. Both slices must be time-ordered.
+func attachHistoryPassengers(history []TrainHistoryPoint, points []ArchiveRecord) {
+	windowMillis := historyPassengerWindow.Milliseconds()
+	latest := make(map[string]ArchiveRecord) // by session
+	next := 0
+	for i := range history {
+		for next < len(points) && points[next].Timestamp <= history[i].Timestamp {
+			latest[points[next].SessionID] = points[next]
+			next++
+		}
+
+		for _, record := range latest {
+			if history[i].Timestamp-record.Timestamp > windowMillis {
+				continue
+			}
+			history[i].Passengers = append(history[i].Passengers, models.Passenger{
+				UserID:        record.UserID,
+				UserType:      "authenticated",
+				ClientType:    "mobile",
+				Lat:           record.Point.Lat,
+				Lng:           record.Point.Lng,
+				Timestamp:     record.Point.Timestamp,
+				SessionID:     record.SessionID,
+				SessionStatus: "active",
+				Accuracy:      record.Point.Accuracy,
+				Speed:         record.Point.Speed,
+				Heading:       record.Point.Heading,
+				Altitude:      record.Point.Altitude,
+			})
+		}
+		sort.Slice(history[i].Passengers, func(a, b int) bool {
+			return history[i].Passengers[a].UserID < history[i].Passengers[b].UserID
+		})
+	}
+}
+
+// GetTrainHistory - Authenticated API endpoint returning a train's archived positions and passenger counts
+func (h *SimpleLiveTrackingHandler) GetTrainHistory(c *gin.Context) {
+	query, err := parseTrainHistoryQuery(c.Param("trainNumber"), c.Query("from"), c.Query("to"), c.Query("resolution"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if h.archive == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "Train history is not available (archive disabled)",
+		})
+		return
+	}
+
+	history, err := loadTrainHistory(h.archive, query, false)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to load history for train %s: %v\n", query.TrainNumber, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load train history",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if history == nil {
+		history = []TrainHistoryPoint{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":            true,
+		"train_number":       query.TrainNumber,
+		"from":               query.From.In(scheduleLocation).Format(time.RFC3339),
+		"to":                 query.To.In(scheduleLocation).Format(time.RFC3339),
+		"resolution_seconds": int(query.Resolution.Seconds()),
+		"count":              len(history),
+		"positions":          history,
+	})
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Distributed train lock parameters
+const (
+	trainLockLease         = 15 * time.Second      // lock expires if the holder dies without unlocking
+	trainLockRenewInterval = trainLockLease / 3    // holder extends the lease while still working
+	trainLockRetryInterval = 25 * time.Millisecond // poll interval while another instance holds the lock
+	trainLockAcquireWait   = 2 * trainLockLease    // give up waiting and fail the request after this
+	trainFenceKeyTTL       = 24 * time.Hour        // fencing counters of idle trains eventually expire
+)
+
+// ErrTrainLockUnavailable is returned by Lock when the Redis lock can't be acquired. Callers
+// must not touch the train then - without the lock other instances may be writing it.
+var ErrTrainLockUnavailable = errors.New("train lock unavailable")
+
+// Release the lock only if we still own it
+var trainLockReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Extend the lease only if we still own it
+var trainLockRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// trainMutex serializes read-modify-write of one train's S3 file and Redis aggregate.
+// With Redis it also holds a leased lock in Redis so other instances are excluded too, and
+// hands out a fencing token that rejects writes from a holder whose lease already expired.
+// Without Redis it is a plain in-process mutex.
+type trainMutex struct {
+	trainNumber string
+	redis       *redis.Client
+	available   func() bool // Redis is skipped while this reports false (nil = always use Redis)
+	local       sync.Mutex  // one goroutine per instance competes for the Redis lock
+
+	owner string        // random value identifying the current Redis lock holder
+	fence atomic.Int64  // fencing token while held through Redis, 0 otherwise
+	held  atomic.Bool   // between a successful Lock and Unlock
+	lost  atomic.Bool   // lease expired or was taken over while held
+	stop  chan struct{} // stops the lease renewal
+}
+
+func newTrainMutex(trainNumber string, redisClient *redis.Client) *trainMutex {
+	return &trainMutex{
+		trainNumber: trainNumber,
+		redis:       redisClient,
+	}
+}
+
+func (m *trainMutex) lockKey() string {
+	return fmt.Sprintf("train_lock:%s", m.trainNumber)
+}
+
+func (m *trainMutex) fenceKey() string {
+	return fmt.Sprintf("train_lock_fence:%s", m.trainNumber)
+}
+
+// Lock acquires the train lock, waiting for other goroutines and instances. Returns
+// ErrTrainLockUnavailable when Redis fails or the lock isn't released within trainLockAcquireWait.
+func (m *trainMutex) Lock() error {
+	m.local.Lock()
+	m.lost.Store(false)
+	if m.redis == nil || (m.available != nil && !m.available()) {
+		m.held.Store(true)
+		return nil
+	}
+
+	ctx := context.Background()
+	owner := uuid.New().String()
+	deadline := time.Now().Add(trainLockAcquireWait)
+	for {
+		acquired, err := m.redis.SetNX(ctx, m.lockKey(), owner, trainLockLease).Result()
+		if err != nil {
+			m.local.Unlock()
+			return fmt.Errorf("%w: train %s: %v", ErrTrainLockUnavailable, m.trainNumber, err)
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			m.local.Unlock()
+			return fmt.Errorf("%w: timed out waiting for train %s", ErrTrainLockUnavailable, m.trainNumber)
+		}
+		time.Sleep(trainLockRetryInterval)
+	}
+
+	pipe := m.redis.TxPipeline()
+	fence := pipe.Incr(ctx, m.fenceKey())
+	pipe.Expire(ctx, m.fenceKey(), trainFenceKeyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		trainLockReleaseScript.Run(ctx, m.redis, []string{m.lockKey()}, owner)
+		m.local.Unlock()
+		return fmt.Errorf("%w: no fencing token for train %s: %v", ErrTrainLockUnavailable, m.trainNumber, err)
+	}
+
+	m.owner = owner
+	m.fence.Store(fence.Val())
+	m.held.Store(true)
+	m.stop = make(chan struct{})
+	go m.renew(owner, m.stop)
+	return nil
+}
+
+// Unlock releases the train lock
+func (m *trainMutex) Unlock() {
+	if m.owner != "" {
+		close(m.stop)
+		if err := trainLockReleaseScript.Run(context.Background(), m.redis, []string{m.lockKey()}, m.owner).Err(); err != nil {
+			fmt.Printf("WARNING: Failed to release Redis train lock for %s: %v\n", m.trainNumber, err)
+		}
+		m.owner = ""
+		m.fence.Store(0)
+	}
+	m.held.Store(false)
+	m.local.Unlock()
+}
+
+// Token returns the fencing token of the current Redis lock holder (0 when not held through Redis)
+func (m *trainMutex) Token() int64 {
+	return m.fence.Load()
+}
+
+// Held reports whether the lock is locked and still ours. False once the lease was lost, in
+// which case another instance may be modifying the train and writes should be abandoned.
+func (m *trainMutex) Held() bool {
+	return m.held.Load() && !m.lost.Load()
+}
+
+// renew extends the lease while the lock is held
+func (m *trainMutex) renew(owner string, stop chan struct{}) {
+	ticker := time.NewTicker(trainLockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			renewed, err := trainLockRenewScript.Run(context.Background(), m.redis,
+				[]string{m.lockKey()}, owner, trainLockLease.Milliseconds()).Int()
+			if err != nil {
+				// Transient error - the lease may still be valid, try again next tick
+				fmt.Printf("WARNING: Failed to renew Redis train lock for %s: %v\n", m.trainNumber, err)
+				continue
+			}
+			if renewed == 0 {
+				fmt.Printf("WARNING: Lost Redis train lock for %s (lease expired)\n", m.trainNumber)
+				m.lost.Store(true)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/modernland/golang-live-tracking/models"
+	"github.com/modernland/golang-live-tracking/utils/s3test"
+)
+
+// newTestInstance returns a handler as another server instance would run it: its own Redis
+// client and its own per-train locks, sharing only the Redis server
+func newTestInstance(t *testing.T, server *miniredis.Miniredis) *SimpleLiveTrackingHandler {
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &SimpleLiveTrackingHandler{
+		store:        NewRedisLiveStore(client),
+		trainMutexes: make(map[string]TrainLock),
+	}
+}
+
+func TestTrainLockAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	instances := []*SimpleLiveTrackingHandler{newTestInstance(t, server), newTestInstance(t, server)}
+
+	const trainNumber = "KA-101"
+	const updatesPerWorker = 20
+	const workersPerInstance = 3
+
+	// Every update is a read-modify-write of the train aggregate, like a passenger joining
+	var wg sync.WaitGroup
+	errs := make(chan error, len(instances)*workersPerInstance*updatesPerWorker)
+	for _, h := range instances {
+		for w := 0; w < workersPerInstance; w++ {
+			wg.Add(1)
+			go func(h *SimpleLiveTrackingHandler) {
+				defer wg.Done()
+				for i := 0; i < updatesPerWorker; i++ {
+					lock := h.getTrainMutex(trainNumber)
+					if err := lock.Lock(); err != nil {
+						errs <- err
+						return
+					}
+
+					trainData, err := h.store.GetTrain(trainNumber)
+					if errors.Is(err, ErrLiveStoreNotFound) {
+						trainData, err = &models.TrainData{TrainID: trainNumber}, nil
+					}
+					if err == nil {
+						trainData.PassengerCount++
+						err = h.store.PutTrain(trainNumber, trainData, lock.Token())
+					}
+					lock.Unlock()
+
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(h)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	trainData, err := instances[0].store.GetTrain(trainNumber)
+	if err != nil {
+		t.Fatalf("failed to read train: %v", err)
+	}
+	if want := len(instances) * workersPerInstance * updatesPerWorker; trainData.PassengerCount != want {
+		t.Fatalf("passenger count = %d, want %d (lost updates)", trainData.PassengerCount, want)
+	}
+}
+
+func TestTrainLockStaleFenceRejected(t *testing.T) {
+	server := miniredis.RunT(t)
+	first, second := newTestInstance(t, server), newTestInstance(t, server)
+	const trainNumber = "KA-102"
+
+	lock := first.getTrainMutex(trainNumber)
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	staleFence := lock.Token()
+	lock.Unlock()
+
+	// A newer holder writes, then the old holder's delayed write must be rejected
+	newer := second.getTrainMutex(trainNumber)
+	if err := newer.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.store.PutTrain(trainNumber, &models.TrainData{TrainID: trainNumber, PassengerCount: 2}, newer.Token()); err != nil {
+		t.Fatalf("write with current fence failed: %v", err)
+	}
+	newer.Unlock()
+
+	if err := first.store.PutTrain(trainNumber, &models.TrainData{TrainID: trainNumber, PassengerCount: 1}, staleFence); err == nil {
+		t.Fatalf("write with stale fence %d was accepted", staleFence)
+	}
+	trainData, err := first.store.GetTrain(trainNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trainData.PassengerCount != 2 {
+		t.Fatalf("passenger count = %d, want 2", trainData.PassengerCount)
+	}
+}
+
+func TestTrainLockFailsWithoutRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	h := newTestInstance(t, server)
+	server.Close()
+
+	lock := h.getTrainMutex("KA-103")
+	if err := lock.Lock(); !errors.Is(err, ErrTrainLockUnavailable) {
+		t.Fatalf("Lock() = %v, want ErrTrainLockUnavailable", err)
+	}
+
+	// A failed Lock leaves nothing held - the next attempt doesn't deadlock
+	server.Restart()
+	if err := lock.Lock(); err != nil {
+		t.Fatalf("Lock() after Redis came back = %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestModifyTrainFileFencedByTrainLock(t *testing.T) {
+	server := miniredis.RunT(t)
+	bucket := s3test.NewServer()
+	defer bucket.Close()
+
+	first, second := newTestInstance(t, server), newTestInstance(t, server)
+	first.trainFiles = &s3LiveStore{s3: bucket.Client()}
+	second.trainFiles = &s3LiveStore{s3: bucket.Client()}
+	const trainNumber = "KA-104"
+
+	addPassenger := func(trainData *models.TrainData) (*models.TrainData, error) {
+		if trainData == nil {
+			trainData = &models.TrainData{TrainID: trainNumber}
+		}
+		trainData.PassengerCount++
+		return trainData, nil
+	}
+
+	var lastFence int64
+	for _, h := range []*SimpleLiveTrackingHandler{first, second} {
+		lock := h.getTrainMutex(trainNumber)
+		if err := lock.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		lastFence = lock.Token()
+		if err := h.modifyTrainFile(trainNumber, lock, addPassenger); err != nil {
+			t.Fatalf("modifyTrainFile failed: %v", err)
+		}
+		lock.Unlock()
+	}
+
+	raw, _ := bucket.Object(s3TrainFileName(trainNumber))
+	var stored s3TrainFile
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.PassengerCount != 2 || stored.Fence != lastFence {
+		t.Fatalf("stored passengers %d with fence %d, want 2 with the last holder's token %d",
+			stored.PassengerCount, stored.Fence, lastFence)
+	}
+}
+
+func TestUpdateLiveTrainDataRequiresHeldLock(t *testing.T) {
+	server := miniredis.RunT(t)
+	h := newTestInstance(t, server)
+	const trainNumber = "KA-105"
+
+	// A lock handle that isn't locked must not pass as token 0
+	if err := h.updateLiveTrainData(trainNumber, h.getTrainMutex(trainNumber)); err == nil {
+		t.Fatalf("updateLiveTrainData without the train lock succeeded")
+	}
+	if err := h.updateLiveTrainData(trainNumber, nil); err == nil {
+		t.Fatalf("updateLiveTrainData without a lock succeeded")
+	}
+}
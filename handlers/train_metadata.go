@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// getTrain gets a train from the trains table, using cache for efficiency
+func (h *SimpleLiveTrackingHandler) getTrain(trainID uint) *models.Train {
+	h.trainCacheMutex.RLock()
+	if cached, exists := h.trainCache[trainID]; exists {
+		h.trainCacheMutex.RUnlock()
+		return cached
+	}
+	h.trainCacheMutex.RUnlock()
+
+	train, err := h.loadTrain(trainID)
+	if err != nil {
+		return nil
+	}
+	return train
+}
+
+// loadTrain reads a train from the trains table, bypassing and refreshing the cache
+func (h *SimpleLiveTrackingHandler) loadTrain(trainID uint) (*models.Train, error) {
+	var train models.Train
+	if err := h.db.First(&train, trainID).Error; err != nil {
+		return nil, err
+	}
+
+	h.trainCacheMutex.Lock()
+	h.trainCache[trainID] = &train
+	h.trainCacheMutex.Unlock()
+
+	return &train, nil
+}
+
+// applyTrainMetadata fills a train's live payload with its trains table row and operational route
+func applyTrainMetadata(trainData *models.TrainData, train *models.Train, routes *routeMatcher) {
+	if train == nil {
+		return
+	}
+
+	trainData.TrainName = train.TrainName
+	trainData.Relation = train.Relation
+	trainData.TrainType = train.TrainType
+	trainData.OperationalRoute = routes.operationalRoute(train.TrainNumber)
+
+	switch {
+	case trainData.OperationalRoute != nil:
+		trainData.Route = trainData.OperationalRoute.Name
+	case train.Relation != nil && *train.Relation != "":
+		trainData.Route = *train.Relation
+	default:
+		trainData.Route = fmt.Sprintf("%s %s", train.TrainName, train.TrainNumber)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Train position aggregation parameters
+const (
+	positionDefaultAccuracyM  = 50.0  // assumed accuracy when a passenger doesn't report one
+	positionMinAccuracyM      = 5.0   // floor so a single over-confident fix can't dominate the weights
+	positionOutlierMADs       = 3.0   // passengers further than median + 3 MADs from the median position are outliers
+	positionMinOutlierRadiusM = 300.0 // never treat passengers this close to the median as outliers (train length)
+	positionMinOutlierSamples = 3     // with fewer passengers there's no majority to compare against
+)
+
+// TrainPositionEstimate is the aggregated position of a train from its passengers
+type TrainPositionEstimate struct {
+	Position          models.Position
+	ConfidenceRadiusM float64 // meters, covers passenger spread and fix accuracy
+	UsedCount         int     // passengers that contributed to the position
+	OutlierCount      int     // passengers dropped as outliers
+}
+
+// aggregateTrainPosition estimates a train's position from its active passengers.
+// Low-quality fixes are only used when nothing else is available, passengers far from
+// the median position (still on the platform, drifting fix) are dropped, and the rest
+// are averaged weighted by 1/accuracy². Returns nil when there are no active passengers.
+func aggregateTrainPosition(passengers []models.Passenger) *TrainPositionEstimate {
+	var candidates, flagged []models.Passenger
+	for _, passenger := range passengers {
+		if passenger.SessionStatus != "active" {
+			continue
+		}
+		if passenger.QualityFlag != "" {
+			flagged = append(flagged, passenger)
+			continue
+		}
+		candidates = append(candidates, passenger)
+	}
+	// Only low-quality fixes - better a rough position than none
+	if len(candidates) == 0 {
+		candidates = flagged
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Drop outliers by distance from the component-wise median
+	kept := candidates
+	if len(candidates) >= positionMinOutlierSamples {
+		lats := make([]float64, len(candidates))
+		lngs := make([]float64, len(candidates))
+		for i, passenger := range candidates {
+			lats[i] = passenger.Lat
+			lngs[i] = passenger.Lng
+		}
+		medianLat, medianLng := medianOf(lats), medianOf(lngs)
+
+		distances := make([]float64, len(candidates))
+		for i, passenger := range candidates {
+			distances[i] = calculateDistance(medianLat, medianLng, passenger.Lat, passenger.Lng) * 1000
+		}
+		medianDistance := medianOf(distances)
+		deviations := make([]float64, len(distances))
+		for i, distance := range distances {
+			deviations[i] = math.Abs(distance - medianDistance)
+		}
+		// 1.4826 scales the MAD to a standard deviation for normally distributed errors
+		threshold := medianDistance + positionOutlierMADs*1.4826*medianOf(deviations)
+		if threshold < positionMinOutlierRadiusM {
+			threshold = positionMinOutlierRadiusM
+		}
+
+		kept = make([]models.Passenger, 0, len(candidates))
+		for i, passenger := range candidates {
+			if distances[i] <= threshold {
+				kept = append(kept, passenger)
+			}
+		}
+	}
+
+	// Accuracy-weighted mean
+	weights := make([]float64, len(kept))
+	var totalWeight, weightedLat, weightedLng float64
+	for i, passenger := range kept {
+		accuracy := positionDefaultAccuracyM
+		if passenger.Accuracy != nil && *passenger.Accuracy > 0 {
+			accuracy = *passenger.Accuracy
+		}
+		if accuracy < positionMinAccuracyM {
+			accuracy = positionMinAccuracyM
+		}
+		weights[i] = 1 / (accuracy * accuracy)
+		totalWeight += weights[i]
+		weightedLat += passenger.Lat * weights[i]
+		weightedLng += passenger.Lng * weights[i]
+	}
+	position := models.Position{
+		Lat: weightedLat / totalWeight,
+		Lng: weightedLng / totalWeight,
+	}
+
+	// Confidence radius: weighted spread of the passengers around the position
+	// combined with the uncertainty of the weighted mean itself
+	var weightedSpread float64
+	for i, passenger := range kept {
+		distance := calculateDistance(position.Lat, position.Lng, passenger.Lat, passenger.Lng) * 1000
+		weightedSpread += weights[i] * distance * distance
+	}
+	radius := math.Sqrt(weightedSpread/totalWeight + 1/totalWeight)
+
+	return &TrainPositionEstimate{
+		Position:          position,
+		ConfidenceRadiusM: math.Round(radius*10) / 10,
+		UsedCount:         len(kept),
+		OutlierCount:      len(candidates) - len(kept),
+	}
+}
+
+// medianOf returns the median of values without modifying the slice
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
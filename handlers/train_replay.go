@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Replay playback parameters
+const (
+	replayDefaultSpeed      = 10.0 // archive seconds per real second
+	replayMinSpeed          = 0.1
+	replayMaxSpeed          = 1000.0
+	replayDefaultResolution = 10 * time.Second
+	replayMaxFrameDelay     = 5 * time.Second  // longer gaps in the archive are shortened
+	replayMaxRange          = 12 * time.Hour   // about one train run; HTTP history allows historyMaxRange
+	replayMinInterval       = 10 * time.Second // between replay starts on one connection
+)
+
+// replayRequest is the data of a client's {"type":"replay"} message
+type replayRequest struct {
+	TrainNumber string  `json:"trainNumber"`
+	From        string  `json:"from"`       // RFC3339 or Unix milliseconds
+	To          string  `json:"to"`         // RFC3339 or Unix milliseconds
+	Resolution  string  `json:"resolution"` // e.g. "10s", default 10s
+	Speed       float64 `json:"speed"`      // playback speed, default 10x
+}
+
+// SetArchiver enables replaying archived train history to WebSocket clients
+func (h *WebSocketHandler) SetArchiver(archive *TrainArchiver) {
+	h.archive = archive
+	fmt.Printf("INFO: Train archive enabled for WebSocket handler (replay)\n")
+}
+
+// writeToClient sends a message to one client, serialized with broadcasts to the same connection
+func (h *WebSocketHandler) writeToClient(conn *websocket.Conn, message WebSocketMessage) error {
+	h.broadcastMutex.Lock()
+	defer h.broadcastMutex.Unlock()
+	return conn.WriteJSON(message)
+}
+
+func (h *WebSocketHandler) sendReplayError(conn *websocket.Conn, message string) {
+	h.writeToClient(conn, WebSocketMessage{
+		Type: "replay_error",
+		Data: map[string]interface{}{"message": message},
+	})
+}
+
+// startReplay plays a train's archived history back to one client as train_updates. Live
+// updates to that client pause until the replay finishes or the client sends replay_stop.
+// Only admins may replay, one replay at a time per connection.
+func (h *WebSocketHandler) startReplay(conn *websocket.Conn, data interface{}, isAdmin bool) {
+	if !isAdmin {
+		h.sendReplayError(conn, "Replay requires an admin token (connect with ?token=)")
+		return
+	}
+	if h.archive == nil {
+		h.sendReplayError(conn, "Train history is not available (archive disabled)")
+		return
+	}
+
+	var req replayRequest
+	raw, _ := json.Marshal(data)
+	if err := json.Unmarshal(raw, &req); err != nil {
+		h.sendReplayError(conn, "Invalid replay request")
+		return
+	}
+
+	query, err := parseTrainHistoryQuery(req.TrainNumber, req.From, req.To, req.Resolution)
+	if err != nil {
+		h.sendReplayError(conn, err.Error())
+		return
+	}
+	if req.Resolution == "" {
+		query.Resolution = replayDefaultResolution
+	}
+	if query.To.Sub(query.From) > replayMaxRange {
+		h.sendReplayError(conn, fmt.Sprintf("replay range must not exceed %s", replayMaxRange))
+		return
+	}
+
+	speed := req.Speed
+	if speed == 0 {
+		speed = replayDefaultSpeed
+	}
+	if speed < replayMinSpeed || speed > replayMaxSpeed {
+		h.sendReplayError(conn, fmt.Sprintf("speed must be between %g and %g", replayMinSpeed, replayMaxSpeed))
+		return
+	}
+
+	// Every replay reads the archive from S3 - limit how often a client starts one
+	ctx, cancel := context.WithCancel(context.Background())
+	h.replayMutex.Lock()
+	if _, replaying := h.replays[conn]; replaying {
+		h.replayMutex.Unlock()
+		cancel()
+		h.sendReplayError(conn, "A replay is already running, send replay_stop first")
+		return
+	}
+	if wait := replayMinInterval - time.Since(h.replayStarted[conn]); wait > 0 {
+		h.replayMutex.Unlock()
+		cancel()
+		h.sendReplayError(conn, fmt.Sprintf("Too many replays, retry in %s", wait.Round(time.Second)))
+		return
+	}
+	h.replays[conn] = cancel
+	h.replayStarted[conn] = time.Now()
+	h.replayMutex.Unlock()
+
+	// Pause live updates
+	h.mutex.Lock()
+	delete(h.clients, conn)
+	h.mutex.Unlock()
+
+	log.Printf("WebSocket: Replaying train %s from %s to %s at %gx", query.TrainNumber,
+		query.From.Format(time.RFC3339), query.To.Format(time.RFC3339), speed)
+
+	go h.runReplay(ctx, conn, query, speed)
+}
+
+// runReplay sends the replay frames in archive time, scaled by speed
+func (h *WebSocketHandler) runReplay(ctx context.Context, conn *websocket.Conn, query TrainHistoryQuery, speed float64) {
+	defer h.finishReplay(ctx, conn)
+
+	history, err := loadTrainHistory(h.archive, query, true)
+	if err != nil {
+		log.Printf("WebSocket: Failed to load replay of train %s: %v", query.TrainNumber, err)
+		h.sendReplayError(conn, "Failed to load train history")
+		return
+	}
+
+	if err := h.writeToClient(conn, WebSocketMessage{
+		Type: "replay_started",
+		Data: map[string]interface{}{
+			"trainNumber": query.TrainNumber,
+			"from":        query.From.In(scheduleLocation).Format(time.RFC3339),
+			"to":          query.To.In(scheduleLocation).Format(time.RFC3339),
+			"speed":       speed,
+			"frames":      len(history),
+		},
+	}); err != nil {
+		return
+	}
+
+	for i, point := range history {
+		if i > 0 {
+			delay := time.Duration(float64(time.Duration(point.Timestamp-history[i-1].Timestamp)*time.Millisecond) / speed)
+			if delay > replayMaxFrameDelay {
+				delay = replayMaxFrameDelay
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		if err := h.writeToClient(conn, WebSocketMessage{
+			Type: "train_updates",
+			Data: []TrainUpdate{h.buildReplayUpdate(query.TrainNumber, point)},
+		}); err != nil {
+			return
+		}
+	}
+
+	h.writeToClient(conn, WebSocketMessage{
+		Type: "replay_finished",
+		Data: map[string]interface{}{"trainNumber": query.TrainNumber, "frames": len(history)},
+	})
+}
+
+// buildReplayUpdate turns an archived history point into the update sent to WebSocket clients.
+// Passengers are anonymous: positions only, no user or session IDs and no names.
+func (h *WebSocketHandler) buildReplayUpdate(trainNumber string, point TrainHistoryPoint) TrainUpdate {
+	passengers := make([]models.Passenger, 0, len(point.Passengers))
+	for _, passenger := range point.Passengers {
+		passenger.UserID = 0
+		passenger.SessionID = ""
+		passengers = append(passengers, passenger)
+	}
+
+	return TrainUpdate{
+		TrainNumber:      trainNumber,
+		TrainName:        point.train.TrainName,
+		Relation:         point.train.Relation,
+		TrainType:        point.train.TrainType,
+		OperationalRoute: point.train.OperationalRoute,
+		PassengerCount:   point.PassengerCount,
+		AveragePosition:  point.Position,
+		ConfidenceRadius: point.ConfidenceRadius,
+		RouteMatch:       point.RouteMatch,
+		Delay:            h.routes.estimateDelay(trainNumber, point.RouteMatch, time.UnixMilli(point.Timestamp)),
+		Passengers:       passengers,
+		LastUpdate:       point.Time,
+		Status:           "replay",
+		Route:            point.train.Route,
+		DataSource:       "archive-replay",
+	}
+}
+
+// finishReplay resumes live updates once a replay ended on its own
+func (h *WebSocketHandler) finishReplay(ctx context.Context, conn *websocket.Conn) {
+	h.replayMutex.Lock()
+	defer h.replayMutex.Unlock()
+
+	if ctx.Err() != nil {
+		return // Stopped, replaced or disconnected - whoever cancelled it took care of the client
+	}
+	h.replays[conn]()
+	delete(h.replays, conn)
+
+	h.mutex.Lock()
+	h.clients[conn] = true
+	h.mutex.Unlock()
+}
+
+// forgetReplayClient drops a disconnected client's replay state
+func (h *WebSocketHandler) forgetReplayClient(conn *websocket.Conn) {
+	h.stopReplay(conn, false)
+
+	h.replayMutex.Lock()
+	delete(h.replayStarted, conn)
+	h.replayMutex.Unlock()
+}
+
+// stopReplay cancels the connection's replay, if any, and optionally resumes live updates
+func (h *WebSocketHandler) stopReplay(conn *websocket.Conn, resumeLive bool) {
+	h.replayMutex.Lock()
+	defer h.replayMutex.Unlock()
+
+	cancel, replaying := h.replays[conn]
+	if !replaying {
+		return
+	}
+	cancel()
+	delete(h.replays, conn)
+
+	if resumeLive {
+		h.mutex.Lock()
+		h.clients[conn] = true
+		h.mutex.Unlock()
+	}
+}
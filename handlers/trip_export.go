@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/modernland/golang-live-tracking/middleware"
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+const tripExportCreator = "168Railway Live Tracking"
+
+// tripExportFormat describes one downloadable trip format
+type tripExportFormat struct {
+	extension   string
+	contentType string
+	render      func(trip *models.Trip, path []GPSPoint) ([]byte, error)
+}
+
+var tripExportFormats = map[string]tripExportFormat{
+	"gpx":     {extension: "gpx", contentType: "application/gpx+xml", render: renderTripGPX},
+	"kml":     {extension: "kml", contentType: "application/vnd.google-earth.kml+xml", render: renderTripKML},
+	"geojson": {extension: "geojson", contentType: "application/geo+json", render: renderTripGeoJSON},
+}
+
+// tripWaypoint is a notable location of a trip, exported next to the track
+type tripWaypoint struct {
+	Kind        string // start, end, max_speed, max_elevation
+	Name        string
+	Description string
+	Lat         float64
+	Lng         float64
+	Elevation   *float64
+	Time        *time.Time
+}
+
+// tripWaypoints returns the trip's start, end, max-speed and max-elevation locations
+func tripWaypoints(trip *models.Trip) []tripWaypoint {
+	var waypoints []tripWaypoint
+
+	if trip.StartLatitude != 0 || trip.StartLongitude != 0 {
+		startedAt := trip.StartedAt
+		name := "Start"
+		if trip.FromStationName != nil && *trip.FromStationName != "" {
+			name = "Start: " + *trip.FromStationName
+		}
+		waypoints = append(waypoints, tripWaypoint{Kind: "start", Name: name, Lat: trip.StartLatitude, Lng: trip.StartLongitude, Time: &startedAt})
+	}
+	if trip.EndLatitude != 0 || trip.EndLongitude != 0 {
+		completedAt := trip.CompletedAt
+		name := "End"
+		if trip.ToStationName != nil && *trip.ToStationName != "" {
+			name = "End: " + *trip.ToStationName
+		}
+		waypoints = append(waypoints, tripWaypoint{Kind: "end", Name: name, Lat: trip.EndLatitude, Lng: trip.EndLongitude, Time: &completedAt})
+	}
+	if trip.MaxSpeedLat != nil && trip.MaxSpeedLng != nil {
+		waypoints = append(waypoints, tripWaypoint{
+			Kind:        "max_speed",
+			Name:        "Max speed",
+			Description: fmt.Sprintf("%.1f km/h", trip.MaxSpeedKmh),
+			Lat:         *trip.MaxSpeedLat,
+			Lng:         *trip.MaxSpeedLng,
+		})
+	}
+	if trip.MaxElevationLat != nil && trip.MaxElevationLng != nil {
+		elevation := float64(trip.MaxElevationM)
+		waypoints = append(waypoints, tripWaypoint{
+			Kind:        "max_elevation",
+			Name:        "Max elevation",
+			Description: fmt.Sprintf("%d m", trip.MaxElevationM),
+			Lat:         *trip.MaxElevationLat,
+			Lng:         *trip.MaxElevationLng,
+			Elevation:   &elevation,
+		})
+	}
+
+	return waypoints
+}
+
+// tripExportName returns the display name of an exported trip
+func tripExportName(trip *models.Trip) string {
+	name := fmt.Sprintf("%s (%s)", trip.TrainName, trip.TrainNumber)
+	if trip.TrainRelation != nil && *trip.TrainRelation != "" {
+		name += " " + *trip.TrainRelation
+	}
+	return name + " - " + trip.StartedAt.In(scheduleLocation).Format("2006-01-02")
+}
+
+// pointTime returns the point's time in UTC, or false when the point has no timestamp
+func pointTime(point GPSPoint) (time.Time, bool) {
+	if point.Timestamp <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(point.Timestamp).UTC(), true
+}
+
+// pointSpeed returns the point's speed in m/s, or nil when unknown (apps report -1)
+func pointSpeed(point GPSPoint) *float64 {
+	if point.Speed == nil || *point.Speed < 0 {
+		return nil
+	}
+	return point.Speed
+}
+
+// pointCourse returns the point's heading normalized to [0, 360), or nil when unknown
+func pointCourse(point GPSPoint) *float64 {
+	if point.Heading == nil || *point.Heading < 0 {
+		return nil
+	}
+	course := math.Mod(*point.Heading, 360)
+	return &course
+}
+
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// GPX 1.1 with the Garmin TrackPointExtension v2 for speed and course
+
+type gpxDocument struct {
+	XMLName        xml.Name      `xml:"gpx"`
+	Version        string        `xml:"version,attr"`
+	Creator        string        `xml:"creator,attr"`
+	Xmlns          string        `xml:"xmlns,attr"`
+	XmlnsXsi       string        `xml:"xmlns:xsi,attr"`
+	XmlnsTpx       string        `xml:"xmlns:gpxtpx,attr"`
+	SchemaLocation string        `xml:"xsi:schemaLocation,attr"`
+	Metadata       gpxMetadata   `xml:"metadata"`
+	Waypoints      []gpxWaypoint `xml:"wpt"`
+	Track          gpxTrack      `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name"`
+	Time string `xml:"time,omitempty"`
+}
+
+type gpxWaypoint struct {
+	Lat         string   `xml:"lat,attr"`
+	Lon         string   `xml:"lon,attr"`
+	Elevation   *float64 `xml:"ele,omitempty"`
+	Time        string   `xml:"time,omitempty"`
+	Name        string   `xml:"name"`
+	Description string   `xml:"desc,omitempty"`
+	Type        string   `xml:"type,omitempty"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Type     string       `xml:"type"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxTrackPoint `xml:"trkpt"`
+}
+
+type gpxTrackPoint struct {
+	Lat        string         `xml:"lat,attr"`
+	Lon        string         `xml:"lon,attr"`
+	Elevation  *float64       `xml:"ele,omitempty"`
+	Time       string         `xml:"time,omitempty"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+type gpxExtensions struct {
+	TrackPoint gpxTrackPointExtension `xml:"gpxtpx:TrackPointExtension"`
+}
+
+type gpxTrackPointExtension struct {
+	Speed  *float64 `xml:"gpxtpx:speed,omitempty"`  // m/s
+	Course *float64 `xml:"gpxtpx:course,omitempty"` // degrees
+}
+
+func renderTripGPX(trip *models.Trip, path []GPSPoint) ([]byte, error) {
+	doc := gpxDocument{
+		Version:        "1.1",
+		Creator:        tripExportCreator,
+		Xmlns:          "http://www.topografix.com/GPX/1/1",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		XmlnsTpx:       "http://www.garmin.com/xmlschemas/TrackPointExtension/v2",
+		SchemaLocation: "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v2 http://www8.garmin.com/xmlschemas/TrackPointExtensionv2.xsd",
+		Metadata: gpxMetadata{
+			Name: tripExportName(trip),
+			Time: trip.StartedAt.UTC().Format(time.RFC3339),
+		},
+		Track: gpxTrack{Name: tripExportName(trip), Type: "train"},
+	}
+
+	for _, waypoint := range tripWaypoints(trip) {
+		wpt := gpxWaypoint{
+			Lat:         formatCoordinate(waypoint.Lat),
+			Lon:         formatCoordinate(waypoint.Lng),
+			Elevation:   waypoint.Elevation,
+			Name:        waypoint.Name,
+			Description: waypoint.Description,
+			Type:        waypoint.Kind,
+		}
+		if waypoint.Time != nil {
+			wpt.Time = waypoint.Time.UTC().Format(time.RFC3339)
+		}
+		doc.Waypoints = append(doc.Waypoints, wpt)
+	}
+
+	segment := gpxSegment{Points: make([]gpxTrackPoint, 0, len(path))}
+	for _, point := range path {
+		trkpt := gpxTrackPoint{
+			Lat:       formatCoordinate(point.Lat),
+			Lon:       formatCoordinate(point.Lng),
+			Elevation: point.Altitude,
+		}
+		if t, ok := pointTime(point); ok {
+			trkpt.Time = t.Format("2006-01-02T15:04:05.000Z")
+		}
+		speed, course := pointSpeed(point), pointCourse(point)
+		if speed != nil || course != nil {
+			trkpt.Extensions = &gpxExtensions{TrackPoint: gpxTrackPointExtension{Speed: speed, Course: course}}
+		}
+		segment.Points = append(segment.Points, trkpt)
+	}
+	doc.Track.Segments = []gpxSegment{segment}
+
+	return marshalXMLDocument(doc)
+}
+
+// KML 2.2 with a gx:Track, so every point keeps its timestamp, and speed as track data
+
+type kmlDocument struct {
+	XMLName  xml.Name        `xml:"kml"`
+	Xmlns    string          `xml:"xmlns,attr"`
+	XmlnsGx  string          `xml:"xmlns:gx,attr"`
+	Document kmlDocumentBody `xml:"Document"`
+}
+
+type kmlDocumentBody struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	Schema      kmlSchema      `xml:"Schema"`
+	Placemarks  []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlSchema struct {
+	ID     string                `xml:"id,attr"`
+	Fields []kmlSimpleArrayField `xml:"gx:SimpleArrayField"`
+}
+
+type kmlSimpleArrayField struct {
+	Name        string `xml:"name,attr"`
+	Type        string `xml:"type,attr"`
+	DisplayName string `xml:"displayName"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	TimeStamp   *kmlTimeStamp  `xml:"TimeStamp,omitempty"`
+	Point       *kmlPoint      `xml:"Point,omitempty"`
+	LineString  *kmlLineString `xml:"LineString,omitempty"`
+	Track       *kmlTrack      `xml:"gx:Track,omitempty"`
+}
+
+type kmlTimeStamp struct {
+	When string `xml:"when"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlLineString struct {
+	Tessellate   int    `xml:"tessellate"`
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type kmlTrack struct {
+	AltitudeMode string           `xml:"altitudeMode"`
+	When         []string         `xml:"when"`
+	Coords       []string         `xml:"gx:coord"`
+	ExtendedData *kmlExtendedData `xml:"ExtendedData,omitempty"`
+}
+
+type kmlExtendedData struct {
+	SchemaData kmlSchemaData `xml:"SchemaData"`
+}
+
+type kmlSchemaData struct {
+	SchemaURL string               `xml:"schemaUrl,attr"`
+	Arrays    []kmlSimpleArrayData `xml:"gx:SimpleArrayData"`
+}
+
+type kmlSimpleArrayData struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"gx:value"`
+}
+
+func renderTripKML(trip *models.Trip, path []GPSPoint) ([]byte, error) {
+	doc := kmlDocument{
+		Xmlns:   "http://www.opengis.net/kml/2.2",
+		XmlnsGx: "http://www.google.com/kml/ext/2.2",
+		Document: kmlDocumentBody{
+			Name:        tripExportName(trip),
+			Description: fmt.Sprintf("%.2f km, max %.1f km/h", trip.TotalDistanceKm, trip.MaxSpeedKmh),
+			Schema: kmlSchema{
+				ID: "trackData",
+				Fields: []kmlSimpleArrayField{
+					{Name: "speed", Type: "float", DisplayName: "Speed (m/s)"},
+				},
+			},
+		},
+	}
+
+	for _, waypoint := range tripWaypoints(trip) {
+		placemark := kmlPlacemark{
+			Name:        waypoint.Name,
+			Description: waypoint.Description,
+			Point:       &kmlPoint{Coordinates: kmlCoordinate(waypoint.Lat, waypoint.Lng, waypoint.Elevation, ",")},
+		}
+		if waypoint.Time != nil {
+			placemark.TimeStamp = &kmlTimeStamp{When: waypoint.Time.UTC().Format(time.RFC3339)}
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, placemark)
+	}
+
+	altitudeMode := "clampToGround"
+	timed := true
+	for _, point := range path {
+		if _, ok := pointTime(point); !ok {
+			timed = false
+		}
+	}
+	if pathHasAltitude(path) {
+		altitudeMode = "absolute"
+	}
+
+	track := kmlPlacemark{Name: "Track"}
+	if timed {
+		// gx:Track needs a time for every coordinate
+		gxTrack := &kmlTrack{AltitudeMode: altitudeMode}
+		speeds := kmlSimpleArrayData{Name: "speed"}
+		for _, point := range path {
+			t, _ := pointTime(point)
+			gxTrack.When = append(gxTrack.When, t.Format("2006-01-02T15:04:05.000Z"))
+			gxTrack.Coords = append(gxTrack.Coords, kmlCoordinate(point.Lat, point.Lng, point.Altitude, " "))
+			speed := ""
+			if s := pointSpeed(point); s != nil {
+				speed = formatCoordinate(*s)
+			}
+			speeds.Values = append(speeds.Values, speed)
+		}
+		gxTrack.ExtendedData = &kmlExtendedData{SchemaData: kmlSchemaData{SchemaURL: "#trackData", Arrays: []kmlSimpleArrayData{speeds}}}
+		track.Track = gxTrack
+	} else {
+		coordinates := make([]string, 0, len(path))
+		for _, point := range path {
+			coordinates = append(coordinates, kmlCoordinate(point.Lat, point.Lng, point.Altitude, ","))
+		}
+		track.LineString = &kmlLineString{Tessellate: 1, AltitudeMode: altitudeMode, Coordinates: strings.Join(coordinates, " ")}
+	}
+	doc.Document.Placemarks = append(doc.Document.Placemarks, track)
+
+	return marshalXMLDocument(doc)
+}
+
+// kmlCoordinate formats lng, lat and altitude (0 when unknown) with the given separator
+func kmlCoordinate(lat, lng float64, altitude *float64, separator string) string {
+	alt := 0.0
+	if altitude != nil {
+		alt = *altitude
+	}
+	return formatCoordinate(lng) + separator + formatCoordinate(lat) + separator + formatCoordinate(alt)
+}
+
+// pathHasAltitude reports whether every point has an altitude
+func pathHasAltitude(path []GPSPoint) bool {
+	for _, point := range path {
+		if point.Altitude == nil {
+			return false
+		}
+	}
+	return len(path) > 0
+}
+
+func marshalXMLDocument(doc interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// GeoJSON (RFC 7946): the track as a LineString with per-point times and speeds in
+// coordinateProperties, and the waypoints as Points
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func renderTripGeoJSON(trip *models.Trip, path []GPSPoint) ([]byte, error) {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+
+	// Positions carry elevation only if every point has one, so all positions have the same length
+	withAltitude := pathHasAltitude(path)
+	coordinates := make([][]float64, 0, len(path))
+	times := make([]interface{}, 0, len(path))
+	speeds := make([]interface{}, 0, len(path))
+	for _, point := range path {
+		position := []float64{point.Lng, point.Lat}
+		if withAltitude {
+			position = append(position, *point.Altitude)
+		}
+		coordinates = append(coordinates, position)
+
+		if t, ok := pointTime(point); ok {
+			times = append(times, t.Format("2006-01-02T15:04:05.000Z"))
+		} else {
+			times = append(times, nil)
+		}
+		if speed := pointSpeed(point); speed != nil {
+			speeds = append(speeds, *speed)
+		} else {
+			speeds = append(speeds, nil)
+		}
+	}
+
+	collection.Features = append(collection.Features, geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+		Properties: map[string]interface{}{
+			"name":              tripExportName(trip),
+			"trip_id":           trip.ID,
+			"train_number":      trip.TrainNumber,
+			"started_at":        trip.StartedAt.UTC().Format(time.RFC3339),
+			"completed_at":      trip.CompletedAt.UTC().Format(time.RFC3339),
+			"total_distance_km": trip.TotalDistanceKm,
+			"max_speed_kmh":     trip.MaxSpeedKmh,
+			"coordinateProperties": map[string]interface{}{
+				"times":  times,
+				"speeds": speeds, // m/s
+			},
+		},
+	})
+
+	for _, waypoint := range tripWaypoints(trip) {
+		position := []float64{waypoint.Lng, waypoint.Lat}
+		if waypoint.Elevation != nil {
+			position = append(position, *waypoint.Elevation)
+		}
+		properties := map[string]interface{}{
+			"type": waypoint.Kind,
+			"name": waypoint.Name,
+		}
+		if waypoint.Description != "" {
+			properties["description"] = waypoint.Description
+		}
+		if waypoint.Time != nil {
+			properties["time"] = waypoint.Time.UTC().Format(time.RFC3339)
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "Point", Coordinates: position},
+			Properties: properties,
+		})
+	}
+
+	return json.MarshalIndent(collection, "", "  ")
+}
+
+// ExportUserTrip - Downloads one of the authenticated user's trips as GPX, KML or GeoJSON
+func (h *SimpleLiveTrackingHandler) ExportUserTrip(c *gin.Context) {
+	user, exists := middleware.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Authentication required",
+		})
+		return
+	}
+
+	formatName := strings.ToLower(c.DefaultQuery("format", "gpx"))
+	format, ok := tripExportFormats[formatName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid format, use gpx, kml or geojson",
+		})
+		return
+	}
+
+	tripID, ok := parseTripID(c)
+	if !ok {
+		return
+	}
+
+	trip, err := h.findUserTrip(user.ID, tripID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Trip not found",
+		})
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR: Failed to fetch trip %d of user %d for export: %v\n", tripID, user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch trip",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	path := tripGPSPath(trip)
+	if len(path) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Trip has no GPS data to export",
+		})
+		return
+	}
+
+	body, err := format.render(trip, path)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to export trip %d as %s: %v\n", tripID, formatName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to export trip",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	fmt.Printf("DEBUG: User %d exported trip %d as %s (%d points)\n", user.ID, tripID, formatName, len(path))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="trip-%d-%s.%s"`, trip.ID, trip.TrainNumber, format.extension))
+	c.Data(http.StatusOK, format.contentType, body)
+}
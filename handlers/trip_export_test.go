@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+const (
+	gpxNamespace    = "http://www.topografix.com/GPX/1/1"
+	gpxTpxNamespace = "http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+	kmlNamespace    = "http://www.opengis.net/kml/2.2"
+	gxNamespace     = "http://www.google.com/kml/ext/2.2"
+)
+
+func exportTestTrip() *models.Trip {
+	relation := "Jakarta - Bandung"
+	from, to := "Gambir", "Bandung"
+	maxSpeedLat, maxSpeedLng := -6.5, 107.2
+	maxElevationLat, maxElevationLng := -6.8, 107.5
+	return &models.Trip{
+		TrainName:       "Argo Parahyangan",
+		TrainNumber:     "KA-20",
+		TrainRelation:   &relation,
+		FromStationName: &from,
+		ToStationName:   &to,
+		TotalDistanceKm: 150.2,
+		MaxSpeedKmh:     110,
+		MaxElevationM:   720,
+		StartLatitude:   -6.17,
+		StartLongitude:  106.83,
+		EndLatitude:     -6.91,
+		EndLongitude:    107.60,
+		MaxSpeedLat:     &maxSpeedLat,
+		MaxSpeedLng:     &maxSpeedLng,
+		MaxElevationLat: &maxElevationLat,
+		MaxElevationLng: &maxElevationLng,
+		StartedAt:       time.Date(2026, 3, 1, 7, 0, 0, 0, time.UTC),
+		CompletedAt:     time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+// exportTestPath has clearly different latitudes and longitudes, so swapped axes are caught
+func exportTestPath(timed bool) []GPSPoint {
+	speed, altitude, heading := 20.5, 35.0, 370.0
+	path := []GPSPoint{
+		{Lat: -6.17, Lng: 106.83, Altitude: &altitude},
+		{Lat: -6.50, Lng: 107.20, Altitude: &altitude, Speed: &speed, Heading: &heading},
+		{Lat: -6.91, Lng: 107.60, Altitude: &altitude},
+	}
+	if timed {
+		for i := range path {
+			path[i].Timestamp = time.Date(2026, 3, 1, 7+i, 0, 0, 0, time.UTC).UnixMilli()
+		}
+	}
+	return path
+}
+
+// xmlNode is a parsed element with its namespace-resolved name
+type xmlNode struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Children []*xmlNode
+	Text     string
+}
+
+func parseXML(t *testing.T, body []byte) *xmlNode {
+	t.Helper()
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid XML: %v\n%s", err, body)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Name: token.Name, Attr: token.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(token)
+			}
+		}
+	}
+	if root == nil {
+		t.Fatalf("empty XML document")
+	}
+	return root
+}
+
+func (n *xmlNode) children(local string) []*xmlNode {
+	var matches []*xmlNode
+	for _, child := range n.Children {
+		if child.Name.Local == local {
+			matches = append(matches, child)
+		}
+	}
+	return matches
+}
+
+func (n *xmlNode) attr(local string) string {
+	for _, attr := range n.Attr {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// checkChildOrder fails when a child element isn't in the schema's sequence or appears out of
+// its order
+func checkChildOrder(t *testing.T, node *xmlNode, sequence []string) {
+	t.Helper()
+	position := make(map[string]int, len(sequence))
+	for i, name := range sequence {
+		position[name] = i
+	}
+
+	last := -1
+	for _, child := range node.Children {
+		pos, ok := position[child.Name.Local]
+		if !ok {
+			t.Errorf("<%s> isn't allowed in <%s>", child.Name.Local, node.Name.Local)
+			continue
+		}
+		if pos < last {
+			t.Errorf("<%s> is out of order in <%s>", child.Name.Local, node.Name.Local)
+		}
+		last = pos
+	}
+}
+
+// checkNamespaces fails when an element isn't in the expected namespace, which is the
+// extension namespace for the listed elements and the document namespace otherwise
+func checkNamespaces(t *testing.T, node *xmlNode, namespace string, extensionNamespace string, extensionElements map[string]bool) {
+	t.Helper()
+	want := namespace
+	if extensionElements[node.Name.Local] {
+		want = extensionNamespace
+	}
+	if node.Name.Space != want {
+		t.Errorf("<%s> is in namespace %q, want %q", node.Name.Local, node.Name.Space, want)
+	}
+	for _, child := range node.Children {
+		checkNamespaces(t, child, namespace, extensionNamespace, extensionElements)
+	}
+}
+
+// Sequences from the GPX 1.1 schema (gpx.xsd) and the TrackPointExtension v2 schema
+var (
+	gpxTypeSequence      = []string{"metadata", "wpt", "rte", "trk", "extensions"}
+	gpxMetadataSequence  = []string{"name", "desc", "author", "copyright", "link", "time", "keywords", "bounds", "extensions"}
+	gpxWptTypeSequence   = []string{"ele", "time", "magvar", "geoidheight", "name", "cmt", "desc", "src", "link", "sym", "type", "fix", "sat", "hdop", "vdop", "pdop", "ageofdgpsdata", "dgpsid", "extensions"}
+	gpxTrkTypeSequence   = []string{"name", "cmt", "desc", "src", "link", "number", "type", "extensions", "trkseg"}
+	gpxTrksegSequence    = []string{"trkpt", "extensions"}
+	gpxTpxSequence       = []string{"atemp", "wtemp", "depth", "hr", "cad", "speed", "course", "bearing", "Extensions"}
+	gpxTpxElementsByName = map[string]bool{"TrackPointExtension": true, "atemp": true, "wtemp": true, "depth": true, "hr": true, "cad": true, "speed": true, "course": true, "bearing": true}
+)
+
+func TestRenderTripGPX(t *testing.T) {
+	body, err := renderTripGPX(exportTestTrip(), exportTestPath(true))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	root := parseXML(t, body)
+
+	if root.Name.Local != "gpx" || root.attr("version") != "1.1" || root.attr("creator") == "" {
+		t.Fatalf("root is <%s version=%q creator=%q>, want a GPX 1.1 root with creator", root.Name.Local, root.attr("version"), root.attr("creator"))
+	}
+	checkNamespaces(t, root, gpxNamespace, gpxTpxNamespace, gpxTpxElementsByName)
+
+	checkChildOrder(t, root, gpxTypeSequence)
+	for _, metadata := range root.children("metadata") {
+		checkChildOrder(t, metadata, gpxMetadataSequence)
+	}
+	waypoints := root.children("wpt")
+	if len(waypoints) != 4 {
+		t.Errorf("got %d waypoints, want 4 (start, end, max speed, max elevation)", len(waypoints))
+	}
+	for _, wpt := range waypoints {
+		checkChildOrder(t, wpt, gpxWptTypeSequence)
+	}
+
+	tracks := root.children("trk")
+	if len(tracks) != 1 {
+		t.Fatalf("got %d tracks, want 1", len(tracks))
+	}
+	checkChildOrder(t, tracks[0], gpxTrkTypeSequence)
+	segments := tracks[0].children("trkseg")
+	if len(segments) != 1 {
+		t.Fatalf("got %d track segments, want 1", len(segments))
+	}
+	checkChildOrder(t, segments[0], gpxTrksegSequence)
+
+	points := segments[0].children("trkpt")
+	if len(points) != 3 {
+		t.Fatalf("got %d track points, want 3", len(points))
+	}
+	if points[0].attr("lat") != "-6.17" || points[0].attr("lon") != "106.83" {
+		t.Errorf("first track point lat=%q lon=%q, want lat=-6.17 lon=106.83", points[0].attr("lat"), points[0].attr("lon"))
+	}
+	for _, trkpt := range points {
+		checkChildOrder(t, trkpt, gpxWptTypeSequence)
+		for _, extensions := range trkpt.children("extensions") {
+			for _, tpx := range extensions.children("TrackPointExtension") {
+				checkChildOrder(t, tpx, gpxTpxSequence)
+			}
+		}
+	}
+
+	// Heading 370 is exported as course 10, speed stays in m/s
+	extensions := points[1].children("extensions")
+	if len(extensions) != 1 {
+		t.Fatalf("second track point has no extensions")
+	}
+	tpx := extensions[0].children("TrackPointExtension")[0]
+	if speed := tpx.children("speed"); len(speed) != 1 || speed[0].Text != "20.5" {
+		t.Errorf("speed extension = %v, want 20.5", speed)
+	}
+	if course := tpx.children("course"); len(course) != 1 || course[0].Text != "10" {
+		t.Errorf("course extension = %v, want 10", course)
+	}
+	if times := points[0].children("time"); len(times) != 1 || times[0].Text != "2026-03-01T07:00:00.000Z" {
+		t.Errorf("track point time = %v, want 2026-03-01T07:00:00.000Z", times)
+	}
+}
+
+// Sequences from the KML 2.2 schema (ogckml22.xsd) and the gx extension schema (kml22gx.xsd)
+var (
+	kmlDocumentSequence   = []string{"name", "visibility", "open", "author", "link", "address", "AddressDetails", "phoneNumber", "Snippet", "description", "AbstractView", "TimeStamp", "TimeSpan", "styleUrl", "Style", "StyleMap", "Region", "ExtendedData", "Schema", "Placemark", "Folder", "Document"}
+	kmlPlacemarkSequence  = []string{"name", "visibility", "open", "author", "link", "address", "AddressDetails", "phoneNumber", "Snippet", "description", "AbstractView", "TimeStamp", "TimeSpan", "styleUrl", "Style", "StyleMap", "Region", "ExtendedData", "Point", "LineString", "Track"}
+	kmlLineStringSequence = []string{"extrude", "tessellate", "altitudeMode", "coordinates"}
+	kmlTrackSequence      = []string{"altitudeMode", "when", "coord", "angles", "Model", "ExtendedData"}
+	kmlGxElementsByName   = map[string]bool{"Track": true, "coord": true, "angles": true, "SimpleArrayField": true, "SimpleArrayData": true, "value": true}
+)
+
+func checkKMLStructure(t *testing.T, body []byte) *xmlNode {
+	t.Helper()
+	root := parseXML(t, body)
+	if root.Name.Local != "kml" {
+		t.Fatalf("root is <%s>, want <kml>", root.Name.Local)
+	}
+	checkNamespaces(t, root, kmlNamespace, gxNamespace, kmlGxElementsByName)
+
+	documents := root.children("Document")
+	if len(documents) != 1 || len(root.Children) != 1 {
+		t.Fatalf("<kml> must contain exactly one <Document>")
+	}
+	checkChildOrder(t, documents[0], kmlDocumentSequence)
+	for _, placemark := range documents[0].children("Placemark") {
+		checkChildOrder(t, placemark, kmlPlacemarkSequence)
+		for _, lineString := range placemark.children("LineString") {
+			checkChildOrder(t, lineString, kmlLineStringSequence)
+		}
+		for _, track := range placemark.children("Track") {
+			checkChildOrder(t, track, kmlTrackSequence)
+		}
+	}
+	return documents[0]
+}
+
+func TestRenderTripKMLTrack(t *testing.T) {
+	body, err := renderTripKML(exportTestTrip(), exportTestPath(true))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	document := checkKMLStructure(t, body)
+
+	placemarks := document.children("Placemark")
+	if len(placemarks) != 5 {
+		t.Fatalf("got %d placemarks, want 4 waypoints and the track", len(placemarks))
+	}
+	// KML coordinates are lon,lat[,alt]
+	if coordinates := placemarks[0].children("Point")[0].children("coordinates")[0].Text; coordinates != "106.83,-6.17,0" {
+		t.Errorf("start point coordinates = %q, want 106.83,-6.17,0", coordinates)
+	}
+
+	tracks := placemarks[4].children("Track")
+	if len(tracks) != 1 {
+		t.Fatalf("timed path isn't exported as gx:Track")
+	}
+	when, coords := tracks[0].children("when"), tracks[0].children("coord")
+	if len(when) != 3 || len(coords) != 3 {
+		t.Fatalf("gx:Track has %d when and %d gx:coord, want 3 each", len(when), len(coords))
+	}
+	// gx:coord is space separated lon lat alt
+	if coords[0].Text != "106.83 -6.17 35" {
+		t.Errorf("first gx:coord = %q, want \"106.83 -6.17 35\"", coords[0].Text)
+	}
+	if mode := tracks[0].children("altitudeMode")[0].Text; mode != "absolute" {
+		t.Errorf("altitudeMode = %q, want absolute for a path with altitudes", mode)
+	}
+
+	values := tracks[0].children("ExtendedData")[0].children("SchemaData")[0].children("SimpleArrayData")[0].children("value")
+	if len(values) != len(when) {
+		t.Errorf("got %d speed values for %d points", len(values), len(when))
+	}
+}
+
+func TestRenderTripKMLLineString(t *testing.T) {
+	body, err := renderTripKML(exportTestTrip(), exportTestPath(false))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	document := checkKMLStructure(t, body)
+
+	placemarks := document.children("Placemark")
+	track := placemarks[len(placemarks)-1]
+	lineStrings := track.children("LineString")
+	if len(lineStrings) != 1 || len(track.children("Track")) != 0 {
+		t.Fatalf("untimed path isn't exported as a LineString")
+	}
+	if coordinates := lineStrings[0].children("coordinates")[0].Text; coordinates != "106.83,-6.17,35 107.2,-6.5,35 107.6,-6.91,35" {
+		t.Errorf("LineString coordinates = %q", coordinates)
+	}
+}
+
+func TestRenderTripGeoJSON(t *testing.T) {
+	body, err := renderTripGeoJSON(exportTestTrip(), exportTestPath(true))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	var collection map[string]interface{}
+	if err := json.Unmarshal(body, &collection); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	// RFC 7946 section 4: coordinates are always WGS 84, the crs member is gone
+	if _, ok := collection["crs"]; ok {
+		t.Errorf("FeatureCollection has a crs member")
+	}
+	if collection["type"] != "FeatureCollection" {
+		t.Fatalf("type = %v, want FeatureCollection", collection["type"])
+	}
+
+	features, ok := collection["features"].([]interface{})
+	if !ok || len(features) != 5 {
+		t.Fatalf("got %v features, want the track and 4 waypoints", len(features))
+	}
+	for i, raw := range features {
+		feature := raw.(map[string]interface{})
+		// RFC 7946 section 3.2: a Feature has type, geometry and properties members
+		if feature["type"] != "Feature" {
+			t.Errorf("feature %d type = %v, want Feature", i, feature["type"])
+		}
+		if _, ok := feature["properties"].(map[string]interface{}); !ok {
+			t.Errorf("feature %d has no properties object", i)
+		}
+		if _, ok := feature["crs"]; ok {
+			t.Errorf("feature %d has a crs member", i)
+		}
+		geometry, ok := feature["geometry"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("feature %d has no geometry object", i)
+		}
+		if _, ok := geometry["crs"]; ok {
+			t.Errorf("feature %d geometry has a crs member", i)
+		}
+
+		switch geometry["type"] {
+		case "LineString":
+			if i != 0 {
+				t.Errorf("LineString at feature %d, want the track first", i)
+			}
+			coordinates := geometry["coordinates"].([]interface{})
+			// RFC 7946 section 3.1.4: a LineString has two or more positions
+			if len(coordinates) < 2 {
+				t.Fatalf("LineString has %d positions", len(coordinates))
+			}
+			for _, position := range coordinates {
+				checkGeoJSONPosition(t, position)
+			}
+			// RFC 7946 section 3.1.1: longitude first
+			first := coordinates[0].([]interface{})
+			if first[0] != 106.83 || first[1] != -6.17 || first[2] != 35.0 {
+				t.Errorf("first position = %v, want [106.83, -6.17, 35]", first)
+			}
+
+			coordinateProperties := feature["properties"].(map[string]interface{})["coordinateProperties"].(map[string]interface{})
+			if times := coordinateProperties["times"].([]interface{}); len(times) != len(coordinates) {
+				t.Errorf("got %d times for %d positions", len(times), len(coordinates))
+			}
+		case "Point":
+			checkGeoJSONPosition(t, geometry["coordinates"])
+		default:
+			t.Errorf("feature %d has geometry type %v", i, geometry["type"])
+		}
+	}
+
+	start := features[1].(map[string]interface{})["geometry"].(map[string]interface{})["coordinates"].([]interface{})
+	if start[0] != 106.83 || start[1] != -6.17 {
+		t.Errorf("start waypoint = %v, want [106.83, -6.17]", start)
+	}
+}
+
+// checkGeoJSONPosition fails unless position is [lon, lat] or [lon, lat, elevation] in range
+func checkGeoJSONPosition(t *testing.T, position interface{}) {
+	t.Helper()
+	values, ok := position.([]interface{})
+	if !ok || len(values) < 2 || len(values) > 3 {
+		t.Errorf("position %v isn't [lon, lat] or [lon, lat, elevation]", position)
+		return
+	}
+	lon, lonOK := values[0].(float64)
+	lat, latOK := values[1].(float64)
+	if !lonOK || !latOK || lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		t.Errorf("position %v is out of range", position)
+	}
+}
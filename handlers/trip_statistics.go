@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"math"
+	"time"
+)
+
+// Trip statistics tuning
+const (
+	tripJitterMinMeters      = 5.0              // movement below this (or the point's accuracy) is GPS noise
+	tripJitterMaxMeters      = 50.0             // cap for the accuracy-based noise threshold
+	tripMovingSpeedMps       = 1.0              // slower intervals count as stopped (3.6 km/h)
+	tripStopMinDuration      = 30 * time.Second // shorter halts aren't counted as stops
+	tripMaxIntervalGap       = 10 * time.Minute // longer gaps without points count for neither moving nor stopped
+	tripElevationWindow      = 5                // points in the altitude moving average
+	tripElevationHysteresisM = 3.0              // smoothed altitude change needed to count as ascent/descent
+)
+
+// tripMotion is the moving/stopped split of a trip
+type tripMotion struct {
+	moving  time.Duration
+	stopped time.Duration
+	stops   int // halts of at least tripStopMinDuration between moving sections
+}
+
+// jitterFreeDistanceKm sums the path distance, only advancing once a point is farther from the
+// last counted point than GPS noise, so a stationary phone doesn't add distance
+func jitterFreeDistanceKm(path []GPSPoint) float64 {
+	if len(path) < 2 {
+		return 0
+	}
+
+	total := 0.0
+	anchor := path[0]
+	for _, point := range path[1:] {
+		threshold := tripJitterMinMeters
+		if point.Accuracy != nil && *point.Accuracy > threshold {
+			threshold = math.Min(*point.Accuracy, tripJitterMaxMeters)
+		}
+
+		distanceKm := calculateDistance(anchor.Lat, anchor.Lng, point.Lat, point.Lng)
+		if distanceKm*1000 >= threshold {
+			total += distanceKm
+			anchor = point
+		}
+	}
+	return total
+}
+
+// analyzeTripMotion splits the trip time into moving and stopped intervals and counts the stops
+// between moving sections. The path must be time-ordered.
+func analyzeTripMotion(path []GPSPoint) tripMotion {
+	var motion tripMotion
+	var stoppedRun time.Duration
+	movedBefore := false
+
+	for i := 1; i < len(path); i++ {
+		previous, point := path[i-1], path[i]
+		if previous.Timestamp <= 0 || point.Timestamp <= previous.Timestamp {
+			continue
+		}
+		interval := time.Duration(point.Timestamp-previous.Timestamp) * time.Millisecond
+		if interval > tripMaxIntervalGap {
+			continue
+		}
+
+		// Prefer the reported speed, fall back to the speed implied by the two points
+		speed := calculateDistance(previous.Lat, previous.Lng, point.Lat, point.Lng) * 1000 / interval.Seconds()
+		if point.Speed != nil && *point.Speed >= 0 {
+			speed = *point.Speed
+		}
+
+		if speed >= tripMovingSpeedMps {
+			motion.moving += interval
+			if movedBefore && stoppedRun >= tripStopMinDuration {
+				motion.stops++
+			}
+			stoppedRun = 0
+			movedBefore = true
+		} else {
+			motion.stopped += interval
+			stoppedRun += interval
+		}
+	}
+
+	return motion
+}
+
+// smoothedElevationChange returns the cumulative ascent and descent in meters. Altitudes are
+// smoothed with a moving average and changes count only past a hysteresis, so GPS altitude
+// noise doesn't add up to hundreds of meters.
+func smoothedElevationChange(path []GPSPoint) (gain, loss float64) {
+	var altitudes []float64
+	for _, point := range path {
+		if point.Altitude != nil {
+			altitudes = append(altitudes, *point.Altitude)
+		}
+	}
+	if len(altitudes) < 2 {
+		return 0, 0
+	}
+
+	half := tripElevationWindow / 2
+	reference := math.NaN()
+	for i := range altitudes {
+		from, to := i-half, i+half+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(altitudes) {
+			to = len(altitudes)
+		}
+		sum := 0.0
+		for _, altitude := range altitudes[from:to] {
+			sum += altitude
+		}
+		smoothed := sum / float64(to-from)
+
+		if math.IsNaN(reference) {
+			reference = smoothed
+			continue
+		}
+		if change := smoothed - reference; change >= tripElevationHysteresisM {
+			gain += change
+			reference = smoothed
+		} else if change <= -tripElevationHysteresisM {
+			loss -= change
+			reference = smoothed
+		}
+	}
+
+	return gain, loss
+}
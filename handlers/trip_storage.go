@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Full-resolution tracking data moved out of MySQL: trip-tracking/<yyyy>/<mm>/<session_id>.json
+const tripTrackingDataPrefix = "trip-tracking/"
+
+// SetTripStorage sets how trips are stored: route coordinates are simplified with the given
+// tolerance in meters (0 keeps every point), and with trackingDataInS3 the full tracking data is
+// stored as an S3 object referenced from the trip row instead of in MySQL
+func (h *SimpleLiveTrackingHandler) SetTripStorage(routeToleranceMeters float64, trackingDataInS3 bool) {
+	h.routeToleranceMeters = routeToleranceMeters
+	h.tripDataInS3 = trackingDataInS3
+	fmt.Printf("INFO: Trip route simplification tolerance: %.1fm, tracking data in S3: %t\n", routeToleranceMeters, trackingDataInS3)
+}
+
+// simplifyPath reduces a path with the Douglas-Peucker algorithm: points closer than
+// toleranceMeters to the simplified line are dropped. First and last points are always kept.
+func simplifyPath(points []GPSPoint, toleranceMeters float64) []GPSPoint {
+	if toleranceMeters <= 0 || len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0] = true
+	keep[len(points)-1] = true
+
+	// Iterative to keep the stack flat on paths with tens of thousands of points
+	ranges := [][2]int{{0, len(points) - 1}}
+	for len(ranges) > 0 {
+		first, last := ranges[len(ranges)-1][0], ranges[len(ranges)-1][1]
+		ranges = ranges[:len(ranges)-1]
+
+		maxDistance := 0.0
+		farthest := -1
+		for i := first + 1; i < last; i++ {
+			if distance := segmentDistanceMeters(points[i], points[first], points[last]); distance > maxDistance {
+				maxDistance = distance
+				farthest = i
+			}
+		}
+
+		if farthest >= 0 && maxDistance > toleranceMeters {
+			keep[farthest] = true
+			ranges = append(ranges, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	simplified := make([]GPSPoint, 0, len(points)/4)
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// segmentDistanceMeters returns the distance of p to the segment a-b on a local flat projection,
+// which is accurate enough at GPS-point spacing
+func segmentDistanceMeters(p, a, b GPSPoint) float64 {
+	const earthRadius = 6371000.0
+	cosLat := math.Cos(a.Lat * math.Pi / 180)
+	project := func(point GPSPoint) (float64, float64) {
+		return (point.Lng - a.Lng) * math.Pi / 180 * earthRadius * cosLat, (point.Lat - a.Lat) * math.Pi / 180 * earthRadius
+	}
+
+	px, py := project(p)
+	bx, by := project(b)
+
+	lengthSquared := bx*bx + by*by
+	if lengthSquared == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/lengthSquared))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// buildRouteCoordinates returns the simplified route of a path as stored in route_coordinates
+func (h *SimpleLiveTrackingHandler) buildRouteCoordinates(path []GPSPoint) (json.RawMessage, error) {
+	simplified := simplifyPath(path, h.routeToleranceMeters)
+
+	routeCoords := make([]map[string]interface{}, 0, len(simplified))
+	for _, point := range simplified {
+		routeCoords = append(routeCoords, map[string]interface{}{
+			"lat":       point.Lat,
+			"lng":       point.Lng,
+			"timestamp": point.Timestamp,
+		})
+	}
+	routeBytes, err := json.Marshal(routeCoords)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(simplified) < len(path) {
+		fmt.Printf("DEBUG: Simplified trip route from %d to %d points (tolerance %.1fm)\n", len(path), len(simplified), h.routeToleranceMeters)
+	}
+	return json.RawMessage(routeBytes), nil
+}
+
+// tripTrackingDataKey returns the S3 object of a session's full tracking data
+func tripTrackingDataKey(session models.LiveTrackingSession) string {
+	return fmt.Sprintf("%s%s/%s.json", tripTrackingDataPrefix, session.StartedAt.In(scheduleLocation).Format("2006/01"), session.SessionID)
+}
+
+// storeTripTrackingData moves the trip's tracking data to S3 when enabled. On failure the data
+// stays in the trip row.
+func (h *SimpleLiveTrackingHandler) storeTripTrackingData(trip *models.Trip, session models.LiveTrackingSession) {
+	if !h.tripDataInS3 || h.s3 == nil || trip.TrackingData == nil {
+		return
+	}
+
+	key := tripTrackingDataKey(session)
+	if err := h.s3.UploadJSON(key, trip.TrackingData); err != nil {
+		fmt.Printf("WARNING: Failed to store tracking data of session %s in S3, keeping it in MySQL: %v\n", session.SessionID, err)
+		return
+	}
+	trip.TrackingData = nil
+	trip.TrackingDataKey = &key
+}
+
+// loadTripTrackingData reads tracking data stored in S3 back into the trip, so readers see the
+// same trip whether or not its data was moved
+func (h *SimpleLiveTrackingHandler) loadTripTrackingData(trip *models.Trip) error {
+	if trip.TrackingDataKey == nil || *trip.TrackingDataKey == "" {
+		return nil
+	}
+
+	var trackingData json.RawMessage
+	if err := h.s3.GetJSON(*trip.TrackingDataKey, &trackingData); err != nil {
+		return fmt.Errorf("failed to read tracking data of trip %d: %w", trip.ID, err)
+	}
+	trip.TrackingData = trackingData
+	return nil
+}
+
+// deleteTripTrackingData deletes tracking data stored in S3 for a deleted trip
+func (h *SimpleLiveTrackingHandler) deleteTripTrackingData(trackingDataKey *string) {
+	if trackingDataKey == nil || *trackingDataKey == "" {
+		return
+	}
+	if err := h.s3.DeleteFile(*trackingDataKey); err != nil {
+		fmt.Printf("WARNING: Failed to delete trip tracking data %s: %v\n", *trackingDataKey, err)
+	}
+}
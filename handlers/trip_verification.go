@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// How far the app's trip summary may differ from the server's statistics before the trip is flagged
+const (
+	tripDistanceTolerance        = 0.2  // relative
+	tripDistanceToleranceKm      = 1.0  // absolute, for short trips
+	tripSpeedToleranceKmh        = 15.0 // max speed
+	tripDurationTolerance        = 0.1  // relative
+	tripDurationToleranceSeconds = 120  // absolute, for short trips
+	tripMaxPlausibleSpeedKmh     = 400.0
+)
+
+// verifyTripSummary stores the app's summary next to the server statistics already on the trip
+// and flags the trip when both disagree beyond the tolerances or either is implausible. The
+// server statistics stay in the main columns, so leaderboards and analytics use them.
+func verifyTripSummary(trip *models.Trip, mobileSummary *TripSummary, pathPoints int) {
+	var reasons []string
+
+	if trip.MaxSpeedKmh > tripMaxPlausibleSpeedKmh {
+		reasons = append(reasons, fmt.Sprintf("server max speed %.0f km/h is implausible", trip.MaxSpeedKmh))
+	}
+
+	if mobileSummary != nil {
+		trip.ClientDistanceKm = &mobileSummary.TotalDistanceKm
+		trip.ClientMaxSpeedKmh = &mobileSummary.MaxSpeedKmh
+		trip.ClientAvgSpeedKmh = &mobileSummary.AvgSpeedKmh
+		trip.ClientDurationSeconds = &mobileSummary.DurationSeconds
+
+		if pathPoints < 2 {
+			reasons = append(reasons, "app summary can't be verified without a GPS path")
+		} else {
+			if diff := math.Abs(mobileSummary.TotalDistanceKm - trip.TotalDistanceKm); diff > tripDistanceToleranceKm && diff > trip.TotalDistanceKm*tripDistanceTolerance {
+				reasons = append(reasons, fmt.Sprintf("distance %.2f km vs server %.2f km", mobileSummary.TotalDistanceKm, trip.TotalDistanceKm))
+			}
+			if math.Abs(mobileSummary.MaxSpeedKmh-trip.MaxSpeedKmh) > tripSpeedToleranceKmh {
+				reasons = append(reasons, fmt.Sprintf("max speed %.0f km/h vs server %.0f km/h", mobileSummary.MaxSpeedKmh, trip.MaxSpeedKmh))
+			}
+			if diff := math.Abs(float64(mobileSummary.DurationSeconds - trip.DurationSeconds)); diff > tripDurationToleranceSeconds && diff > float64(trip.DurationSeconds)*tripDurationTolerance {
+				reasons = append(reasons, fmt.Sprintf("duration %ds vs server %ds", mobileSummary.DurationSeconds, trip.DurationSeconds))
+			}
+		}
+		if mobileSummary.MaxSpeedKmh > tripMaxPlausibleSpeedKmh {
+			reasons = append(reasons, fmt.Sprintf("app max speed %.0f km/h is implausible", mobileSummary.MaxSpeedKmh))
+		}
+	}
+
+	if len(reasons) == 0 {
+		return
+	}
+
+	reason := strings.Join(reasons, "; ")
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	trip.StatsFlagged = true
+	trip.StatsFlagReason = &reason
+}
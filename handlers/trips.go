@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/modernland/golang-live-tracking/middleware"
+	"github.com/modernland/golang-live-tracking/models"
+)
+
+// Trip list pagination
+const (
+	tripsDefaultLimit = 20
+	tripsMaxLimit     = 100
+)
+
+// Columns too large for the trip list - only returned by the trip detail
+var tripHeavyColumns = []string{"tracking_data", "route_coordinates"}
+
+// parseTripID reads the :id path parameter, answering 400 when it isn't a trip ID
+func parseTripID(c *gin.Context) (uint, bool) {
+	tripID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || tripID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid trip ID",
+		})
+		return 0, false
+	}
+	return uint(tripID), true
+}
+
+// findUserTrip loads one of the user's trips, including its GPS data (also when stored in S3)
+func (h *SimpleLiveTrackingHandler) findUserTrip(userID uint, tripID uint) (*models.Trip, error) {
+	var trip models.Trip
+	if err := h.db.Where("id = ? AND user_id = ?", tripID, userID).First(&trip).Error; err != nil {
+		return nil, err
+	}
+	if err := h.loadTripTrackingData(&trip); err != nil {
+		return nil, err
+	}
+	return &trip, nil
+}
+
+// tripGPSPath returns the trip's GPS points from its tracking data, or from its route
+// coordinates for trips saved without tracking data
+func tripGPSPath(trip *models.Trip) []GPSPoint {
+	for _, column := range []interface{}{trip.TrackingData, trip.RouteCoordinates} {
+		// Mobile/server paths and S3 passenger entries share the point fields
+		var points []GPSPoint
+		if err := json.Unmarshal(rawJSONColumn(column), &points); err != nil {
+			continue
+		}
+
+		path := make([]GPSPoint, 0, len(points))
+		for _, point := range points {
+			if point.Lat == 0 && point.Lng == 0 {
+				continue
+			}
+			path = append(path, point)
+		}
+		if len(path) > 0 {
+			return path
+		}
+	}
+	return nil
+}
+
+// parseTripDate parses a YYYY-MM-DD date (schedule timezone) or an RFC3339 time. A date used as
+// the end of a range includes the whole day.
+func parseTripDate(value string, endOfRange bool) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, scheduleLocation); err == nil {
+		if endOfRange {
+			return day.AddDate(0, 0, 1), nil
+		}
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// rawJSONColumn returns a JSON column as scanned by the MySQL driver ([]byte) as raw JSON,
+// so it's embedded in responses instead of being encoded as a base64 string
+func rawJSONColumn(value interface{}) json.RawMessage {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case json.RawMessage:
+		data = v
+	case nil:
+		return json.RawMessage("null")
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return json.RawMessage("null")
+		}
+		return encoded
+	}
+	if !json.Valid(data) {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(data)
+}
+
+// formatTrip returns the trip's list fields
+func formatTrip(trip models.Trip) gin.H {
+	return gin.H{
+		"id":                   trip.ID,
+		"session_id":           trip.SessionID,
+		"train_id":             trip.TrainID,
+		"train_name":           trip.TrainName,
+		"train_number":         trip.TrainNumber,
+		"train_relation":       trip.TrainRelation,
+		"total_distance_km":    trip.TotalDistanceKm,
+		"max_speed_kmh":        trip.MaxSpeedKmh,
+		"avg_speed_kmh":        trip.AvgSpeedKmh,
+		"max_elevation_m":      trip.MaxElevationM,
+		"min_elevation_m":      trip.MinElevationM,
+		"elevation_gain_m":     trip.ElevationGainM,
+		"elevation_loss_m":     trip.ElevationLossM,
+		"duration_seconds":     trip.DurationSeconds,
+		"moving_time_seconds":  trip.MovingTimeSeconds,
+		"stopped_time_seconds": trip.StoppedTimeSeconds,
+		"avg_moving_speed_kmh": trip.AvgMovingSpeedKmh,
+		"stop_count":           trip.StopCount,
+		"stats_flagged":        trip.StatsFlagged,
+		"stats_flag_reason":    trip.StatsFlagReason,
+		"client_stats": gin.H{
+			"total_distance_km": trip.ClientDistanceKm,
+			"max_speed_kmh":     trip.ClientMaxSpeedKmh,
+			"avg_speed_kmh":     trip.ClientAvgSpeedKmh,
+			"duration_seconds":  trip.ClientDurationSeconds,
+		},
+		"start_latitude":    trip.StartLatitude,
+		"start_longitude":   trip.StartLongitude,
+		"end_latitude":      trip.EndLatitude,
+		"end_longitude":     trip.EndLongitude,
+		"max_speed_lat":     trip.MaxSpeedLat,
+		"max_speed_lng":     trip.MaxSpeedLng,
+		"max_elevation_lat": trip.MaxElevationLat,
+		"max_elevation_lng": trip.MaxElevationLng,
+		"from_station_id":   trip.FromStationID,
+		"from_station_name": trip.FromStationName,
+		"to_station_id":     trip.ToStationID,
+		"to_station_name":   trip.ToStationName,
+		"started_at":        trip.StartedAt,
+		"completed_at":      trip.CompletedAt,
+		"created_at":        trip.CreatedAt,
+	}
+}
+
+// GetUserTrips - Lists the authenticated user's saved trips, newest first, without GPS data
+func (h *SimpleLiveTrackingHandler) GetUserTrips(c *gin.Context) {
+	user, exists := middleware.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Authentication required",
+		})
+		return
+	}
+
+	limit := tripsDefaultLimit
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > tripsMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("limit must be between 1 and %d", tripsMaxLimit),
+			})
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if o := c.Query("offset"); o != "" {
+		parsed, err := strconv.Atoi(o)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "offset must be a non-negative number",
+			})
+			return
+		}
+		offset = parsed
+	}
+
+	query := h.db.Model(&models.Trip{}).Where("user_id = ?", user.ID)
+
+	trainNumber := c.Query("train_number")
+	if trainNumber != "" {
+		query = query.Where("train_number = ?", trainNumber)
+	}
+	if from := c.Query("from"); from != "" {
+		fromTime, err := parseTripDate(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid from, use YYYY-MM-DD or RFC3339",
+			})
+			return
+		}
+		query = query.Where("started_at >= ?", fromTime)
+	}
+	if to := c.Query("to"); to != "" {
+		toTime, err := parseTripDate(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid to, use YYYY-MM-DD or RFC3339",
+			})
+			return
+		}
+		query = query.Where("started_at < ?", toTime)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		fmt.Printf("ERROR: Failed to count trips of user %d: %v\n", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch trips",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var trips []models.Trip
+	result := query.Omit(tripHeavyColumns...).
+		Order("started_at DESC, id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&trips)
+	if result.Error != nil {
+		fmt.Printf("ERROR: Failed to fetch trips of user %d: %v\n", user.ID, result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch trips",
+			"error":   result.Error.Error(),
+		})
+		return
+	}
+
+	formattedTrips := make([]gin.H, 0, len(trips))
+	for _, trip := range trips {
+		formattedTrips = append(formattedTrips, formatTrip(trip))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"trips":    formattedTrips,
+			"total":    total,
+			"limit":    limit,
+			"offset":   offset,
+			"has_more": int64(offset+len(trips)) < total,
+		},
+	})
+}
+
+// GetUserTrip - Returns one of the authenticated user's trips with its full GPS data
+func (h *SimpleLiveTrackingHandler) GetUserTrip(c *gin.Context) {
+	user, exists := middleware.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Authentication required",
+		})
+		return
+	}
+
+	tripID, ok := parseTripID(c)
+	if !ok {
+		return
+	}
+
+	trip, err := h.findUserTrip(user.ID, tripID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Trips of other users look the same as missing ones
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Trip not found",
+		})
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR: Failed to fetch trip %d of user %d: %v\n", tripID, user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to fetch trip",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	formattedTrip := formatTrip(*trip)
+	formattedTrip["tracking_data"] = rawJSONColumn(trip.TrackingData)
+	formattedTrip["route_coordinates"] = rawJSONColumn(trip.RouteCoordinates)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    formattedTrip,
+	})
+}
+
+// DeleteUserTrip - Deletes one of the authenticated user's trips
+func (h *SimpleLiveTrackingHandler) DeleteUserTrip(c *gin.Context) {
+	user, exists := middleware.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Authentication required",
+		})
+		return
+	}
+
+	tripID, ok := parseTripID(c)
+	if !ok {
+		return
+	}
+
+	// The tracking data key is needed to delete data moved to S3 together with the trip
+	var trip models.Trip
+	err := h.db.Select("id", "tracking_data_key").Where("id = ? AND user_id = ?", tripID, user.ID).First(&trip).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Trip not found",
+		})
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR: Failed to fetch trip %d of user %d for deletion: %v\n", tripID, user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to delete trip",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	result := h.db.Where("id = ? AND user_id = ?", tripID, user.ID).Delete(&models.Trip{})
+	if result.Error != nil {
+		fmt.Printf("ERROR: Failed to delete trip %d of user %d: %v\n", tripID, user.ID, result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to delete trip",
+			"error":   result.Error.Error(),
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Trip not found",
+		})
+		return
+	}
+
+	h.deleteTripTrackingData(trip.TrackingDataKey)
+
+	fmt.Printf("DEBUG: User %d deleted trip %d\n", user.ID, tripID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Trip deleted successfully",
+		"data": gin.H{
+			"id": tripID,
+		},
+	})
+}
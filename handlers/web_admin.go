@@ -3,6 +3,7 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -17,21 +18,16 @@ import (
 
 type WebAdminHandler struct {
 	db *gorm.DB
-	// In-memory session store (in production, use Redis or database)
-	sessions map[string]*AdminSession
-}
-
-type AdminSession struct {
-	UserID    uint      `json:"user_id"`
-	Username  string    `json:"username"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	// Sessions are kept in MySQL (admin_web_sessions), shared by all instances
+	sessionTTL    time.Duration
+	sessionMaxAge time.Duration
 }
 
 func NewWebAdminHandler(db *gorm.DB) *WebAdminHandler {
 	return &WebAdminHandler{
-		db:       db,
-		sessions: make(map[string]*AdminSession),
+		db:            db,
+		sessionTTL:    adminSessionDefaultTTL,
+		sessionMaxAge: adminSessionDefaultMaxAge,
 	}
 }
 
@@ -45,8 +41,8 @@ func (h *WebAdminHandler) generateSessionID() string {
 // Login page
 func (h *WebAdminHandler) ShowLoginPage(c *gin.Context) {
 	// Check if already logged in
-	if sessionID, err := c.Cookie("admin_session"); err == nil {
-		if session, exists := h.sessions[sessionID]; exists && session.ExpiresAt.After(time.Now()) {
+	if token, err := c.Cookie(adminSessionCookie); err == nil {
+		if _, err := h.lookupSession(token); err == nil {
 			c.Redirect(http.StatusFound, "/admin/dashboard")
 			return
 		}
@@ -465,54 +461,56 @@ func (h *WebAdminHandler) HandleLogin(c *gin.Context) {
 	}
 
 	// Create session
-	sessionID := h.generateSessionID()
-	session := &AdminSession{
-		UserID:    user.ID,
-		Username:  user.Name,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 24 hour session
+	if err := h.createSession(c, user); err != nil {
+		fmt.Printf("ERROR: Failed to create admin session for user %d: %v\n", user.ID, err)
+		c.Redirect(http.StatusFound, "/admin/login?error=Login failed, please try again")
+		return
 	}
 	
-	h.sessions[sessionID] = session
-	
-	// Set secure cookie
-	c.SetCookie("admin_session", sessionID, 24*3600, "/admin", "", false, true)
-	
 	fmt.Printf("DEBUG: Admin login successful for user %d (%s)\n", user.ID, user.Name)
 	c.Redirect(http.StatusFound, "/admin/dashboard")
 }
 
 // Logout handler
 func (h *WebAdminHandler) HandleLogout(c *gin.Context) {
-	if sessionID, err := c.Cookie("admin_session"); err == nil {
-		delete(h.sessions, sessionID)
+	if token, err := c.Cookie(adminSessionCookie); err == nil {
+		if err := h.db.Where("token_hash = ?", hashAdminSessionToken(token)).Delete(&models.AdminWebSession{}).Error; err != nil {
+			fmt.Printf("ERROR: Failed to delete admin session: %v\n", err)
+		}
 	}
 	
-	c.SetCookie("admin_session", "", -1, "/admin", "", false, true)
+	h.clearSessionCookie(c)
 	c.Redirect(http.StatusFound, "/admin/login")
 }
 
 // Middleware to protect admin routes
 func (h *WebAdminHandler) RequireAdminSession() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sessionID, err := c.Cookie("admin_session")
+		token, err := c.Cookie(adminSessionCookie)
 		if err != nil {
 			c.Redirect(http.StatusFound, "/admin/login")
 			c.Abort()
 			return
 		}
 		
-		session, exists := h.sessions[sessionID]
-		if !exists || session.ExpiresAt.Before(time.Now()) {
-			delete(h.sessions, sessionID)
+		session, err := h.lookupSession(token)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				fmt.Printf("ERROR: Failed to look up admin session: %v\n", err)
+			}
+			h.clearSessionCookie(c)
 			c.Redirect(http.StatusFound, "/admin/login?error=Session expired")
 			c.Abort()
 			return
 		}
 		
+		h.renewSession(c, token, session)
+		
 		// Store user info in context
+		c.Set("admin_session_id", session.ID)
 		c.Set("admin_user_id", session.UserID)
 		c.Set("admin_username", session.Username)
+		c.Set("admin_csrf_token", adminCSRFToken(session))
 		c.Next()
 	}
 }
@@ -1156,6 +1154,12 @@ func (h *WebAdminHandler) ShowDashboard(c *gin.Context) {
                 <a class="nav-link" href="/admin/logout">
                     <i class="bi bi-box-arrow-right"></i> Logout
                 </a>
+                <form method="POST" action="/admin/logout-all" class="d-inline">
+                    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+                    <button type="submit" class="nav-link btn btn-link" title="Log out on all devices">
+                        <i class="bi bi-door-closed"></i> Logout everywhere
+                    </button>
+                </form>
             </div>
         </div>
     </nav>
@@ -1575,7 +1579,7 @@ func (h *WebAdminHandler) ShowDashboard(c *gin.Context) {
 
 	tmpl, _ := template.New("dashboard").Parse(dashboardHTML)
 	c.Header("Content-Type", "text/html")
-	tmpl.Execute(c.Writer, gin.H{"Username": username})
+	tmpl.Execute(c.Writer, gin.H{"Username": username, "CSRFToken": c.GetString("admin_csrf_token")})
 }
 
 // Web Admin API endpoints (using session authentication)
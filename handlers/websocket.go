@@ -11,9 +11,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 
+	"github.com/modernland/golang-live-tracking/middleware"
 	"github.com/modernland/golang-live-tracking/models"
 	"github.com/modernland/golang-live-tracking/utils"
 )
@@ -33,8 +33,15 @@ type WebSocketMessage struct {
 
 type TrainUpdate struct {
 	TrainNumber     string                 `json:"trainNumber"`
+	TrainName       string                 `json:"trainName,omitempty"`
+	Relation        *string                `json:"relation,omitempty"`
+	TrainType       *string                `json:"trainType,omitempty"`
+	OperationalRoute *models.OperationalRouteSummary `json:"operationalRoute,omitempty"`
 	PassengerCount  int                    `json:"passengerCount"`
 	AveragePosition models.Position        `json:"averagePosition"`
+	ConfidenceRadius *float64              `json:"confidenceRadius,omitempty"` // Meters around averagePosition
+	RouteMatch      *models.RouteMatch     `json:"routeMatch,omitempty"`       // averagePosition snapped onto the route
+	Delay           *models.TrainDelay     `json:"delay,omitempty"`            // Live delay and ETAs against the timetable
 	AverageSpeed    *float64               `json:"averageSpeed,omitempty"` // NEW: Average speed in km/h
 	Passengers      []models.Passenger     `json:"passengers"`
 	LastUpdate      string                 `json:"lastUpdate"`
@@ -48,20 +55,44 @@ type TrainUpdate struct {
 type WebSocketHandler struct {
 	db     *gorm.DB
 	s3     *utils.S3Client
-	redis  *redis.Client // Redis client for real-time data
+	store  LiveStore // live tracking state (Redis, S3 train files or in-memory)
 	clients map[*websocket.Conn]bool
 	mutex   sync.RWMutex
 	// Cache for user and station data (key: userID)
 	userCache map[uint]*UserStationCache
 	cacheMutex sync.RWMutex
+	// Cache for trains table rows (key: trainID)
+	trainCache      map[uint]*models.Train
+	trainCacheMutex sync.RWMutex
+	// Route geometry for map-matching train positions
+	routes *routeMatcher
+	// Trains changed by live events since the last push (live event fan-out)
+	dirtyTrains map[string]bool
+	dirtyMutex  sync.Mutex
+	// Stops the fan-out workers of the current live store
+	liveEventsCancel context.CancelFunc
+	liveEventsMutex  sync.Mutex
+	// Serializes writes to client connections across broadcasters
+	broadcastMutex sync.Mutex
+	// Archived history for replay (nil when disabled), running replays and last replay start per connection
+	archive       *TrainArchiver
+	replays       map[*websocket.Conn]context.CancelFunc
+	replayStarted map[*websocket.Conn]time.Time
+	replayMutex   sync.Mutex
 }
 
 func NewWebSocketHandler(db *gorm.DB, s3Client *utils.S3Client) *WebSocketHandler {
 	handler := &WebSocketHandler{
 		db:        db,
 		s3:        s3Client,
+		store:     NewS3LiveStore(s3Client),
 		clients:   make(map[*websocket.Conn]bool),
 		userCache: make(map[uint]*UserStationCache),
+		trainCache: make(map[uint]*models.Train),
+		routes:    newRouteMatcher(db),
+		dirtyTrains: make(map[string]bool),
+		replays:     make(map[*websocket.Conn]context.CancelFunc),
+		replayStarted: make(map[*websocket.Conn]time.Time),
 	}
 	
 	// Start background goroutine to broadcast updates
@@ -70,10 +101,35 @@ func NewWebSocketHandler(db *gorm.DB, s3Client *utils.S3Client) *WebSocketHandle
 	return handler
 }
 
-// SetRedisClient sets the Redis client for real-time WebSocket data
-func (h *WebSocketHandler) SetRedisClient(redisClient *redis.Client) {
-	h.redis = redisClient
-	fmt.Printf("INFO: Redis client enabled for WebSocket handler (real-time updates)\n")
+// SetLiveStore sets where live tracking state is read from (defaults to the legacy S3 train files)
+func (h *WebSocketHandler) SetLiveStore(store LiveStore) {
+	h.liveEventsMutex.Lock()
+	defer h.liveEventsMutex.Unlock()
+
+	// Only one set of fan-out workers, for the current store
+	if h.liveEventsCancel != nil {
+		h.liveEventsCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.liveEventsCancel = cancel
+
+	h.store = store
+	fmt.Printf("INFO: %s live store enabled for WebSocket handler (real-time updates)\n", store.Name())
+
+	// Push changes from every instance as they happen
+	go h.subscribeLiveEvents(ctx, store)
+	go h.flushLiveEvents(ctx)
+}
+
+// StopLiveEvents stops the live event fan-out workers for shutdown
+func (h *WebSocketHandler) StopLiveEvents() {
+	h.liveEventsMutex.Lock()
+	defer h.liveEventsMutex.Unlock()
+
+	if h.liveEventsCancel != nil {
+		h.liveEventsCancel()
+		h.liveEventsCancel = nil
+	}
 }
 
 // getUserWithStation gets user data with station lookup, using cache for efficiency
@@ -123,29 +179,12 @@ func (h *WebSocketHandler) getUserWithStation(userID uint) *UserStationCache {
 	return userCache
 }
 
-// getTrainDataFromRedis gets train data from Redis with S3 failover for WebSocket
-func (h *WebSocketHandler) getTrainDataFromRedis(trainNumber string) (*models.TrainData, error) {
-	if h.redis != nil {
-		// Try Redis first (real-time data)
-		ctx := context.Background()
-		trainKey := fmt.Sprintf("train_live:%s", trainNumber)
-		trainDataStr, err := h.redis.Get(ctx, trainKey).Result()
-		
-		if err == nil {
-			var trainData models.TrainData
-			if err := json.Unmarshal([]byte(trainDataStr), &trainData); err == nil {
-				return &trainData, nil
-			}
-		}
-	}
-	
-	// Fallback to S3 if Redis fails or data not found
-	fileName := fmt.Sprintf("trains/train-%s.json", trainNumber)
-	return h.s3.GetTrainData(fileName)
-}
-
 // HandleWebSocket - WebSocket endpoint for real-time train updates
 func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
+	// Live updates are public; replay needs an admin token (see OptionalSanctumAuth)
+	user, authenticated := middleware.GetUserFromContext(c)
+	isAdmin := authenticated && user.Role == "admin"
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
@@ -176,12 +215,13 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		if messageType == websocket.TextMessage {
 			var msg WebSocketMessage
 			if err := json.Unmarshal(message, &msg); err == nil {
-				h.handleClientMessage(conn, &msg)
+				h.handleClientMessage(conn, &msg, isAdmin)
 			}
 		}
 	}
 
 	// Remove client on disconnect
+	h.forgetReplayClient(conn)
 	h.mutex.Lock()
 	delete(h.clients, conn)
 	clientCount = len(h.clients)
@@ -204,7 +244,7 @@ func (h *WebSocketHandler) sendInitialData(conn *websocket.Conn) {
 	}
 }
 
-func (h *WebSocketHandler) handleClientMessage(conn *websocket.Conn, msg *WebSocketMessage) {
+func (h *WebSocketHandler) handleClientMessage(conn *websocket.Conn, msg *WebSocketMessage, isAdmin bool) {
 	switch msg.Type {
 	case "ping":
 		// Respond with pong
@@ -212,7 +252,7 @@ func (h *WebSocketHandler) handleClientMessage(conn *websocket.Conn, msg *WebSoc
 			Type: "pong",
 			Data: map[string]interface{}{"timestamp": time.Now().Unix()},
 		}
-		conn.WriteJSON(response)
+		h.writeToClient(conn, response)
 		
 	case "subscribe_train":
 		// Handle train-specific subscription
@@ -221,12 +261,18 @@ func (h *WebSocketHandler) handleClientMessage(conn *websocket.Conn, msg *WebSoc
 			// Send current train data
 			h.sendTrainData(conn, trainNumber)
 		}
+
+	case "replay":
+		// Play archived history of a train back as train_updates
+		h.startReplay(conn, msg.Data, isAdmin)
+
+	case "replay_stop":
+		h.stopReplay(conn, true)
 	}
 }
 
 func (h *WebSocketHandler) sendTrainData(conn *websocket.Conn, trainNumber string) {
-	fileName := fmt.Sprintf("trains/train-%s.json", trainNumber)
-	trainData, err := h.s3.GetTrainData(fileName)
+	trainData, err := getLiveTrainData(h.store, h.s3, trainNumber)
 	if err != nil {
 		return // Train not found
 	}
@@ -236,7 +282,7 @@ func (h *WebSocketHandler) sendTrainData(conn *websocket.Conn, trainNumber strin
 		Data: trainData,
 	}
 
-	conn.WriteJSON(message)
+	h.writeToClient(conn, message)
 }
 
 // Background goroutine to broadcast updates every 5 seconds
@@ -260,6 +306,15 @@ func (h *WebSocketHandler) broadcastTrainUpdates() {
 	}
 	h.mutex.RUnlock()
 
+	// The live store already lists every train with live data - no per-tick DB query
+	if h.store.TracksSessions() {
+		err := h.broadcastTrainUpdatesFromStore()
+		if err == nil {
+			return
+		}
+		log.Printf("WebSocket: Failed to read live trains from %s live store, falling back to database: %v", h.store.Name(), err)
+	}
+
 	// Get active sessions directly from database (single source of truth)
 	var sessions []models.LiveTrackingSession
 	result := h.db.Where("status = ?", "active").Find(&sessions)
@@ -290,15 +345,11 @@ func (h *WebSocketHandler) broadcastTrainUpdates() {
 	// Prepare train updates from database sessions
 	var updates []TrainUpdate
 	for trainNumber, trainSessionList := range trainSessions {
-		// Get detailed train data from Redis first, then S3 fallback (real-time data)
-		trainData, err := h.getTrainDataFromRedis(trainNumber)
+		// Get detailed train data from the live store first, then S3 fallback (real-time data)
+		trainData, err := getLiveTrainData(h.store, h.s3, trainNumber)
 		if err != nil {
-			// If Redis and S3 both fail but we have active sessions, create basic update from database
-			if h.redis != nil {
-				log.Printf("WebSocket: Redis and S3 data missing for train %s, creating from database", trainNumber)
-			} else {
-				log.Printf("WebSocket: S3 file missing for train %s, creating from database", trainNumber)
-			}
+			// If the live store and S3 both fail but we have active sessions, create basic update from database
+			log.Printf("WebSocket: %s live store and S3 data missing for train %s, creating from database", h.store.Name(), trainNumber)
 			update := h.createUpdateFromDatabaseSessions(trainNumber, trainSessionList)
 			if update != nil {
 				updates = append(updates, *update)
@@ -336,47 +387,7 @@ func (h *WebSocketHandler) broadcastTrainUpdates() {
 			continue
 		}
 
-		// Calculate average position and speed from active passengers
-		var totalLat, totalLng float64
-		var totalSpeed float64
-		var speedCount int
-		
-		for _, passenger := range activePassengers {
-			totalLat += passenger.Lat
-			totalLng += passenger.Lng
-			
-			// Include speed in average calculation if available
-			if passenger.Speed != nil && *passenger.Speed >= 0 {
-				totalSpeed += *passenger.Speed
-				speedCount++
-			}
-		}
-		
-		avgPosition := models.Position{
-			Lat: totalLat / float64(len(activePassengers)),
-			Lng: totalLng / float64(len(activePassengers)),
-		}
-		
-		// Calculate average speed (only if we have speed data from passengers)
-		var avgSpeed *float64
-		if speedCount > 0 {
-			calculatedAvgSpeed := totalSpeed / float64(speedCount)
-			avgSpeed = &calculatedAvgSpeed
-		}
-
-		update := TrainUpdate{
-			TrainNumber:     trainNumber,
-			PassengerCount:  len(activePassengers),
-			AveragePosition: avgPosition,
-			AverageSpeed:    avgSpeed, // NEW: Include average speed
-			Passengers:      activePassengers,
-			LastUpdate:      time.Now().Format(time.RFC3339),
-			Status:          "active",
-			Route:           trainData.Route,
-			DataSource:      "database-driven-websocket",
-		}
-
-		updates = append(updates, update)
+		updates = append(updates, h.buildTrainUpdate(trainNumber, trainData, activePassengers, "database-driven-websocket"))
 	}
 
 	// Broadcast to all clients
@@ -391,8 +402,61 @@ func (h *WebSocketHandler) broadcastTrainUpdates() {
 	}
 }
 
+// buildTrainUpdate aggregates a train's passengers into the update sent to WebSocket clients
+func (h *WebSocketHandler) buildTrainUpdate(trainNumber string, trainData *models.TrainData, activePassengers []models.Passenger, dataSource string) TrainUpdate {
+	// Calculate average position and speed from active passengers
+	var totalSpeed float64
+	var speedCount int
+	
+	for _, passenger := range activePassengers {
+		// Include speed in average calculation if available
+		if passenger.Speed != nil && *passenger.Speed >= 0 {
+			totalSpeed += *passenger.Speed
+			speedCount++
+		}
+	}
+	
+	avgPosition := trainData.AveragePosition
+	confidenceRadius := trainData.ConfidenceRadius
+	routeMatch := trainData.RouteMatch
+	if estimate := aggregateTrainPosition(activePassengers); estimate != nil {
+		avgPosition = estimate.Position
+		confidenceRadius = &estimate.ConfidenceRadiusM
+		routeMatch = h.routes.match(trainNumber, estimate.Position)
+	}
+	
+	// Calculate average speed (only if we have speed data from passengers)
+	var avgSpeed *float64
+	if speedCount > 0 {
+		calculatedAvgSpeed := totalSpeed / float64(speedCount)
+		avgSpeed = &calculatedAvgSpeed
+	}
+
+	return TrainUpdate{
+		TrainNumber:     trainNumber,
+		PassengerCount:  len(activePassengers),
+		AveragePosition: avgPosition,
+		ConfidenceRadius: confidenceRadius,
+		RouteMatch:      routeMatch,
+		Delay:           h.routes.estimateDelay(trainNumber, routeMatch, time.Now()),
+		AverageSpeed:    avgSpeed, // NEW: Include average speed
+		Passengers:      activePassengers,
+		LastUpdate:      time.Now().Format(time.RFC3339),
+		Status:          "active",
+		Route:           trainData.Route,
+		TrainName:       trainData.TrainName,
+		Relation:        trainData.Relation,
+		TrainType:       trainData.TrainType,
+		OperationalRoute: trainData.OperationalRoute,
+		DataSource:      dataSource,
+	}
+}
+
 // Helper method to broadcast messages to all clients
 func (h *WebSocketHandler) broadcastToClients(message WebSocketMessage) {
+	h.broadcastMutex.Lock()
+	defer h.broadcastMutex.Unlock()
+
 	h.mutex.RLock()
 	for conn := range h.clients {
 		if err := conn.WriteJSON(message); err != nil {
@@ -446,15 +510,44 @@ func (h *WebSocketHandler) createUpdateFromDatabaseSessions(trainNumber string,
 		return nil
 	}
 
+	// Same train metadata as the live store payloads (no route when the train is unknown)
+	var metadata models.TrainData
+	applyTrainMetadata(&metadata, h.getTrain(sessions[0].TrainID), h.routes)
+
 	return &TrainUpdate{
-		TrainNumber:    trainNumber,
-		PassengerCount: len(passengers),
-		Passengers:     passengers,
-		LastUpdate:     time.Now().Format(time.RFC3339),
-		Status:         "active",
-		Route:          fmt.Sprintf("Route for train %s", trainNumber),
-		DataSource:     "database-only-fallback",
+		TrainNumber:      trainNumber,
+		TrainName:        metadata.TrainName,
+		Relation:         metadata.Relation,
+		TrainType:        metadata.TrainType,
+		OperationalRoute: metadata.OperationalRoute,
+		PassengerCount:   len(passengers),
+		Passengers:       passengers,
+		LastUpdate:       time.Now().Format(time.RFC3339),
+		Status:           "active",
+		Route:            metadata.Route,
+		DataSource:       "database-only-fallback",
+	}
+}
+
+// getTrain gets a train from the trains table, using cache for efficiency
+func (h *WebSocketHandler) getTrain(trainID uint) *models.Train {
+	h.trainCacheMutex.RLock()
+	if cached, exists := h.trainCache[trainID]; exists {
+		h.trainCacheMutex.RUnlock()
+		return cached
 	}
+	h.trainCacheMutex.RUnlock()
+
+	var train models.Train
+	if err := h.db.First(&train, trainID).Error; err != nil {
+		return nil
+	}
+
+	h.trainCacheMutex.Lock()
+	h.trainCache[trainID] = &train
+	h.trainCacheMutex.Unlock()
+
+	return &train
 }
 
 // generateInitialDataFromDatabase - Generate WebSocket initial data from database
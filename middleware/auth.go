@@ -127,6 +127,24 @@ func (am *AuthMiddleware) SanctumAuth() gin.HandlerFunc {
 	}
 }
 
+// OptionalSanctumAuth authenticates like SanctumAuth when a token is sent and lets requests
+// without one through anonymously. Browsers can't set headers on WebSocket connections, so the
+// token may also be passed as ?token=.
+func (am *AuthMiddleware) OptionalSanctumAuth() gin.HandlerFunc {
+	sanctumAuth := am.SanctumAuth()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			token := c.Query("token")
+			if token == "" {
+				c.Next()
+				return
+			}
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		sanctumAuth(c)
+	}
+}
+
 // GetUserFromContext retrieves the authenticated user from Gin context
 func GetUserFromContext(c *gin.Context) (*models.User, bool) {
 	user, exists := c.Get("user")
@@ -55,9 +55,16 @@ type LiveSession struct {
 // TrainData represents the JSON structure stored in S3
 type TrainData struct {
 	TrainID         string         `json:"trainId"`
+	TrainName       string         `json:"trainName,omitempty"`
+	Relation        *string        `json:"relation,omitempty"`
+	TrainType       *string        `json:"trainType,omitempty"`
 	Route           string         `json:"route"`
+	OperationalRoute *OperationalRouteSummary `json:"operationalRoute,omitempty"`
 	PassengerCount  int           `json:"passengerCount"`
 	AveragePosition Position      `json:"averagePosition"`
+	ConfidenceRadius *float64     `json:"confidenceRadius,omitempty"` // Meters around averagePosition
+	RouteMatch      *RouteMatch   `json:"routeMatch,omitempty"`       // averagePosition snapped onto the route
+	Delay           *TrainDelay   `json:"delay,omitempty"`            // Computed on read, not stored
 	Passengers      []Passenger   `json:"passengers"`
 	LastUpdate      string        `json:"lastUpdate"`
 	Status          string        `json:"status"`
@@ -69,6 +76,52 @@ type Position struct {
 	Lng float64 `json:"lng"`
 }
 
+// OperationalRouteSummary identifies the operational route a train runs on
+type OperationalRouteSummary struct {
+	ID   uint    `json:"id"`
+	Code *string `json:"code,omitempty"`
+	Name string  `json:"name"`
+}
+
+// RouteMatch is a train position snapped onto its operational route geometry
+type RouteMatch struct {
+	OperationalRouteID      uint          `json:"operationalRouteId"`
+	SnappedPosition         Position      `json:"snappedPosition"`
+	ChainageKm              float64       `json:"chainageKm"`         // Distance along the route from the train's origin
+	DistanceFromRouteM      float64       `json:"distanceFromRouteM"` // Distance between averagePosition and snappedPosition
+	PreviousStation         *RouteStation `json:"previousStation,omitempty"`
+	NextStation             *RouteStation `json:"nextStation,omitempty"`
+	DistanceToNextStationKm *float64      `json:"distanceToNextStationKm,omitempty"`
+}
+
+// TrainDelay is a train's live delay against its timetable
+type TrainDelay struct {
+	DelayMinutes   int       `json:"delayMinutes"`   // Positive = late, negative = early
+	ServiceDate    string    `json:"serviceDate"`    // Date the train left its origin (YYYY-MM-DD)
+	EstimatedAt    string    `json:"estimatedAt"`
+	RemainingStops []StopETA `json:"remainingStops"`
+}
+
+// StopETA is the scheduled and estimated time at a stop still ahead of the train (RFC3339)
+type StopETA struct {
+	StationID          uint    `json:"stationId"`
+	StationName        string  `json:"stationName"`
+	StopSequence       int     `json:"stopSequence"`
+	IsPassThrough      bool    `json:"isPassThrough"`
+	ScheduledArrival   *string `json:"scheduledArrival,omitempty"`
+	EstimatedArrival   *string `json:"estimatedArrival,omitempty"`
+	ScheduledDeparture *string `json:"scheduledDeparture,omitempty"`
+	EstimatedDeparture *string `json:"estimatedDeparture,omitempty"`
+}
+
+// RouteStation is a scheduled station placed along a train's route
+type RouteStation struct {
+	StationID   uint    `json:"stationId"`
+	StationCode string  `json:"stationCode"`
+	StationName string  `json:"stationName"`
+	ChainageKm  float64 `json:"chainageKm"`
+}
+
 // UserStatus represents a passenger's current status message
 type UserStatus struct {
 	Emoji     string `json:"emoji"`
@@ -92,6 +145,7 @@ type Passenger struct {
 	Heading     *float64    `json:"heading,omitempty"`
 	Altitude    *float64    `json:"altitude,omitempty"`
 	SessionStatus string    `json:"sessionStatus"`           // Session status: "active", "inactive", etc.
+	QualityFlag string      `json:"qualityFlag,omitempty"`   // GPS quality flag (e.g. "low_accuracy"), excluded from averagePosition
 	UserStatus  *UserStatus `json:"status,omitempty"`        // User's custom status with emoji and message
 }
 
@@ -112,6 +166,11 @@ type Trip struct {
 	MinElevationM        int                    `json:"min_elevation_m"`
 	ElevationGainM       int                    `json:"elevation_gain_m"`
 	DurationSeconds      int                    `json:"duration_seconds"`
+	ElevationLossM       int                    `json:"elevation_loss_m"`
+	MovingTimeSeconds    int                    `json:"moving_time_seconds"`
+	StoppedTimeSeconds   int                    `json:"stopped_time_seconds"`
+	AvgMovingSpeedKmh    float64                `json:"avg_moving_speed_kmh"`
+	StopCount            int                    `json:"stop_count"`
 	StartLatitude        float64                `json:"start_latitude"`
 	StartLongitude       float64                `json:"start_longitude"`
 	EndLatitude          float64                `json:"end_latitude"`
@@ -121,7 +180,15 @@ type Trip struct {
 	MaxElevationLat      *float64               `json:"max_elevation_lat"`
 	MaxElevationLng      *float64               `json:"max_elevation_lng"`
 	TrackingData         interface{}            `json:"tracking_data" gorm:"type:json"`
+	TrackingDataKey      *string                `json:"tracking_data_key" gorm:"size:255"` // S3 object holding tracking_data when moved out of MySQL
 	RouteCoordinates     interface{}            `json:"route_coordinates" gorm:"type:json"`
+	// Summary reported by the app, kept next to the server-calculated statistics above
+	ClientDistanceKm      *float64              `json:"client_distance_km"`
+	ClientMaxSpeedKmh     *float64              `json:"client_max_speed_kmh"`
+	ClientAvgSpeedKmh     *float64              `json:"client_avg_speed_kmh"`
+	ClientDurationSeconds *int                  `json:"client_duration_seconds"`
+	StatsFlagged          bool                  `json:"stats_flagged" gorm:"default:false"`
+	StatsFlagReason       *string               `json:"stats_flag_reason" gorm:"size:255"`
 	FromStationID        *uint                  `json:"from_station_id"`
 	FromStationName      *string                `json:"from_station_name"`
 	ToStationID          *uint                  `json:"to_station_id"`
@@ -169,6 +236,24 @@ func (LiveTrackingSession) TableName() string {
 	return "live_tracking_sessions"
 }
 
+// AdminWebSession is a web admin login, shared by all instances. Only a hash of the
+// cookie token is stored, so the table can't be used to hijack sessions.
+type AdminWebSession struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	TokenHash  string    `json:"-" gorm:"size:64;uniqueIndex"`
+	UserID     uint      `json:"user_id" gorm:"index"`
+	Username   string    `json:"username"`
+	IPAddress  string    `json:"ip_address" gorm:"size:64"`
+	UserAgent  string    `json:"user_agent" gorm:"size:512"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"index"`
+}
+
+func (AdminWebSession) TableName() string {
+	return "admin_web_sessions"
+}
+
 // Station model matching Laravel stations table
 type Station struct {
 	StationID   uint     `json:"station_id" gorm:"primaryKey"`
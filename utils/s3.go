@@ -3,18 +3,30 @@ package utils
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/modernland/golang-live-tracking/models"
 )
 
+var (
+	// ErrNotFound is returned when the object doesn't exist
+	ErrNotFound = errors.New("object not found")
+	// ErrPreconditionFailed is returned when a conditional write finds the object changed by another writer
+	ErrPreconditionFailed = errors.New("object was changed by another writer")
+)
+
 type S3Client struct {
-	client   *s3.S3
-	bucket   string
+	client      *s3.S3
+	bucket      string
+	compression string // compression of JSON uploads, see SetCompression
 }
 
 func NewS3Client(accessKey, secretKey, region, bucket, endpoint string) *S3Client {
@@ -27,25 +39,19 @@ func NewS3Client(accessKey, secretKey, region, bucket, endpoint string) *S3Clien
 	}))
 
 	return &S3Client{
-		client: s3.New(sess),
-		bucket: bucket,
+		client:      s3.New(sess),
+		bucket:      bucket,
+		compression: CompressionNone,
 	}
 }
 
 func (s *S3Client) UploadJSON(key string, data interface{}) error {
-	jsonData, err := json.Marshal(data)
+	// Use AWS SDK to put object
+	input, err := s.jsonPutInput(key, data)
 	if err != nil {
 		return err
 	}
 
-	// Use AWS SDK to put object
-	input := &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(jsonData),
-		ContentType: aws.String("application/json"),
-	}
-
 	_, err = s.client.PutObject(input)
 	if err != nil {
 		return fmt.Errorf("failed to upload to S3: %v", err)
@@ -56,6 +62,12 @@ func (s *S3Client) UploadJSON(key string, data interface{}) error {
 }
 
 func (s *S3Client) GetTrainData(key string) (*models.TrainData, error) {
+	trainData, _, err := s.GetTrainDataWithETag(key)
+	return trainData, err
+}
+
+// GetTrainDataWithETag reads a train file together with its ETag, for a later UploadJSONIfMatch
+func (s *S3Client) GetTrainDataWithETag(key string) (*models.TrainData, string, error) {
 	// Use AWS SDK to get object
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -64,7 +76,7 @@ func (s *S3Client) GetTrainData(key string) (*models.TrainData, error) {
 
 	result, err := s.client.GetObject(input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get from S3: %v", err)
+		return nil, "", fmt.Errorf("failed to get from S3: %w", classifyS3Error(err))
 	}
 	defer result.Body.Close()
 
@@ -72,15 +84,123 @@ func (s *S3Client) GetTrainData(key string) (*models.TrainData, error) {
 	buf := new(bytes.Buffer)
 	_, err = buf.ReadFrom(result.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	body, err := decodeObjectBody(buf.Bytes())
+	if err != nil {
+		return nil, "", err
 	}
 
 	var trainData models.TrainData
-	if err := json.Unmarshal(buf.Bytes(), &trainData); err != nil {
-		return nil, err
+	if err := json.Unmarshal(body, &trainData); err != nil {
+		return nil, "", err
+	}
+
+	return &trainData, aws.StringValue(result.ETag), nil
+}
+
+// UploadJSONIfMatch writes the object only if it still has the given ETag, or only if it
+// doesn't exist yet when etag is empty. Returns the new ETag, or ErrPreconditionFailed when
+// another writer got there first.
+func (s *S3Client) UploadJSONIfMatch(key string, data interface{}, etag string) (string, error) {
+	input, err := s.jsonPutInput(key, data)
+	if err != nil {
+		return "", err
+	}
+
+	return s.putIfMatch(input, etag)
+}
+
+// UploadIfMatch writes raw bytes (never compressed) with the same conditions as UploadJSONIfMatch
+func (s *S3Client) UploadIfMatch(key string, data []byte, contentType string, etag string) (string, error) {
+	return s.putIfMatch(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	}, etag)
+}
+
+func (s *S3Client) putIfMatch(input *s3.PutObjectInput, etag string) (string, error) {
+	req, result := s.client.PutObjectRequest(input)
+	if etag != "" {
+		setRequestHeader(req, "If-Match", etag)
+	} else {
+		setRequestHeader(req, "If-None-Match", "*")
+	}
+
+	if err := req.Send(); err != nil {
+		return "", fmt.Errorf("failed to upload to S3: %w", classifyS3Error(err))
+	}
+
+	return aws.StringValue(result.ETag), nil
+}
+
+// setRequestHeader sets a header once the HTTP request is built. The pinned SDK has no fields
+// for If-Match/If-None-Match on PutObjectInput and DeleteObjectInput.
+func setRequestHeader(req *request.Request, name, value string) {
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(name, value)
+	})
+}
+
+// GetObjectWithETag reads an object's raw bytes (never decompressed) together with its ETag
+func (s *S3Client) GetObjectWithETag(key string) ([]byte, string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := s.client.GetObject(input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get from S3: %w", classifyS3Error(err))
+	}
+	defer result.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(result.Body); err != nil {
+		return nil, "", err
 	}
 
-	return &trainData, nil
+	return buf.Bytes(), aws.StringValue(result.ETag), nil
+}
+
+// DeleteFileIfMatch deletes the object only if it still has the given ETag, returning
+// ErrPreconditionFailed when another writer changed it. A missing object counts as deleted.
+func (s *S3Client) DeleteFileIfMatch(key string, etag string) error {
+	req, _ := s.client.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	setRequestHeader(req, "If-Match", etag)
+
+	if err := req.Send(); err != nil {
+		err = classifyS3Error(err)
+		if errors.Is(err, ErrNotFound) {
+			return nil // Already gone
+		}
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+
+	fmt.Printf("DEBUG: Successfully deleted %s from S3\n", key)
+	return nil
+}
+
+// classifyS3Error maps missing objects and failed preconditions to ErrNotFound and ErrPreconditionFailed
+func classifyS3Error(err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusNotFound:
+			return ErrNotFound
+		case http.StatusPreconditionFailed, http.StatusConflict: // 409 = concurrent conditional write
+			return ErrPreconditionFailed
+		}
+	}
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return ErrNotFound
+	}
+	return err
 }
 
 func (s *S3Client) DeleteFile(key string) error {
@@ -100,23 +220,45 @@ func (s *S3Client) DeleteFile(key string) error {
 }
 
 func (s *S3Client) ListFiles(prefix string) ([]string, error) {
-	// Use AWS SDK to list objects
+	// Use AWS SDK to list objects, following pagination past 1000 keys
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
 		Prefix: aws.String(prefix),
 	}
 
-	result, err := s.client.ListObjectsV2(input)
+	var files []string
+	err := s.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			files = append(files, *obj.Key)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list S3 objects: %v", err)
 	}
 
-	var files []string
-	for _, obj := range result.Contents {
-		files = append(files, *obj.Key)
+	return files, nil
+}
+
+// GetJSON reads a JSON object (compressed or not) into v
+func (s *S3Client) GetJSON(key string, v interface{}) error {
+	_, err := s.GetJSONWithETag(key, v)
+	return err
+}
+
+// GetJSONWithETag reads a JSON object (compressed or not) into v and returns its ETag, for a
+// later UploadJSONIfMatch
+func (s *S3Client) GetJSONWithETag(key string, v interface{}) (string, error) {
+	raw, etag, err := s.GetObjectWithETag(key)
+	if err != nil {
+		return "", err
 	}
 
-	return files, nil
+	body, err := decodeObjectBody(raw)
+	if err != nil {
+		return "", err
+	}
+	return etag, json.Unmarshal(body, v)
 }
 
 func (s *S3Client) GetJSONData(key string) (map[string]interface{}, error) {
@@ -139,8 +281,13 @@ func (s *S3Client) GetJSONData(key string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	body, err := decodeObjectBody(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
 	var jsonData map[string]interface{}
-	if err := json.Unmarshal(buf.Bytes(), &jsonData); err != nil {
+	if err := json.Unmarshal(body, &jsonData); err != nil {
 		return nil, err
 	}
 
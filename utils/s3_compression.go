@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Compression of JSON objects written by UploadJSON and UploadJSONIfMatch
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// Object metadata describing how the body is stored
+const (
+	metadataCompression      = "compression"
+	metadataUncompressedSize = "uncompressed-size"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// SetCompression sets the compression of JSON uploads. Reads detect compressed objects on their
+// own, so it can be switched at any time; MigrateCompression converts existing objects.
+func (s *S3Client) SetCompression(compression string) error {
+	switch compression {
+	case "", CompressionNone:
+		s.compression = CompressionNone
+	case CompressionGzip:
+		s.compression = CompressionGzip
+	default:
+		return fmt.Errorf("unsupported S3 compression %q (supported: %s, %s)", compression, CompressionNone, CompressionGzip)
+	}
+	fmt.Printf("INFO: S3 JSON compression: %s\n", s.compression)
+	return nil
+}
+
+// jsonPutInput marshals data and prepares its upload, compressed as configured
+func (s *S3Client) jsonPutInput(key string, data interface{}) (*s3.PutObjectInput, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/json"),
+	}
+	if err := s.setBody(input, jsonData); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// setBody sets the upload's body, compressing it and describing the encoding as configured.
+// With Content-Encoding set, browsers and CDNs reading the object directly decompress it too.
+func (s *S3Client) setBody(input *s3.PutObjectInput, body []byte) error {
+	if s.compression != CompressionGzip {
+		input.Body = bytes.NewReader(body)
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(body); err != nil {
+		return fmt.Errorf("failed to compress %s: %v", aws.StringValue(input.Key), err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to compress %s: %v", aws.StringValue(input.Key), err)
+	}
+
+	input.Body = bytes.NewReader(compressed.Bytes())
+	input.ContentEncoding = aws.String(CompressionGzip)
+	input.Metadata = map[string]*string{
+		metadataCompression:      aws.String(CompressionGzip),
+		metadataUncompressedSize: aws.String(strconv.Itoa(len(body))),
+	}
+	return nil
+}
+
+// decodeObjectBody decompresses a gzip body and returns anything else unchanged. Bodies are
+// detected by content rather than headers, since the HTTP client may already have decoded them.
+func decodeObjectBody(body []byte) ([]byte, error) {
+	if !bytes.HasPrefix(body, gzipMagic) {
+		return body, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress S3 object: %v", err)
+	}
+	defer gzipReader.Close()
+
+	decoded, err := io.ReadAll(gzipReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress S3 object: %v", err)
+	}
+	return decoded, nil
+}
+
+// MigrateCompression rewrites the JSON objects under prefix that aren't stored with the
+// configured compression (compressing them, or decompressing them when compression is off).
+// Writes are conditional, so objects updated concurrently are left to their writer.
+// Returns the number of objects rewritten.
+func (s *S3Client) MigrateCompression(prefix string) (int, error) {
+	keys, err := s.ListFiles(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+
+		// Decide by the stored encoding - the body may arrive already decoded by the HTTP client
+		head, err := s.client.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			if errors.Is(classifyS3Error(err), ErrNotFound) {
+				continue
+			}
+			return migrated, fmt.Errorf("failed to check S3 object: %w", classifyS3Error(err))
+		}
+		compressed := aws.StringValue(head.ContentEncoding) == CompressionGzip
+		if compressed == (s.compression == CompressionGzip) {
+			continue
+		}
+
+		raw, etag, err := s.GetObjectWithETag(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+
+		body, err := decodeObjectBody(raw)
+		if err != nil {
+			fmt.Printf("WARNING: Skipping %s in compression migration: %v\n", key, err)
+			continue
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String("application/json"),
+		}
+		if err := s.setBody(input, body); err != nil {
+			return migrated, err
+		}
+		if _, err := s.putIfMatch(input, etag); err != nil {
+			if errors.Is(err, ErrPreconditionFailed) {
+				continue // Rewritten by a live writer in the meantime
+			}
+			return migrated, err
+		}
+		migrated++
+	}
+
+	fmt.Printf("INFO: S3 compression migration of %s rewrote %d objects as %s\n", prefix, migrated, s.compression)
+	return migrated, nil
+}
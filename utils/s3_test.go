@@ -0,0 +1,127 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/modernland/golang-live-tracking/utils"
+	"github.com/modernland/golang-live-tracking/utils/s3test"
+)
+
+func TestUploadJSONIfMatch(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	// Empty ETag: create only if missing
+	etag, err := client.UploadJSONIfMatch("trains/train-1.json", map[string]int{"v": 1}, "")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := client.UploadJSONIfMatch("trains/train-1.json", map[string]int{"v": 2}, ""); !errors.Is(err, utils.ErrPreconditionFailed) {
+		t.Fatalf("second create: got %v, want ErrPreconditionFailed", err)
+	}
+
+	// Matching ETag replaces, the old ETag is stale afterwards
+	newETag, err := client.UploadJSONIfMatch("trains/train-1.json", map[string]int{"v": 3}, etag)
+	if err != nil {
+		t.Fatalf("update with current ETag failed: %v", err)
+	}
+	if newETag == etag {
+		t.Fatalf("ETag didn't change on update")
+	}
+	if _, err := client.UploadJSONIfMatch("trains/train-1.json", map[string]int{"v": 4}, etag); !errors.Is(err, utils.ErrPreconditionFailed) {
+		t.Fatalf("update with stale ETag: got %v, want ErrPreconditionFailed", err)
+	}
+
+	var stored map[string]int
+	if err := client.GetJSON("trains/train-1.json", &stored); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if stored["v"] != 3 {
+		t.Fatalf("stored v = %d, want 3", stored["v"])
+	}
+}
+
+func TestUploadJSONIfMatchCompressed(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	client := server.Client()
+	if err := client.SetCompression(utils.CompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.UploadJSONIfMatch("trains/train-2.json", map[string]string{"train": "2"}, ""); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	raw, _ := server.Object("trains/train-2.json")
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Fatalf("object isn't gzip compressed")
+	}
+
+	var stored map[string]string
+	if err := client.GetJSON("trains/train-2.json", &stored); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if stored["train"] != "2" {
+		t.Fatalf("stored train = %q, want 2", stored["train"])
+	}
+}
+
+func TestDeleteFileIfMatch(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	etag, err := client.UploadIfMatch("trains/train-3.json", []byte(`{}`), "application/json", "")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := client.UploadIfMatch("trains/train-3.json", []byte(`{"changed":true}`), "application/json", etag); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	// A concurrent update makes the delete fail and keeps the object
+	if err := client.DeleteFileIfMatch("trains/train-3.json", etag); !errors.Is(err, utils.ErrPreconditionFailed) {
+		t.Fatalf("delete with stale ETag: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, ok := server.Object("trains/train-3.json"); !ok {
+		t.Fatalf("object was deleted despite the stale ETag")
+	}
+
+	_, currentETag, err := client.GetObjectWithETag("trains/train-3.json")
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if err := client.DeleteFileIfMatch("trains/train-3.json", currentETag); err != nil {
+		t.Fatalf("delete with current ETag failed: %v", err)
+	}
+	if _, ok := server.Object("trains/train-3.json"); ok {
+		t.Fatalf("object still exists after delete")
+	}
+
+	// Already gone counts as deleted
+	if err := client.DeleteFileIfMatch("trains/train-3.json", currentETag); err != nil {
+		t.Fatalf("delete of missing object: %v", err)
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	for _, key := range []string{"trains/train-1.json", "trains/train-2.json", "archive/x.ndjson.gz"} {
+		if err := client.UploadJSON(key, map[string]string{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := client.ListFiles("trains/")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(files) != 2 || files[0] != "trains/train-1.json" || files[1] != "trains/train-2.json" {
+		t.Fatalf("files = %v", files)
+	}
+}
@@ -0,0 +1,197 @@
+// Package s3test provides an in-memory S3 endpoint for tests. It implements the requests the
+// utils.S3Client sends (path-style PUT, GET, HEAD, DELETE and ListObjectsV2) including the
+// If-Match / If-None-Match conditions, so conditional writes behave as on a real bucket.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/modernland/golang-live-tracking/utils"
+)
+
+type object struct {
+	body    []byte
+	etag    string
+	headers http.Header // Content-Type, Content-Encoding and x-amz-meta-*
+}
+
+// Server is an in-memory bucket served over HTTP
+type Server struct {
+	URL    string
+	Bucket string
+
+	server  *httptest.Server
+	mutex   sync.Mutex
+	objects map[string]object
+	version int
+}
+
+// NewServer starts an empty bucket. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Bucket:  "test-bucket",
+		objects: make(map[string]object),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// Client returns an S3Client talking to this server
+func (s *Server) Client() *utils.S3Client {
+	return utils.NewS3Client("test", "test", "us-east-1", s.Bucket, s.URL)
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Object returns the stored bytes of key
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	obj, ok := s.objects[key]
+	return obj.body, ok
+}
+
+// Keys returns the stored keys in order
+func (s *Server) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if key == "" && r.Method == http.MethodGet {
+		s.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	existing, exists := s.objects[key]
+	if status, code := checkConditions(r, existing, exists); status != 0 {
+		writeError(w, status, code)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.version++
+		obj := object{
+			body:    body,
+			etag:    fmt.Sprintf("\"%x-%d\"", md5.Sum(body), s.version),
+			headers: http.Header{},
+		}
+		for name, values := range r.Header {
+			if name == "Content-Type" || name == "Content-Encoding" || strings.HasPrefix(name, "X-Amz-Meta-") {
+				obj.headers[name] = values
+			}
+		}
+		s.objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range existing.headers {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", existing.etag)
+		w.Header().Set("Content-Length", fmt.Sprint(len(existing.body)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(existing.body)
+		}
+
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// checkConditions applies If-Match / If-None-Match like S3: a mismatch fails with 412, and
+// If-Match on a missing object with 404
+func checkConditions(r *http.Request, existing object, exists bool) (int, string) {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !exists {
+			return http.StatusNotFound, "NoSuchKey"
+		}
+		if ifMatch != existing.etag && ifMatch != "*" {
+			return http.StatusPreconditionFailed, "PreconditionFailed"
+		}
+	}
+	if r.Header.Get("If-None-Match") == "*" && exists && r.Method == http.MethodPut {
+		return http.StatusPreconditionFailed, "PreconditionFailed"
+	}
+	return 0, ""
+}
+
+type listResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	IsTruncated bool
+	KeyCount    int
+	Contents    []listEntry
+}
+
+type listEntry struct {
+	Key  string
+	ETag string
+	Size int
+}
+
+func (s *Server) list(w http.ResponseWriter, prefix string) {
+	var result listResult
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, listEntry{Key: key, ETag: obj.etag, Size: len(obj.body)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}